@@ -0,0 +1,220 @@
+package accesslog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Redacted is the value logged in place of a redacted variable
+const Redacted = "[REDACTED]"
+
+// RedactFunc returns the value to log for a variable
+type RedactFunc func(name string, value interface{}) interface{}
+
+// RedactAll redacts every variable value, only the keys are logged
+func RedactAll(name string, value interface{}) interface{} {
+	return Redacted
+}
+
+// RedactNone logs every variable value as is
+func RedactNone(name string, value interface{}) interface{} {
+	return value
+}
+
+// RedactKeys redacts the values of the named variables only
+func RedactKeys(names ...string) RedactFunc {
+	redact := map[string]bool{}
+	for _, name := range names {
+		redact[name] = true
+	}
+
+	return func(name string, value interface{}) interface{} {
+		if redact[name] {
+			return Redacted
+		}
+		return value
+	}
+}
+
+// Options configures the access log. LogFunc defaults to the server
+// LogFunc and Redact defaults to RedactAll. Operations that take longer
+// than a non-zero SlowThreshold are logged at warn level
+type Options struct {
+	LogFunc       logger.LogFunc
+	Redact        RedactFunc
+	SlowThreshold time.Duration
+}
+
+// Logger writes one access log entry per completed operation. A nil
+// logger is valid and logs nothing
+type Logger struct {
+	logFunc       logger.LogFunc
+	redact        RedactFunc
+	slowThreshold time.Duration
+}
+
+// New creates a new access logger
+func New(opts *Options) *Logger {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	l := &Logger{
+		logFunc:       opts.LogFunc,
+		redact:        opts.Redact,
+		slowThreshold: opts.SlowThreshold,
+	}
+
+	if l.logFunc == nil {
+		l.logFunc = logger.NoopLogFunc
+	}
+	if l.redact == nil {
+		l.redact = RedactAll
+	}
+
+	return l
+}
+
+// Entry identifies the transport and client of an operation
+type Entry struct {
+	Transport    string
+	RemoteAddr   string
+	ConnectionID string
+	OperationID  string
+}
+
+// Operation records the access log entry of a single operation
+type Operation struct {
+	mx            sync.Mutex
+	l             *Logger
+	entry         Entry
+	start         time.Time
+	operationName string
+	operationType string
+	queryHash     string
+	variables     map[string]interface{}
+}
+
+type operationKey struct{}
+
+// StartOperation starts recording an operation and adds it to the context
+// so that the request details can be set once it is parsed
+func (l *Logger) StartOperation(ctx context.Context, entry Entry) (context.Context, *Operation) {
+	if l == nil {
+		return ctx, nil
+	}
+
+	o := &Operation{
+		l:     l,
+		entry: entry,
+		start: time.Now(),
+	}
+
+	return context.WithValue(ctx, operationKey{}, o), o
+}
+
+// SetRequest sets the query hash and the redacted variables
+func (o *Operation) SetRequest(query string, variables map[string]interface{}) {
+	if o == nil {
+		return
+	}
+
+	redacted := make(map[string]interface{}, len(variables))
+	for k, v := range variables {
+		redacted[k] = o.l.redact(k, v)
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	o.queryHash = document.Hash(query)
+	o.variables = redacted
+}
+
+// SetOperation sets the operation name and type
+func (o *Operation) SetOperation(op *ast.OperationDefinition) {
+	if o == nil || op == nil {
+		return
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	o.operationType = op.Operation
+	if op.GetName() != nil {
+		o.operationName = op.GetName().Value
+	}
+}
+
+// End writes the access log entry
+func (o *Operation) End(errorCount int) {
+	if o == nil {
+		return
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	duration := time.Since(o.start)
+	fields := map[string]interface{}{
+		"transport":     o.entry.Transport,
+		"operationName": o.operationName,
+		"operationType": o.operationType,
+		"queryHash":     o.queryHash,
+		"variables":     o.variables,
+		"durationMs":    float64(duration) / float64(time.Millisecond),
+		"errorCount":    errorCount,
+		"remoteAddr":    o.entry.RemoteAddr,
+	}
+
+	if o.entry.ConnectionID != "" {
+		fields["connectionId"] = o.entry.ConnectionID
+	}
+	if o.entry.OperationID != "" {
+		fields["operationId"] = o.entry.OperationID
+	}
+
+	payload := logger.LogPayload{
+		Level:   logger.InfoLevel,
+		Fields:  fields,
+		Message: "graphql operation",
+	}
+
+	if o.l.slowThreshold > 0 && duration > o.l.slowThreshold {
+		payload.Level = logger.WarnLevel
+		payload.Message = "slow graphql operation"
+	}
+
+	o.l.logFunc(payload)
+}
+
+// ParseDidStart sets the request details of the operation
+func (l *Logger) ParseDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(doc *ast.Document, err error)) {
+	o, _ := ctx.Value(operationKey{}).(*Operation)
+	o.SetRequest(p.RequestString, p.VariableValues)
+
+	return ctx, func(doc *ast.Document, err error) {
+		if err == nil && doc != nil {
+			op, _ := utils.GetOperationAST(doc, p.OperationName)
+			o.SetOperation(op)
+		}
+	}
+}
+
+// ValidationDidStart implements document.Observer
+func (l *Logger) ValidationDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(errs gqlerrors.FormattedErrors)) {
+	return ctx, func(errs gqlerrors.FormattedErrors) {}
+}
+
+// ExecutionDidStart implements document.Observer
+func (l *Logger) ExecutionDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(result *graphql.Result)) {
+	return ctx, func(result *graphql.Result) {}
+}
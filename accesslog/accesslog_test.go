@@ -0,0 +1,66 @@
+package accesslog_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/graphql-go/graphql"
+)
+
+func TestHTTPAccessLog(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Query: graphql.Fields{
+			"hello": &graphql.Field{
+				Type: graphql.String,
+				Args: graphql.FieldConfigArgument{
+					"name":  &graphql.ArgumentConfig{Type: graphql.String},
+					"token": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return "world", nil
+				},
+			},
+		},
+	})
+
+	payloads := []logger.LogPayload{}
+	srv := server.New(schema, server.WithAccessLog(&accesslog.Options{
+		LogFunc: func(payload logger.LogPayload) {
+			payloads = append(payloads, payload)
+		},
+		Redact: accesslog.RedactKeys("token"),
+	}))
+
+	query := `query Hello($name: String, $token: String) { hello(name: $name, token: $token) }`
+	body := `{"query":"` + query + `","variables":{"name":"foo","token":"secret"}}`
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 access log entry, got %d", len(payloads))
+	}
+
+	fields := payloads[0].Fields
+	if payloads[0].Level != logger.InfoLevel {
+		t.Fatalf("expected info level, got %v", payloads[0].Level)
+	}
+	if fields["operationName"] != "Hello" || fields["operationType"] != "query" {
+		t.Fatalf("unexpected operation fields %v", fields)
+	}
+	if fields["queryHash"] != document.Hash(query) {
+		t.Fatalf("unexpected query hash %v", fields["queryHash"])
+	}
+
+	variables := fields["variables"].(map[string]interface{})
+	if variables["name"] != "foo" || variables["token"] != accesslog.Redacted {
+		t.Fatalf("unexpected variables %v", variables)
+	}
+}
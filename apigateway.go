@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/bhoriuchi/graphql-go-server/ws/apigateway"
+)
+
+// APIGatewayHandler returns a handler for AWS API Gateway websocket events
+// that serves the graphql-transport-ws protocol with the server options
+func (s *Server) APIGatewayHandler(store apigateway.Store, poster apigateway.Poster) *apigateway.Handler {
+	opts := s.options.GraphQLTransportWS
+	if opts == nil {
+		opts = &GraphQLTransportWS{}
+	}
+
+	p := &graphqlTransportWSProtocol{s: s, opts: opts}
+	return apigateway.New(apigateway.Config{
+		Protocol: p.config(&WSConn{}),
+		Store:    store,
+		Poster:   poster,
+	})
+}
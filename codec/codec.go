@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Encoder writes encoded values to an output stream
+type Encoder interface {
+	Encode(v interface{}) error
+	SetIndent(prefix, indent string)
+}
+
+// Codec marshals and unmarshals request and response payloads. It can be
+// used to replace encoding/json with a faster implementation
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewEncoder(w io.Writer) Encoder
+}
+
+// Default is the encoding/json codec
+var Default Codec = jsonCodec{}
+
+// jsonCodec implements Codec using encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+// OrDefault returns the codec or the default codec if it is nil
+func OrDefault(c Codec) Codec {
+	if c == nil {
+		return Default
+	}
+	return c
+}
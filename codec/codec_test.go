@@ -0,0 +1,38 @@
+package codec_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/codec"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"data":   map[string]interface{}{"hello": "world", "count": float64(2)},
+		"errors": []interface{}{map[string]interface{}{"message": "<failed>"}},
+	}
+
+	b, err := codec.Default.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := codec.Default.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %v, got %v", in, out)
+	}
+
+	// the encoder writes the same bytes followed by a newline
+	var buf bytes.Buffer
+	if err := codec.OrDefault(nil).NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(b)+"\n" {
+		t.Fatalf("expected encoded %s, got %s", b, buf.String())
+	}
+}
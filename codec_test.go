@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/graphql-go/graphql"
+)
+
+// countingCodec counts the responses encoded by the default codec
+type countingCodec struct {
+	codec.Codec
+	encoded int
+}
+
+func (c *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	c.encoded++
+	return c.Codec.Marshal(v)
+}
+
+func (c *countingCodec) NewEncoder(w io.Writer) codec.Encoder {
+	c.encoded++
+	return c.Codec.NewEncoder(w)
+}
+
+func TestHTTPResponseEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []server.Option
+		body string
+	}{
+		{
+			name: "streamed",
+			body: `{"data":{"hello":"world"}}`,
+		},
+		{
+			name: "streamed pretty",
+			opts: []server.Option{server.WithPretty()},
+			body: "{\n\t\"data\": {\n\t\t\"hello\": \"world\"\n\t}\n}",
+		},
+		{
+			name: "callback",
+			opts: []server.Option{
+				server.WithResultCallbackFunc(func(ctx context.Context, params *graphql.Params, result *graphql.Result, responseBody []byte) {}),
+			},
+			body: `{"data":{"hello":"world"}}`,
+		},
+		{
+			name: "callback pretty",
+			opts: []server.Option{
+				server.WithPretty(),
+				server.WithResultCallbackFunc(func(ctx context.Context, params *graphql.Params, result *graphql.Result, responseBody []byte) {}),
+			},
+			body: "{\n\t\"data\": {\n\t\t\"hello\": \"world\"\n\t}\n}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &countingCodec{Codec: codec.Default}
+			srv := server.New(testutil.Hello(t), append(tt.opts, server.WithCodec(c))...)
+
+			r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hello }"}`))
+			r.Header.Set("Content-Type", server.ContentTypeJSON)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			// streamed and buffered responses have the same body
+			if w.Body.String() != tt.body {
+				t.Fatalf("expected body %q, got %q", tt.body, w.Body.String())
+			}
+			if c.encoded != 1 {
+				t.Fatalf("expected the response to be encoded by the codec once, got %d", c.encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+)
+
+// recordConn records the bytes read from the server
+type recordConn struct {
+	net.Conn
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mx.Lock()
+	c.buf.Write(b[:n])
+	c.mx.Unlock()
+	return n, err
+}
+
+// compressed returns the rsv1 bit of each frame read after the handshake,
+// the bit is set on compressed messages
+func (c *recordConn) compressed() []bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	b := c.buf.Bytes()
+	b = b[bytes.Index(b, []byte("\r\n\r\n"))+4:]
+
+	frames := []bool{}
+	for len(b) >= 2 {
+		size, header := uint64(b[1]&0x7f), 2
+		switch size {
+		case 126:
+			size, header = uint64(binary.BigEndian.Uint16(b[2:])), 4
+		case 127:
+			size, header = binary.BigEndian.Uint64(b[2:]), 10
+		}
+
+		frames = append(frames, b[0]&0x40 != 0)
+		b = b[header+int(size):]
+	}
+
+	return frames
+}
+
+func TestCompression(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Query: graphql.Fields{
+			"large": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return strings.Repeat("a", 1024), nil
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		compression *server.Compression
+		client      bool
+		negotiated  bool
+		frames      []bool
+	}{
+		{
+			name:        "negotiated",
+			compression: &server.Compression{EnableCompression: true, Threshold: 512},
+			client:      true,
+			negotiated:  true,
+			// the ack and complete are below the threshold
+			frames: []bool{false, true, false},
+		},
+		{
+			name:        "client disabled",
+			compression: &server.Compression{EnableCompression: true, Threshold: 512},
+			frames:      []bool{false, false, false},
+		},
+		{
+			name:   "server disabled",
+			client: true,
+			frames: []bool{false, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(server.New(
+				schema,
+				server.WithGraphQLTransportWS(&server.GraphQLTransportWS{}),
+				server.WithCompression(tt.compression),
+			))
+			defer srv.Close()
+
+			var conn *recordConn
+			dialer := &websocket.Dialer{
+				EnableCompression: tt.client,
+				NetDial: func(network, addr string) (net.Conn, error) {
+					c, err := net.Dial(network, addr)
+					conn = &recordConn{Conn: c}
+					return conn, err
+				},
+			}
+
+			ws, resp := dial(t, srv, dialer, graphqltransportws.Subprotocol)
+			negotiated := strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+			if negotiated != tt.negotiated {
+				t.Fatalf("expected negotiated %v, got %q", tt.negotiated, resp.Header.Get("Sec-Websocket-Extensions"))
+			}
+
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_init"}`))
+			readMessage(t, ws)
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"{ large }"}}`))
+			if msg := readMessage(t, ws); len(testutil.Data(msg)["large"].(string)) != 1024 {
+				t.Fatalf("expected the large result, got %+v", msg)
+			}
+			if msg := readMessage(t, ws); msg.Type != protocol.MsgComplete {
+				t.Fatalf("expected complete, got %+v", msg)
+			}
+
+			frames := conn.compressed()
+			if len(frames) != len(tt.frames) {
+				t.Fatalf("expected %d frames, got %d", len(tt.frames), len(frames))
+			}
+			for i, compressed := range tt.frames {
+				if frames[i] != compressed {
+					t.Errorf("frame %d: expected compressed %v, got %v", i, compressed, frames[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package document
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sync"
+
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Stats are cache metrics
+type Stats struct {
+	Size      int
+	Capacity  int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// entry is a cached document along with its parse and validation results
+type entry struct {
+	key         string
+	document    *ast.Document
+	parseErr    error
+	validations []validation
+}
+
+// validation is the result of validating a document against a schema,
+// schemas are identified by their type map
+type validation struct {
+	types graphql.TypeMap
+	errs  gqlerrors.FormattedErrors
+}
+
+// Cache is a bounded LRU cache of parsed and validated documents keyed
+// by the query hash. Documents are parsed once and validated once per
+// schema so a cache can be shared by executors of different schemas
+type Cache struct {
+	mx       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	stats    Stats
+}
+
+// NewCache creates a new cache holding up to capacity documents
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		stats:    Stats{Capacity: capacity},
+	}
+}
+
+// Hash returns the hash of the query used as the cache key
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the entry for the query, parsing it on a miss
+func (c *Cache) get(query string) *entry {
+	key := Hash(query)
+
+	c.mx.Lock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.stats.Hits++
+		c.mx.Unlock()
+		return el.Value.(*entry)
+	}
+	c.stats.Misses++
+	c.mx.Unlock()
+
+	// parse outside of the lock, concurrent misses for the same query
+	// will parse more than once but only one entry is kept
+	doc, err := utils.ParseQuery(query)
+	e := &entry{
+		key:      key,
+		document: doc,
+		parseErr: err,
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*entry)
+	}
+
+	c.items[key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+		c.stats.Evictions++
+	}
+
+	return e
+}
+
+// validate validates the entry document once against the schema
+func (c *Cache) validate(schema *graphql.Schema, e *entry) gqlerrors.FormattedErrors {
+	types := schema.TypeMap()
+
+	c.mx.Lock()
+	if v, ok := e.validation(types); ok {
+		c.mx.Unlock()
+		return v.errs
+	}
+	c.mx.Unlock()
+
+	result := graphql.ValidateDocument(schema, e.document, nil)
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if v, ok := e.validation(types); ok {
+		return v.errs
+	}
+
+	v := validation{types: types}
+	if !result.IsValid {
+		v.errs = result.Errors
+	}
+	e.validations = append(e.validations, v)
+
+	return v.errs
+}
+
+// validation returns the validation result of the schema with the type
+// map, copies of a schema share their type map
+func (e *entry) validation(types graphql.TypeMap) (validation, bool) {
+	ptr := reflect.ValueOf(types).Pointer()
+	for _, v := range e.validations {
+		if reflect.ValueOf(v.types).Pointer() == ptr {
+			return v, true
+		}
+	}
+	return validation{}, false
+}
+
+// Stats returns the cache metrics
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	stats := c.stats
+	stats.Size = c.ll.Len()
+	return stats
+}
@@ -0,0 +1,79 @@
+package document_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/graphql-go/graphql"
+)
+
+func TestCache(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	c := &document.Executor{Cache: document.NewCache(1)}
+	for i := 0; i < 2; i++ {
+		result := c.Do(graphql.Params{Schema: schema, RequestString: "{ hello }"})
+		if len(result.Errors) > 0 {
+			t.Fatalf("unexpected errors: %v", result.Errors)
+		}
+	}
+
+	if result := c.Do(graphql.Params{Schema: schema, RequestString: "{ missing }"}); len(result.Errors) == 0 {
+		t.Fatal("expected validation errors")
+	}
+
+	stats := c.Cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Evictions != 1 || stats.Size != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestExecuteParsedDocument(t *testing.T) {
+	schema := testutil.Hello(t)
+	e := &document.Executor{Cache: document.NewCache(10)}
+
+	p := graphql.Params{Schema: schema, RequestString: "{ hello }", Context: context.Background()}
+	d, err := e.Parse(p.Context, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the parsed document is executed without another cache lookup
+	if result := e.Execute(p, d); len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if stats := e.Cache.Stats(); stats.Hits != 0 || stats.Misses != 1 {
+		t.Fatalf("expected a single lookup, got %+v", stats)
+	}
+
+	// a request string replaced after parsing is parsed again
+	p.RequestString = "{ missing }"
+	if result := e.Execute(p, d); len(result.Errors) == 0 {
+		t.Fatal("expected validation errors for the replaced request string")
+	}
+}
+
+func TestCacheSchemas(t *testing.T) {
+	hello := testutil.Hello(t)
+	other := testutil.NewSchema(t, &testutil.Schema{
+		Query: graphql.Fields{"other": &graphql.Field{Type: graphql.String}},
+	})
+	e := &document.Executor{Cache: document.NewCache(10)}
+
+	// the document is validated against each schema sharing the cache
+	if result := e.Do(graphql.Params{Schema: hello, RequestString: "{ other }"}); len(result.Errors) == 0 {
+		t.Fatal("expected validation errors")
+	}
+	if result := e.Do(graphql.Params{Schema: other, RequestString: "{ other }"}); len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if result := e.Do(graphql.Params{Schema: hello, RequestString: "{ other }"}); len(result.Errors) == 0 {
+		t.Fatal("expected validation errors")
+	}
+
+	if stats := e.Cache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("expected the document to be parsed once, got %+v", stats)
+	}
+}
@@ -0,0 +1,85 @@
+package document
+
+import (
+	"context"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Check is run against a parsed and validated document before it is
+// executed, returning errors prevents execution
+type Check func(ctx context.Context, doc *ast.Document, p *graphql.Params) gqlerrors.FormattedErrors
+
+// NoIntrospection returns a check that rejects documents that query the
+// __schema or __type introspection fields unless allow returns true for
+// the request context. A nil allow rejects all introspection
+func NoIntrospection(allow func(ctx context.Context) bool) Check {
+	return func(ctx context.Context, doc *ast.Document, p *graphql.Params) gqlerrors.FormattedErrors {
+		if allow != nil && allow(ctx) {
+			return nil
+		}
+
+		var errs gqlerrors.FormattedErrors
+		for _, def := range doc.Definitions {
+			var selectionSet *ast.SelectionSet
+			switch d := def.(type) {
+			case *ast.OperationDefinition:
+				selectionSet = d.SelectionSet
+			case *ast.FragmentDefinition:
+				selectionSet = d.SelectionSet
+			}
+
+			for _, field := range introspectionFields(selectionSet) {
+				errs = append(errs, gqlerrors.FormatError(gqlerrors.NewLocatedError(
+					"GraphQL introspection is not allowed",
+					[]ast.Node{field},
+				)))
+			}
+		}
+
+		return errs
+	}
+}
+
+// introspectionFields finds the introspection fields in a selection set
+func introspectionFields(selectionSet *ast.SelectionSet) []*ast.Field {
+	if selectionSet == nil {
+		return nil
+	}
+
+	fields := []*ast.Field{}
+	for _, selection := range selectionSet.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if name := s.Name; name != nil && (name.Value == "__schema" || name.Value == "__type") {
+				fields = append(fields, s)
+			}
+			fields = append(fields, introspectionFields(s.SelectionSet)...)
+		case *ast.InlineFragment:
+			fields = append(fields, introspectionFields(s.SelectionSet)...)
+		}
+	}
+
+	return fields
+}
+
+// StripSuggestions removes "Did you mean" suggestions, which can leak
+// schema details, from the error messages
+func StripSuggestions(errs gqlerrors.FormattedErrors) gqlerrors.FormattedErrors {
+	if len(errs) == 0 {
+		return errs
+	}
+
+	stripped := make(gqlerrors.FormattedErrors, len(errs))
+	for i, err := range errs {
+		if idx := strings.Index(err.Message, " Did you mean "); idx != -1 {
+			err.Message = err.Message[:idx]
+		}
+		stripped[i] = err
+	}
+
+	return stripped
+}
@@ -0,0 +1,45 @@
+package document_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/graphql-go/graphql"
+)
+
+type adminKey struct{}
+
+func TestChecks(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	e := &document.Executor{
+		Checks: []document.Check{
+			document.NoIntrospection(func(ctx context.Context) bool {
+				return ctx.Value(adminKey{}) != nil
+			}),
+		},
+		StripSuggestions: true,
+	}
+
+	query := "{ ...F } fragment F on Query { __schema { queryType { name } } }"
+	if result := e.Do(graphql.Params{Schema: schema, RequestString: query}); len(result.Errors) != 1 {
+		t.Fatalf("expected introspection to be rejected, got %v", result.Errors)
+	}
+
+	admin := context.WithValue(context.Background(), adminKey{}, true)
+	if result := e.Do(graphql.Params{Schema: schema, RequestString: query, Context: admin}); len(result.Errors) > 0 {
+		t.Fatalf("expected introspection to be allowed, got %v", result.Errors)
+	}
+
+	if result := e.Do(graphql.Params{Schema: schema, RequestString: "{ __typename }"}); len(result.Errors) > 0 {
+		t.Fatalf("expected __typename to be allowed, got %v", result.Errors)
+	}
+
+	result := e.Do(graphql.Params{Schema: schema, RequestString: "{ helo }"})
+	if len(result.Errors) != 1 || strings.Contains(result.Errors[0].Message, "Did you mean") {
+		t.Fatalf("expected suggestion to be stripped, got %v", result.Errors)
+	}
+}
@@ -0,0 +1,388 @@
+package document
+
+import (
+	"context"
+	"reflect"
+	"time"
+	"unsafe"
+
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Observer is notified of the start of each execution phase and returns
+// a function that is called when the phase finishes
+type Observer interface {
+	ParseDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(doc *ast.Document, err error))
+	ValidationDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(errs gqlerrors.FormattedErrors))
+	ExecutionDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(result *graphql.Result))
+}
+
+// Executor executes operations using an optional document cache, runs
+// checks against the validated document, enforces query and mutation
+// timeouts and notifies observers of each phase. A nil executor, or one
+// without any of these features, executes Do and Subscribe with
+// graphql.Do and graphql.Subscribe.
+//
+// Otherwise, and for documents parsed with Parse and run with Execute or
+// ExecuteSubscription, operations are executed with graphql.Execute and
+// the executor calls the Init, ParseDidStart and ValidationDidStart hooks
+// of schema extensions itself. Documents parsed with Parse finish the
+// parse hooks of extensions without error when executed
+type Executor struct {
+	Cache            *Cache
+	Checks           []Check
+	Observers        []Observer
+	StripSuggestions bool
+	QueryTimeout     time.Duration
+	MutationTimeout  time.Duration
+}
+
+// passthrough returns true if the executor adds nothing to graphql.Do
+func (e *Executor) passthrough() bool {
+	return e == nil || (e.Cache == nil &&
+		len(e.Checks) == 0 &&
+		len(e.Observers) == 0 &&
+		!e.StripSuggestions &&
+		e.QueryTimeout == 0 &&
+		e.MutationTimeout == 0)
+}
+
+// timeout returns the timeout of the operation type
+func (e *Executor) timeout(doc *ast.Document, operationName string) time.Duration {
+	op, err := utils.GetOperationAST(doc, operationName)
+	if err != nil {
+		return 0
+	}
+
+	switch op.Operation {
+	case ast.OperationTypeQuery:
+		return e.QueryTimeout
+	case ast.OperationTypeMutation:
+		return e.MutationTimeout
+	}
+
+	return 0
+}
+
+// formatErrors strips suggestions from the errors when configured
+func (e *Executor) formatErrors(errs gqlerrors.FormattedErrors) gqlerrors.FormattedErrors {
+	if e.StripSuggestions {
+		return StripSuggestions(errs)
+	}
+	return errs
+}
+
+// extensions are the schema extensions. graphql.Execute only calls their
+// execution and resolve hooks, the other hooks are called by graphql.Do
+type extensions []graphql.Extension
+
+// extensionsField returns the unexported extensions field of the schema
+// or nil if graphql-go no longer has it
+func extensionsField(schema *graphql.Schema) *[]graphql.Extension {
+	f := reflect.ValueOf(schema).Elem().FieldByName("extensions")
+	if !f.IsValid() || f.Type() != reflect.TypeOf([]graphql.Extension(nil)) {
+		return nil
+	}
+	return (*[]graphql.Extension)(unsafe.Pointer(f.UnsafeAddr()))
+}
+
+// schemaExtensions returns the extensions added to the schema
+func schemaExtensions(schema *graphql.Schema) extensions {
+	if f := extensionsField(schema); f != nil {
+		return *f
+	}
+	return nil
+}
+
+// AddExtensions adds extensions to the schema. Unlike
+// graphql.Schema.AddExtensions the extensions are appended to a copy so
+// they are not added to the schema it was copied from when the
+// extensions share a backing array
+func AddExtensions(schema *graphql.Schema, exts ...graphql.Extension) {
+	f := extensionsField(schema)
+	if f == nil {
+		schema.AddExtensions(exts...)
+		return
+	}
+
+	*f = append(append([]graphql.Extension{}, *f...), exts...)
+}
+
+// parseDidStart initializes the extensions and notifies them that parsing
+// has started, updating the params context
+func (exts extensions) parseDidStart(p *graphql.Params) func(err error) {
+	for _, ext := range exts {
+		p.Context = ext.Init(p.Context, p)
+	}
+
+	finishFuncs := []graphql.ParseFinishFunc{}
+	for _, ext := range exts {
+		var finish graphql.ParseFinishFunc
+		p.Context, finish = ext.ParseDidStart(p.Context)
+		finishFuncs = append(finishFuncs, finish)
+	}
+
+	return func(err error) {
+		for _, finish := range finishFuncs {
+			finish(err)
+		}
+	}
+}
+
+// validationDidStart notifies the extensions that validation has started,
+// updating the params context
+func (exts extensions) validationDidStart(p *graphql.Params) func(errs gqlerrors.FormattedErrors) {
+	finishFuncs := []graphql.ValidationFinishFunc{}
+	for _, ext := range exts {
+		var finish graphql.ValidationFinishFunc
+		p.Context, finish = ext.ValidationDidStart(p.Context)
+		finishFuncs = append(finishFuncs, finish)
+	}
+
+	return func(errs gqlerrors.FormattedErrors) {
+		for _, finish := range finishFuncs {
+			finish(errs)
+		}
+	}
+}
+
+// Document is a parsed query. Executing a Document does not parse or look
+// up the request string again and reuses its cached validation results
+type Document struct {
+	AST   *ast.Document
+	query string
+	ent   *entry
+}
+
+// Parse parses the request string returning the cached document when
+// available and notifies the observers of the parse phase
+func (e *Executor) Parse(ctx context.Context, p *graphql.Params) (*Document, error) {
+	if e == nil {
+		doc, err := utils.ParseQuery(p.RequestString)
+		if err != nil {
+			return nil, err
+		}
+		return &Document{AST: doc, query: p.RequestString}, nil
+	}
+
+	parseFinish := []func(*ast.Document, error){}
+	for _, o := range e.Observers {
+		_, finish := o.ParseDidStart(ctx, p)
+		parseFinish = append(parseFinish, finish)
+	}
+
+	d := &Document{query: p.RequestString}
+	var err error
+	if e.Cache != nil {
+		d.ent = e.Cache.get(p.RequestString)
+		d.AST, err = d.ent.document, d.ent.parseErr
+	} else {
+		d.AST, err = utils.ParseQuery(p.RequestString)
+	}
+
+	for _, finish := range parseFinish {
+		finish(d.AST, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// prepare validates and checks the document, the extensions are notified
+// of the validation phase
+func (e *Executor) prepare(p *graphql.Params, d *Document, exts extensions) gqlerrors.FormattedErrors {
+	extensionsFinish := exts.validationDidStart(p)
+	ctx := p.Context
+	validationFinish := []func(gqlerrors.FormattedErrors){}
+	for _, o := range e.Observers {
+		_, finish := o.ValidationDidStart(ctx, p)
+		validationFinish = append(validationFinish, finish)
+	}
+
+	var errs gqlerrors.FormattedErrors
+	if d.ent != nil {
+		errs = e.Cache.validate(&p.Schema, d.ent)
+	} else if result := graphql.ValidateDocument(&p.Schema, d.AST, nil); !result.IsValid {
+		errs = result.Errors
+	}
+
+	extensionsFinish(errs)
+	for _, finish := range validationFinish {
+		finish(errs)
+	}
+
+	if len(errs) > 0 {
+		return e.formatErrors(errs)
+	}
+
+	// check
+	for _, check := range e.Checks {
+		if errs := check(ctx, d.AST, p); len(errs) > 0 {
+			return errs
+		}
+	}
+
+	return nil
+}
+
+// parse parses the request string unless the document was parsed from it
+func (e *Executor) parse(ctx context.Context, p *graphql.Params, d *Document) (*Document, gqlerrors.FormattedErrors) {
+	if d != nil && d.query == p.RequestString {
+		return d, nil
+	}
+
+	d, err := e.Parse(ctx, p)
+	if err != nil {
+		return nil, e.formatErrors(gqlerrors.FormatErrors(err))
+	}
+	return d, nil
+}
+
+// begin parses, validates and checks the document calling the schema
+// extension hooks, it returns the context to execute with
+func (e *Executor) begin(p *graphql.Params, d *Document) (context.Context, *Document, gqlerrors.FormattedErrors) {
+	p.Context = contextOf(*p)
+	exts := schemaExtensions(&p.Schema)
+
+	parseFinish := exts.parseDidStart(p)
+	d, errs := e.parse(p.Context, p, d)
+	if errs != nil {
+		parseFinish(errs[0])
+		return p.Context, nil, errs
+	}
+	parseFinish(nil)
+
+	errs = e.prepare(p, d, exts)
+	return p.Context, d, errs
+}
+
+// executionDidStart notifies the observers that execution has started
+func (e *Executor) executionDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(*graphql.Result)) {
+	finishFuncs := []func(*graphql.Result){}
+	for _, o := range e.Observers {
+		var finish func(*graphql.Result)
+		ctx, finish = o.ExecutionDidStart(ctx, p)
+		finishFuncs = append(finishFuncs, finish)
+	}
+
+	return ctx, func(result *graphql.Result) {
+		for _, finish := range finishFuncs {
+			finish(result)
+		}
+	}
+}
+
+// Do parses and executes a query or mutation
+func (e *Executor) Do(p graphql.Params) *graphql.Result {
+	if e.passthrough() {
+		return graphql.Do(p)
+	}
+
+	return e.Execute(p, nil)
+}
+
+// Execute executes a query or mutation parsed by Parse. The request
+// string is parsed again if it was changed after parsing
+func (e *Executor) Execute(p graphql.Params, d *Document) *graphql.Result {
+	if e == nil {
+		e = &Executor{}
+	}
+
+	ctx, d, errs := e.begin(&p, d)
+	if errs != nil {
+		return &graphql.Result{Errors: errs}
+	}
+
+	if timeout := e.timeout(d.AST, p.OperationName); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx, finish := e.executionDidStart(ctx, &p)
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        p.Schema,
+		Root:          p.RootObject,
+		AST:           d.AST,
+		OperationName: p.OperationName,
+		Args:          p.VariableValues,
+		Context:       ctx,
+	})
+	result.Errors = e.formatErrors(result.Errors)
+	finish(result)
+
+	return result
+}
+
+// Subscribe parses and executes a subscription. The execution phase
+// observed by the observers lasts until the result channel is closed
+func (e *Executor) Subscribe(p graphql.Params) chan *graphql.Result {
+	if e.passthrough() {
+		return graphql.Subscribe(p)
+	}
+
+	return e.ExecuteSubscription(p, nil)
+}
+
+// ExecuteSubscription executes a subscription parsed by Parse. The
+// request string is parsed again if it was changed after parsing
+func (e *Executor) ExecuteSubscription(p graphql.Params, d *Document) chan *graphql.Result {
+	if e == nil {
+		e = &Executor{}
+	}
+
+	ctx, d, errs := e.begin(&p, d)
+	if errs != nil {
+		return errorChannel(errs)
+	}
+
+	execCtx, finish := e.executionDidStart(ctx, &p)
+	src := graphql.ExecuteSubscription(graphql.ExecuteParams{
+		Schema:        p.Schema,
+		Root:          p.RootObject,
+		AST:           d.AST,
+		OperationName: p.OperationName,
+		Args:          p.VariableValues,
+		Context:       execCtx,
+	})
+
+	// the execution phase ends with the subscription, the observers
+	// receive the last result
+	ch := make(chan *graphql.Result)
+	go func() {
+		var last *graphql.Result
+		defer close(ch)
+		defer func() { finish(last) }()
+
+		for res := range src {
+			last = res
+			select {
+			case ch <- res:
+			case <-execCtx.Done():
+			}
+		}
+	}()
+
+	return ch
+}
+
+// contextOf returns the params context or the background context
+func contextOf(p graphql.Params) context.Context {
+	if p.Context == nil {
+		return context.Background()
+	}
+	return p.Context
+}
+
+// errorChannel returns a closed result channel holding the errors
+func errorChannel(errs gqlerrors.FormattedErrors) chan *graphql.Result {
+	ch := make(chan *graphql.Result, 1)
+	ch <- &graphql.Result{Errors: errs}
+	close(ch)
+	return ch
+}
@@ -0,0 +1,124 @@
+package document_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// recorder is a schema extension recording the hooks it is called with
+type recorder struct {
+	calls []string
+}
+
+type recorderKey struct{}
+
+func (r *recorder) Init(ctx context.Context, p *graphql.Params) context.Context {
+	r.calls = append(r.calls, "init")
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	r.calls = append(r.calls, "parse")
+	return ctx, func(err error) { r.calls = append(r.calls, "parsed") }
+}
+
+func (r *recorder) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	r.calls = append(r.calls, "validation")
+	return ctx, func(errs []gqlerrors.FormattedError) { r.calls = append(r.calls, "validated") }
+}
+
+func (r *recorder) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	if ctx.Value(recorderKey{}) == r {
+		r.calls = append(r.calls, "execution")
+	}
+	return ctx, func(result *graphql.Result) {}
+}
+
+func (r *recorder) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	return ctx, func(v interface{}, err error) {}
+}
+
+func (r *recorder) HasResult() bool {
+	return false
+}
+
+func (r *recorder) GetResult(ctx context.Context) interface{} {
+	return nil
+}
+
+func TestExtensions(t *testing.T) {
+	r := &recorder{}
+	schema := testutil.Hello(t)
+	schema.AddExtensions(r)
+
+	// the executor calls the hooks graphql.Execute does not and threads
+	// the context returned by Init to execution
+	e := &document.Executor{Cache: document.NewCache(10)}
+	if result := e.Do(graphql.Params{Schema: schema, RequestString: "{ hello }"}); len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+
+	expected := []string{"init", "parse", "parsed", "validation", "validated", "execution"}
+	if !reflect.DeepEqual(r.calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.calls)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Query: graphql.Fields{
+			"slow": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					<-p.Context.Done()
+					return nil, p.Context.Err()
+				},
+			},
+		},
+	})
+
+	e := &document.Executor{QueryTimeout: 10 * time.Millisecond}
+	result := e.Do(graphql.Params{Schema: schema, RequestString: "{ slow }", Context: context.Background()})
+
+	if len(result.Errors) != 1 || !errors.Is(result.Errors[0].OriginalError(), context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline exceeded error, got %v", result.Errors)
+	}
+}
+
+func TestAddExtensions(t *testing.T) {
+	// the schema extensions have spare capacity so appending to copies of
+	// the schema shares their backing array
+	hello := testutil.Hello(t)
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:      hello.QueryType(),
+		Extensions: append(make([]graphql.Extension, 0, 4), &recorder{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := &recorder{}, &recorder{}
+	schemaA, schemaB := schema, schema
+	document.AddExtensions(&schemaA, a)
+	document.AddExtensions(&schemaB, b)
+
+	e := &document.Executor{Cache: document.NewCache(10)}
+	if result := e.Do(graphql.Params{Schema: schemaA, RequestString: "{ hello }"}); len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if len(a.calls) == 0 || len(b.calls) != 0 {
+		t.Fatalf("expected only the extension of the schema to be called, got %v and %v", a.calls, b.calls)
+	}
+}
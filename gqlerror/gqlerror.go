@@ -0,0 +1,229 @@
+package gqlerror
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Error codes set in extensions.code
+const (
+	CodeBadRequest              = "BAD_REQUEST"
+	CodeBadUserInput            = "BAD_USER_INPUT"
+	CodeUnauthenticated         = "UNAUTHENTICATED"
+	CodeForbidden               = "FORBIDDEN"
+	CodeRateLimited             = "RATE_LIMITED"
+	CodeTimeout                 = "TIMEOUT"
+	CodePersistedQueryNotFound  = "PERSISTED_QUERY_NOT_FOUND"
+	CodeGraphQLParseFailed      = "GRAPHQL_PARSE_FAILED"
+	CodeGraphQLValidationFailed = "GRAPHQL_VALIDATION_FAILED"
+	CodeInternalServerError     = "INTERNAL_SERVER_ERROR"
+)
+
+// DefaultMaskedMessage is the message of masked errors
+const DefaultMaskedMessage = "Internal server error"
+
+// ErrTimeout is sent to clients when an operation deadline is exceeded
+var ErrTimeout = New(CodeTimeout, "operation timed out")
+
+// Coder is implemented by errors that are safe to send to clients and
+// supply an extensions code
+type Coder interface {
+	error
+	Code() string
+}
+
+// Error is a classified error with an extensions code
+type Error struct {
+	code       string
+	message    string
+	extensions map[string]interface{}
+	err        error
+}
+
+// New creates a new classified error
+func New(code, message string) *Error {
+	return &Error{code: code, message: message}
+}
+
+// Wrap classifies an error, the message of the error is sent to clients
+func Wrap(code string, err error) *Error {
+	return &Error{code: code, message: err.Error(), err: err}
+}
+
+// WithExtension returns a copy of the error with an additional extension
+func (e *Error) WithExtension(key string, value interface{}) *Error {
+	c := *e
+	c.extensions = map[string]interface{}{}
+	for k, v := range e.extensions {
+		c.extensions[k] = v
+	}
+	c.extensions[key] = value
+	return &c
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return e.message
+}
+
+// Unwrap returns the wrapped error
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Code implements Coder
+func (e *Error) Code() string {
+	return e.code
+}
+
+// Extensions implements gqlerrors.ExtendedError
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{}
+	for k, v := range e.extensions {
+		ext[k] = v
+	}
+	ext["code"] = e.code
+	return ext
+}
+
+// Options configures error formatting. When Mask is set errors that do
+// not implement Coder are replaced with MaskedMessage and a correlation
+// id that is logged with the original error. FormatErrorFunc replaces
+// the classification entirely when set
+type Options struct {
+	Mask              bool
+	MaskedMessage     string
+	CorrelationIDFunc func() string
+	FormatErrorFunc   func(err error) gqlerrors.FormattedError
+}
+
+// Formatter classifies and optionally masks errors before they are sent
+// to clients. A nil formatter is valid and returns errors as is
+type Formatter struct {
+	log               *logger.LogWrapper
+	mask              bool
+	maskedMessage     string
+	correlationIDFunc func() string
+	formatErrorFunc   func(err error) gqlerrors.FormattedError
+}
+
+// NewFormatter creates a new error formatter
+func NewFormatter(opts *Options, log *logger.LogWrapper) *Formatter {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if log == nil {
+		log = logger.NewNoopLogger()
+	}
+
+	f := &Formatter{
+		log:               log,
+		mask:              opts.Mask,
+		maskedMessage:     opts.MaskedMessage,
+		correlationIDFunc: opts.CorrelationIDFunc,
+		formatErrorFunc:   opts.FormatErrorFunc,
+	}
+
+	if f.maskedMessage == "" {
+		f.maskedMessage = DefaultMaskedMessage
+	}
+	if f.correlationIDFunc == nil {
+		f.correlationIDFunc = uuid.NewString
+	}
+
+	return f
+}
+
+// FormatErrors formats each error
+func (f *Formatter) FormatErrors(errs gqlerrors.FormattedErrors) gqlerrors.FormattedErrors {
+	if f == nil || len(errs) == 0 {
+		return errs
+	}
+
+	formatted := make(gqlerrors.FormattedErrors, len(errs))
+	for i, err := range errs {
+		formatted[i] = f.FormatError(err)
+	}
+
+	return formatted
+}
+
+// FormatError classifies the error and masks it if it is unexpected
+func (f *Formatter) FormatError(err error) gqlerrors.FormattedError {
+	formatted := gqlerrors.FormatError(err)
+	if f == nil {
+		return formatted
+	}
+
+	original := formatted.OriginalError()
+	if f.formatErrorFunc != nil {
+		return f.formatErrorFunc(original)
+	}
+
+	// errors raised by graphql itself have no original error
+	if gqlErr, ok := original.(*gqlerrors.Error); ok {
+		if gqlErr.OriginalError == nil {
+			code := CodeGraphQLValidationFailed
+			if strings.HasPrefix(gqlErr.Message, "Syntax Error") {
+				code = CodeGraphQLParseFailed
+			}
+			return withExtensions(formatted, map[string]interface{}{"code": code})
+		}
+		original = gqlErr.OriginalError
+	}
+
+	if original == nil {
+		return formatted
+	}
+
+	// deadlines are set by operation timeouts
+	if errors.Is(original, context.DeadlineExceeded) {
+		formatted.Message = ErrTimeout.Error()
+		return withExtensions(formatted, ErrTimeout.Extensions())
+	}
+
+	var coder Coder
+	if errors.As(original, &coder) {
+		ext := map[string]interface{}{"code": coder.Code()}
+		if extended, ok := coder.(gqlerrors.ExtendedError); ok {
+			ext = extended.Extensions()
+		}
+		return withExtensions(formatted, ext)
+	}
+
+	if !f.mask {
+		return withExtensions(formatted, map[string]interface{}{"code": CodeInternalServerError})
+	}
+
+	id := f.correlationIDFunc()
+	f.log.WithError(original).WithField("correlationId", id).Errorf("masked unexpected error")
+
+	formatted.Message = f.maskedMessage
+	formatted.Extensions = map[string]interface{}{
+		"code":          CodeInternalServerError,
+		"correlationId": id,
+	}
+
+	return formatted
+}
+
+// withExtensions merges the extensions into the error extensions
+func withExtensions(err gqlerrors.FormattedError, ext map[string]interface{}) gqlerrors.FormattedError {
+	merged := map[string]interface{}{}
+	for k, v := range err.Extensions {
+		merged[k] = v
+	}
+	for k, v := range ext {
+		if _, ok := merged[k]; !ok {
+			merged[k] = v
+		}
+	}
+
+	err.Extensions = merged
+	return err
+}
@@ -0,0 +1,80 @@
+package gqlerror_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/graphql-go/graphql"
+)
+
+func TestErrorMasking(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Query: graphql.Fields{
+			"input": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return nil, gqlerror.New(gqlerror.CodeBadUserInput, "invalid input")
+				},
+			},
+			"internal": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return nil, errors.New("db password is hunter2")
+				},
+			},
+		},
+	})
+
+	logged := []logger.LogPayload{}
+	srv := server.New(
+		schema,
+		server.WithLogFunc(func(payload logger.LogPayload) {
+			logged = append(logged, payload)
+		}),
+		server.WithErrorFormatting(&gqlerror.Options{
+			Mask:              true,
+			CorrelationIDFunc: func() string { return "abc" },
+		}),
+	)
+
+	do := func(query string) string {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"`+query+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	if body := do("{ input }"); !strings.Contains(body, `"invalid input"`) || !strings.Contains(body, `"BAD_USER_INPUT"`) {
+		t.Fatalf("expected coded error, got %s", body)
+	}
+
+	body := do("{ internal }")
+	if strings.Contains(body, "hunter2") {
+		t.Fatalf("expected error to be masked, got %s", body)
+	}
+	if !strings.Contains(body, `"INTERNAL_SERVER_ERROR"`) || !strings.Contains(body, `"correlationId":"abc"`) {
+		t.Fatalf("expected masked error with correlation id, got %s", body)
+	}
+
+	found := false
+	for _, payload := range logged {
+		if payload.Fields["correlationId"] == "abc" && payload.Error != nil && strings.Contains(payload.Error.Error(), "hunter2") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected the masked error to be logged with the correlation id")
+	}
+
+	if body := do("{ inptu }"); !strings.Contains(body, `"GRAPHQL_VALIDATION_FAILED"`) {
+		t.Fatalf("expected validation error code, got %s", body)
+	}
+}
@@ -3,20 +3,31 @@ package server
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
 	"github.com/bhoriuchi/graphql-go-server/ide"
-	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
-	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/bhoriuchi/graphql-go-server/metrics"
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
+	"github.com/bhoriuchi/graphql-go-server/trusted"
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport/gorillatransport"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
 )
 
 // RequestOptions options
@@ -24,6 +35,8 @@ type RequestOptions struct {
 	Query         string                 `json:"query" url:"query" schema:"query"`
 	Variables     map[string]interface{} `json:"variables" url:"variables" schema:"variables"`
 	OperationName string                 `json:"operationName" url:"operationName" schema:"operationName"`
+	DocumentID    string                 `json:"documentId,omitempty" url:"documentId" schema:"documentId"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty" url:"extensions" schema:"extensions"`
 }
 
 // a workaround for getting`variables` as a JSON string
@@ -33,18 +46,27 @@ type requestOptionsCompatibility struct {
 	OperationName string `json:"operationName" url:"operationName" schema:"operationName"`
 }
 
-func getFromForm(values url.Values) *RequestOptions {
+func getFromForm(values url.Values, c codec.Codec) *RequestOptions {
 	query := values.Get("query")
-	if query != "" {
+	documentID := values.Get("documentId")
+	extensionsStr := values.Get("extensions")
+	if query != "" || documentID != "" || extensionsStr != "" {
 		// get variables map
 		variables := make(map[string]interface{}, len(values))
 		variablesStr := values.Get("variables")
-		json.Unmarshal([]byte(variablesStr), &variables)
+		c.Unmarshal([]byte(variablesStr), &variables)
+
+		var extensions map[string]interface{}
+		if extensionsStr != "" {
+			c.Unmarshal([]byte(extensionsStr), &extensions)
+		}
 
 		return &RequestOptions{
 			Query:         query,
 			Variables:     variables,
 			OperationName: values.Get("operationName"),
+			DocumentID:    documentID,
+			Extensions:    extensions,
 		}
 	}
 
@@ -53,66 +75,18 @@ func getFromForm(values url.Values) *RequestOptions {
 
 // NewRequestOptions Parses a http.Request into GraphQL request options struct
 func NewRequestOptions(r *http.Request) *RequestOptions {
-	if reqOpt := getFromForm(r.URL.Query()); reqOpt != nil {
-		return reqOpt
-	}
-
-	if r.Method != http.MethodPost {
-		return &RequestOptions{}
-	}
-
-	if r.Body == nil {
-		return &RequestOptions{}
-	}
-
-	// TODO: improve Content-Type handling
-	contentTypeStr := r.Header.Get("Content-Type")
-	contentTypeTokens := strings.Split(contentTypeStr, ";")
-	contentType := contentTypeTokens[0]
-
-	switch contentType {
-	case ContentTypeGraphQL:
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return &RequestOptions{}
-		}
-		return &RequestOptions{
-			Query: string(body),
-		}
-	case ContentTypeFormURLEncoded:
-		if err := r.ParseForm(); err != nil {
-			return &RequestOptions{}
-		}
-
-		if reqOpt := getFromForm(r.PostForm); reqOpt != nil {
-			return reqOpt
-		}
-
-		return &RequestOptions{}
-
-	case ContentTypeJSON:
-		fallthrough
-	default:
-		var opts RequestOptions
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return &opts
-		}
-		err = json.Unmarshal(body, &opts)
-		if err != nil {
-			// Probably `variables` was sent as a string instead of an object.
-			// So, we try to be polite and try to parse that as a JSON string
-			var optsCompatible requestOptionsCompatibility
-			json.Unmarshal(body, &optsCompatible)
-			json.Unmarshal([]byte(optsCompatible.Variables), &opts.Variables)
-		}
-		return &opts
-	}
+	return parseRequestOptions(r, codec.Default, false)
 }
 
 // GetRequestOptions Parses a http.Request into GraphQL request options struct without clearning the body
 func GetRequestOptions(r *http.Request) *RequestOptions {
-	if reqOpt := getFromForm(r.URL.Query()); reqOpt != nil {
+	return parseRequestOptions(r, codec.Default, true)
+}
+
+// parseRequestOptions parses a http.Request into GraphQL request options
+// using the codec, optionally restoring the body so that it can be read again
+func parseRequestOptions(r *http.Request, c codec.Codec, restoreBody bool) *RequestOptions {
+	if reqOpt := getFromForm(r.URL.Query(), c); reqOpt != nil {
 		return reqOpt
 	}
 
@@ -135,7 +109,9 @@ func GetRequestOptions(r *http.Request) *RequestOptions {
 		if err != nil {
 			return &RequestOptions{}
 		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		if restoreBody {
+			r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		}
 		return &RequestOptions{
 			Query: string(body),
 		}
@@ -144,7 +120,7 @@ func GetRequestOptions(r *http.Request) *RequestOptions {
 			return &RequestOptions{}
 		}
 
-		if reqOpt := getFromForm(r.PostForm); reqOpt != nil {
+		if reqOpt := getFromForm(r.PostForm, c); reqOpt != nil {
 			return reqOpt
 		}
 
@@ -158,14 +134,16 @@ func GetRequestOptions(r *http.Request) *RequestOptions {
 		if err != nil {
 			return &opts
 		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
-		err = json.Unmarshal(body, &opts)
+		if restoreBody {
+			r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		}
+		err = c.Unmarshal(body, &opts)
 		if err != nil {
 			// Probably `variables` was sent as a string instead of an object.
 			// So, we try to be polite and try to parse that as a JSON string
 			var optsCompatible requestOptionsCompatibility
-			json.Unmarshal(body, &optsCompatible)
-			json.Unmarshal([]byte(optsCompatible.Variables), &opts.Variables)
+			c.Unmarshal(body, &optsCompatible)
+			c.Unmarshal([]byte(optsCompatible.Variables), &opts.Variables)
 		}
 		return &opts
 	}
@@ -174,10 +152,43 @@ func GetRequestOptions(r *http.Request) *RequestOptions {
 // ContextHandler provides an entrypoint into executing graphQL queries with a
 // user-provided context.
 func (s *Server) ContextHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// enforce the http rate limit
+	if s.httpLimiter != nil {
+		if key := s.options.RateLimit.HTTPKeyFunc(r); key != "" && !s.httpLimiter.Allow(key) {
+			s.log.WithField("key", key).Warnf("http request rate limit exceeded")
+			s.writeError(w, http.StatusTooManyRequests, gqlerror.New(gqlerror.CodeRateLimited, "rate limit exceeded"))
+			return
+		}
+	}
+
 	// get query
-	opts := NewRequestOptions(r)
+	opts := parseRequestOptions(r, s.codec, false)
+
+	// resolve the trusted document
+	query, err := s.options.TrustedDocuments.Resolve(opts.Query, opts.DocumentID, opts.Extensions)
+	if err != nil {
+		s.log.WithError(err).Warnf("untrusted document rejected")
+		// clients retry unknown persisted queries with the full query, they
+		// expect the error in a 200 response like any other graphql error
+		status := http.StatusBadRequest
+		if errors.Is(err, trusted.ErrDocumentNotFound) {
+			status = http.StatusOK
+		}
+		s.writeError(w, status, err)
+		return
+	}
+	opts.Query = query
+
+	// start the operation span before parsing so that parse failures are
+	// recorded
+	ctx, span := s.tracer.StartOperation(s.tracer.ExtractHTTP(ctx, r.Header), "graphql.http")
+	ctx, op := s.metrics.StartOperation(ctx, metrics.TransportHTTP)
+	ctx, access := s.accessLog.StartOperation(ctx, accesslog.Entry{
+		Transport:  metrics.TransportHTTP,
+		RemoteAddr: r.RemoteAddr,
+	})
+	ctx = s.timing.OptIn(ctx, r)
 
-	// execute graphql query
 	params := graphql.Params{
 		Schema:         s.schema,
 		RequestString:  opts.Query,
@@ -186,7 +197,41 @@ func (s *Server) ContextHandler(ctx context.Context, w http.ResponseWriter, r *h
 		Context:        ctx,
 	}
 
-	if s.options.RootValueFunc != nil {
+	// apply the operation hook shared with websockets
+	var (
+		rootValue map[string]interface{}
+		doc       *document.Document
+	)
+	if s.options.OperationFunc != nil {
+		// the document is parsed once for the hook and the execution
+		var operation *ast.OperationDefinition
+		if doc, err = s.executor.Parse(ctx, &params); err == nil {
+			operation, _ = utils.GetOperationAST(doc.AST, opts.OperationName)
+		}
+
+		opCtx, root, err := s.options.OperationFunc(ctx, protocol.OperationInfo{
+			Request:   r,
+			Operation: operation,
+			Params:    &params,
+		})
+		if err != nil {
+			tracing.EndOperation(span, 1)
+			op.End(1)
+			access.End(1)
+			s.log.WithError(err).Errorf("operation hook failed")
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if opCtx != nil {
+			ctx = opCtx
+			params.Context = ctx
+		}
+		rootValue = root
+	}
+
+	if rootValue != nil {
+		params.RootObject = rootValue
+	} else if s.options.RootValueFunc != nil {
 		params.RootObject = s.options.RootValueFunc(ctx, r)
 	}
 
@@ -194,15 +239,13 @@ func (s *Server) ContextHandler(ctx context.Context, w http.ResponseWriter, r *h
 		params.RootObject = map[string]interface{}{}
 	}
 
-	result := graphql.Do(params)
+	result, status := s.execute(ctx, params, doc)
 
-	if formatErrorFunc := s.options.FormatErrorFunc; formatErrorFunc != nil && len(result.Errors) > 0 {
-		formatted := make([]gqlerrors.FormattedError, len(result.Errors))
-		for i, formattedError := range result.Errors {
-			formatted[i] = formatErrorFunc(formattedError.OriginalError())
-		}
-		result.Errors = formatted
-	}
+	result.Errors = s.errors.FormatErrors(result.Errors)
+
+	tracing.EndOperation(span, len(result.Errors))
+	op.End(len(result.Errors))
+	access.End(len(result.Errors))
 
 	if s.options.GraphiQL != nil {
 		acceptHeader := r.Header.Get("Accept")
@@ -222,104 +265,169 @@ func (s *Server) ContextHandler(ctx context.Context, w http.ResponseWriter, r *h
 
 	// use proper JSON Header
 	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	// stream the response when the bytes are not needed by a callback
+	if s.options.ResultCallbackFunc == nil {
+		enc := s.codec.NewEncoder(&trimNewlineWriter{w: w})
+		if s.options.Pretty {
+			enc.SetIndent("", "\t")
+		}
+		if err := enc.Encode(result); err != nil {
+			s.log.WithError(err).Errorf("failed to encode response")
+		}
+		return
+	}
 
 	var buff []byte
 	if s.options.Pretty {
-		w.WriteHeader(http.StatusOK)
-		buff, _ = json.MarshalIndent(result, "", "\t")
-
-		w.Write(buff)
+		var b bytes.Buffer
+		enc := s.codec.NewEncoder(&trimNewlineWriter{w: &b})
+		enc.SetIndent("", "\t")
+		enc.Encode(result)
+		buff = b.Bytes()
 	} else {
-		w.WriteHeader(http.StatusOK)
-		buff, _ = json.Marshal(result)
+		buff, _ = s.codec.Marshal(result)
+	}
+
+	w.Write(buff)
+	s.options.ResultCallbackFunc(ctx, &params, result, buff)
+}
+
+// execute executes the operation using the document when it has already
+// been parsed, a panic is recovered and returned as an internal server error
+func (s *Server) execute(ctx context.Context, params graphql.Params, doc *document.Document) (result *graphql.Result, status int) {
+	defer recovery.Recover(ctx, s.options.PanicHandler, func(err *recovery.Error) {
+		s.log.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic in http operation")
+		result = &graphql.Result{Errors: gqlerrors.FormatErrors(recovery.ErrInternal)}
+		status = http.StatusInternalServerError
+	})
+
+	if doc != nil {
+		return s.executor.Execute(params, doc), http.StatusOK
+	}
+	return s.executor.Do(params), http.StatusOK
+}
+
+// trimNewlineWriter drops the newline encoders write after each value so
+// encoded responses match marshaled ones
+type trimNewlineWriter struct {
+	w       io.Writer
+	pending bool
+}
+
+// Write holds back a trailing newline until more bytes are written
+func (t *trimNewlineWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if n == 0 {
+		return 0, nil
+	}
 
-		w.Write(buff)
+	if t.pending {
+		if _, err := t.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+		t.pending = false
+	}
+
+	if b[n-1] == '\n' {
+		t.pending = true
+		b = b[:n-1]
 	}
 
-	if s.options.ResultCallbackFunc != nil {
-		s.options.ResultCallbackFunc(ctx, &params, result, buff)
+	if _, err := t.w.Write(b); err != nil {
+		return 0, err
 	}
+	return n, nil
+}
+
+// writeError writes a graphql error response with the status code
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	buff, _ := s.codec.Marshal(&graphql.Result{
+		Errors: s.errors.FormatErrors(gqlerrors.FormatErrors(err)),
+	})
+	w.Write(buff)
 }
 
 // WSHandler handles websocket connection upgrade
 func (s *Server) WSHandler(w http.ResponseWriter, r *http.Request) {
+	s.ContextWSHandler(s.requestContext(r), w, r)
+}
+
+// ContextWSHandler handles websocket connection upgrade with a
+// user-provided context. The context is the parent of every operation
+// context on the connection
+func (s *Server) ContextWSHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	// enforce the per ip connection limit before upgrading
+	release := func() {}
+	if s.connLimiter != nil {
+		ip := s.remoteIP(r)
+		rel, ok := s.connLimiter.Acquire(ip)
+		if !ok {
+			s.log.WithField("remoteIp", ip).Warnf("websocket connection limit exceeded")
+			s.writeError(w, http.StatusTooManyRequests, gqlerror.New(gqlerror.CodeRateLimited, "too many connections"))
+			return
+		}
+		release = rel
+	}
+
+	// negotiate the subprotocol from the registered protocols, the
+	// upgrader picks the first protocol in priority order the client
+	// requested
+	upgrader := s.upgrader
+	upgrader.Subprotocols = s.protocols.names()
+
 	// Establish a WebSocket connection
 	s.log.Debugf("upgrading connection to websocket")
-	var ws, err = s.upgrader.Upgrade(w, r, nil)
+	var ws, err = upgrader.Upgrade(w, r, nil)
 
 	// Bail out if the WebSocket connection could not be established
 	if err != nil {
-		s.log.Warnf("Failed to establish WebSocket connection", err)
+		release()
+		s.log.WithError(err).Warnf("Failed to establish WebSocket connection")
 		return
 	}
 
 	s.log.Debugf("Client requested %q subprotocol", ws.Subprotocol())
 
-	// Close the connection early if it doesn't implement a supported protocol
-	switch ws.Subprotocol() {
-	// graphql-ws protocol
-	case graphqlws.Subprotocol:
-		if s.options.GraphQLWS == nil {
-			s.log.Warnf("Connection does not implement the GraphQL WS protocol. Subprotocol: %q", ws.Subprotocol())
-			s.closeWS(
-				ws,
-				websocket.CloseProtocolError,
-				"server does not support %q protocol",
-				ws.Subprotocol(),
-			)
-			return
-		}
+	// clients that do not request a subprotocol are served the default
+	// protocol, clients that request only unsupported subprotocols are
+	// closed
+	var (
+		p  Protocol
+		ok bool
+	)
+	if ws.Subprotocol() != "" || len(websocket.Subprotocols(r)) == 0 {
+		p, ok = s.protocols.get(ws.Subprotocol())
+	}
 
-		graphqlws.NewConnection(r.Context(), graphqlws.Config{
-			WS:                  ws,
-			Schema:              &s.schema,
-			Logger:              s.log,
-			Request:             r,
-			KeepAlive:           s.options.GraphQLWS.KeepAlive,
-			RootValueFunc:       s.options.GraphQLWS.RootValueFunc,
-			ContextValueFunc:    s.options.GraphQLWS.ContextValueFunc,
-			OnConnect:           s.options.GraphQLWS.OnConnect,
-			OnDisconnect:        s.options.GraphQLWS.OnDisconnect,
-			OnOperation:         s.options.GraphQLWS.OnOperation,
-			OnOperationComplete: s.options.GraphQLWS.OnOperationComplete,
-		})
+	if !ok {
+		release()
+		s.log.Warnf("Connection does not implement the GraphQL WS protocol. Subprotocol: %q", ws.Subprotocol())
+		s.closeWS(ws, websocket.CloseProtocolError, "Connection does not implement a supported GraphQL subprotocol")
+		return
+	}
 
-	// graphql-transport-ws protocol
-	case graphqltransportws.Subprotocol:
-		if s.options.GraphQLTransportWS == nil {
-			s.log.Warnf("Connection does not implement the GraphQL WS protocol. Subprotocol: %q", ws.Subprotocol())
-			s.closeWS(
-				ws,
-				websocket.CloseProtocolError,
-				"server does not support %q protocol",
-				ws.Subprotocol(),
-			)
-			return
-		}
+	p.Serve(ctx, &WSConn{
+		Transport: gorillatransport.New(ws),
+		WS:        ws,
+		Request:   r,
+		release:   release,
+	})
+}
 
-		graphqltransportws.NewConnection(r.Context(), graphqltransportws.Config{
-			WS:                        ws,
-			Schema:                    &s.schema,
-			Logger:                    s.log,
-			Request:                   r,
-			ConnectionInitWaitTimeout: s.options.GraphQLTransportWS.ConnectionInitWaitTimeout,
-			RootValueFunc:             s.options.GraphQLTransportWS.RootValueFunc,
-			ContextValueFunc:          s.options.GraphQLTransportWS.ContextValueFunc,
-			OnConnect:                 s.options.GraphQLTransportWS.OnConnect,
-			OnPing:                    s.options.GraphQLTransportWS.OnPing,
-			OnPong:                    s.options.GraphQLTransportWS.OnPong,
-			OnDisconnect:              s.options.GraphQLTransportWS.OnDisconnect,
-			OnClose:                   s.options.GraphQLTransportWS.OnClose,
-			OnSubscribe:               s.options.GraphQLTransportWS.OnSubscribe,
-			OnNext:                    s.options.GraphQLTransportWS.OnNext,
-			OnError:                   s.options.GraphQLTransportWS.OnError,
-			OnComplete:                s.options.GraphQLTransportWS.OnComplete,
-			OnOperation:               s.options.GraphQLTransportWS.OnOperation,
-		})
+// wsRootValueFunc returns the protocol root value func falling back to
+// the server RootValueFunc
+func (s *Server) wsRootValueFunc(f func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}) func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{} {
+	if f != nil || s.options.RootValueFunc == nil {
+		return f
+	}
 
-	default:
-		s.log.Warnf("Connection does not implement the GraphQL WS protocol. Subprotocol: %q", ws.Subprotocol())
-		s.closeWS(ws, websocket.CloseProtocolError, "Connection does not implement a supported GraphQL subprotocol")
+	return func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{} {
+		return s.options.RootValueFunc(ctx, r)
 	}
 }
 
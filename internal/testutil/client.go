@@ -0,0 +1,104 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+)
+
+// ReadTimeout bounds how long a test client waits for a message
+var ReadTimeout = 5 * time.Second
+
+// Client is the client end of an in-memory websocket connection
+type Client struct {
+	t    testing.TB
+	Conn *transport.PipeConn
+}
+
+// NewClient wraps the client end of a transport pipe
+func NewClient(t testing.TB, conn *transport.PipeConn) *Client {
+	return &Client{t: t, Conn: conn}
+}
+
+// Send writes a raw message failing the test if the write fails
+func (c *Client) Send(msg string) {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ReadTimeout)
+	defer cancel()
+
+	if err := c.Conn.WriteMessage(ctx, []byte(msg)); err != nil {
+		c.t.Fatalf("failed to send %s: %v", msg, err)
+	}
+}
+
+// Read reads the next message failing the test if none is received
+func (c *Client) Read() protocol.OperationMessage {
+	c.t.Helper()
+
+	msg, err := c.Next()
+	if err != nil {
+		c.t.Fatalf("failed to read message: %v", err)
+	}
+	return msg
+}
+
+// Next reads the next message returning the read error, which is a
+// *transport.CloseError once the server closes the connection
+func (c *Client) Next() (protocol.OperationMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ReadTimeout)
+	defer cancel()
+
+	msg := protocol.OperationMessage{}
+	b, err := c.Conn.ReadMessage(ctx)
+	if err != nil {
+		return msg, err
+	}
+
+	err = json.Unmarshal(b, &msg)
+	return msg, err
+}
+
+// Expect reads the next message failing the test unless it has the type
+func (c *Client) Expect(msgType protocol.MessageType) protocol.OperationMessage {
+	c.t.Helper()
+
+	msg := c.Read()
+	if msg.Type != msgType {
+		c.t.Fatalf("expected %s, got %+v", msgType, msg)
+	}
+	return msg
+}
+
+// ExpectClose reads until the server closes the connection failing the
+// test unless it closes with the code
+func (c *Client) ExpectClose(code int) {
+	c.t.Helper()
+
+	for {
+		msg, err := c.Next()
+		if err == nil {
+			continue
+		}
+		if !transport.IsCloseError(err, code) {
+			c.t.Fatalf("expected close %d, got %v (last message %+v)", code, err, msg)
+		}
+		return
+	}
+}
+
+// Close closes the client end of the connection
+func (c *Client) Close(code int) {
+	c.Conn.Close(code, "")
+}
+
+// Data returns the data of a next or data message payload
+func Data(msg protocol.OperationMessage) map[string]interface{} {
+	payload, _ := msg.Payload.(map[string]interface{})
+	data, _ := payload["data"].(map[string]interface{})
+	return data
+}
@@ -0,0 +1,103 @@
+// Package testutil provides the fixtures shared by the package tests
+package testutil
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Schema configures a test schema. The query type always has a hello
+// field resolving to world, Query fields are added to it and replace
+// hello when they share its name
+type Schema struct {
+	Query        graphql.Fields
+	Mutation     graphql.Fields
+	Subscription graphql.Fields
+	Directives   []*graphql.Directive
+}
+
+// NewSchema creates a test schema failing the test if it is invalid
+func NewSchema(t testing.TB, config *Schema) graphql.Schema {
+	t.Helper()
+
+	if config == nil {
+		config = &Schema{}
+	}
+
+	query := graphql.Fields{
+		"hello": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return "world", nil
+			},
+		},
+	}
+	for name, field := range config.Query {
+		query[name] = field
+	}
+
+	schemaConfig := graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Query",
+			Fields: query,
+		}),
+	}
+
+	if len(config.Mutation) > 0 {
+		schemaConfig.Mutation = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Mutation",
+			Fields: config.Mutation,
+		})
+	}
+
+	if len(config.Subscription) > 0 {
+		schemaConfig.Subscription = graphql.NewObject(graphql.ObjectConfig{
+			Name:   "Subscription",
+			Fields: config.Subscription,
+		})
+	}
+
+	if len(config.Directives) > 0 {
+		schemaConfig.Directives = append(graphql.SpecifiedDirectives, config.Directives...)
+	}
+
+	schema, err := graphql.NewSchema(schemaConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+// Hello creates a schema with only the hello query
+func Hello(t testing.TB) graphql.Schema {
+	t.Helper()
+	return NewSchema(t, nil)
+}
+
+// Events returns an Int subscription field that resolves each event
+// received on the channel
+func Events(events chan interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.Int,
+		Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+			return events, nil
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source, nil
+		},
+	}
+}
+
+// Blocking returns a String field whose resolver blocks until the
+// operation context is done and returns its error
+func Blocking() *graphql.Field {
+	return &graphql.Field{
+		Type: graphql.String,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			<-p.Context.Done()
+			return nil, p.Context.Err()
+		},
+	}
+}
@@ -0,0 +1,287 @@
+// Package live implements live queries. A query marked with the @live
+// directive is executed and then re-executed whenever a resource it
+// touched is invalidated, a result is only sent when it has changed.
+//
+// Resolvers record the resources they read with Track before reading
+// them and the application calls Invalidate when the resources change
+package live
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// ExtensionName is the result extensions key of the live query state
+const ExtensionName = "live"
+
+// Directive is the @live directive marking a query as live. It must be
+// added to the schema directives to be used
+var Directive = graphql.NewDirective(graphql.DirectiveConfig{
+	Name:        "live",
+	Description: "Re-sends the query result whenever it changes.",
+	Locations:   []string{graphql.DirectiveLocationQuery},
+})
+
+// Options configures live queries. When Patches is set, results after
+// the first are sent as JSON patches of the previous result in the
+// result extensions rather than as the full result
+type Options struct {
+	Patches bool
+}
+
+// IsLive returns true if the operation is a live query
+func IsLive(op *ast.OperationDefinition) bool {
+	if op == nil || op.Operation != ast.OperationTypeQuery {
+		return false
+	}
+
+	for _, directive := range op.Directives {
+		if directive.Name != nil && directive.Name.Value == Directive.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry executes live queries and tracks the resources they touched
+type Registry struct {
+	mx       sync.Mutex
+	executor *document.Executor
+	patches  bool
+	watchers map[string]map[*watcher]struct{}
+}
+
+// watcher is a running live query
+type watcher struct {
+	invalidated chan struct{}
+	keys        map[string]struct{}
+}
+
+// New creates a new registry executing queries with the executor
+func New(executor *document.Executor, opts *Options) *Registry {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	return &Registry{
+		executor: executor,
+		patches:  opts.Patches,
+		watchers: map[string]map[*watcher]struct{}{},
+	}
+}
+
+type trackerKey struct{}
+
+// tracker records the resources touched by an execution
+type tracker struct {
+	mx   sync.Mutex
+	r    *Registry
+	w    *watcher
+	keys map[string]struct{}
+}
+
+// Track records that the live query executing with the context touched
+// the resources. It has no effect outside of a live query
+func Track(ctx context.Context, keys ...string) {
+	t, ok := ctx.Value(trackerKey{}).(*tracker)
+	if !ok {
+		return
+	}
+
+	t.mx.Lock()
+	for _, key := range keys {
+		t.keys[key] = struct{}{}
+	}
+	t.mx.Unlock()
+
+	t.r.add(t.w, keys...)
+}
+
+// add watches the resources
+func (r *Registry) add(w *watcher, keys ...string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for _, key := range keys {
+		watchers, ok := r.watchers[key]
+		if !ok {
+			watchers = map[*watcher]struct{}{}
+			r.watchers[key] = watchers
+		}
+		watchers[w] = struct{}{}
+		w.keys[key] = struct{}{}
+	}
+}
+
+// remove stops watching the resources not in keep
+func (r *Registry) remove(w *watcher, keep map[string]struct{}) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for key := range w.keys {
+		if _, ok := keep[key]; ok {
+			continue
+		}
+
+		delete(w.keys, key)
+		if watchers, ok := r.watchers[key]; ok {
+			delete(watchers, w)
+			if len(watchers) == 0 {
+				delete(r.watchers, key)
+			}
+		}
+	}
+}
+
+// Invalidate re-executes the live queries that touched the resources
+func (r *Registry) Invalidate(keys ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for _, key := range keys {
+		for w := range r.watchers[key] {
+			// pending invalidations are coalesced into one execution
+			select {
+			case w.invalidated <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Watch executes the live query and re-executes it whenever a resource
+// it touched is invalidated until the params context is done. Each
+// changed result is sent on the returned channel. The document is the
+// query parsed by the executor, it is not parsed again on re-execution
+func (r *Registry) Watch(p graphql.Params, d *document.Document) chan *graphql.Result {
+	ctx := p.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	w := &watcher{
+		invalidated: make(chan struct{}, 1),
+		keys:        map[string]struct{}{},
+	}
+
+	ch := make(chan *graphql.Result)
+	go r.watch(ctx, w, p, d, ch)
+	return ch
+}
+
+// watch runs the live query
+func (r *Registry) watch(ctx context.Context, w *watcher, p graphql.Params, d *document.Document, ch chan *graphql.Result) {
+	defer close(ch)
+	defer r.remove(w, nil)
+
+	var (
+		last     []byte
+		lastData interface{}
+		revision int
+	)
+
+	for {
+		res := r.execute(ctx, w, p, d)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// extensions such as timings differ between executions
+		b, err := json.Marshal(graphql.Result{Data: res.Data, Errors: res.Errors})
+		if err != nil || !bytes.Equal(b, last) {
+			data := normalize(res.Data)
+
+			// send a patch of the previous result when neither has errors
+			out := &graphql.Result{
+				Data:       res.Data,
+				Errors:     res.Errors,
+				Extensions: extensions(res, revision, nil),
+			}
+			if r.patches && len(res.Errors) == 0 && lastData != nil {
+				out = &graphql.Result{
+					Extensions: extensions(res, revision, Diff(lastData, data)),
+				}
+			}
+
+			select {
+			case ch <- out:
+			case <-ctx.Done():
+				return
+			}
+
+			last = b
+			lastData = nil
+			if len(res.Errors) == 0 {
+				lastData = data
+			}
+			revision++
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.invalidated:
+		}
+	}
+}
+
+// execute executes the query tracking the resources it touches
+func (r *Registry) execute(ctx context.Context, w *watcher, p graphql.Params, d *document.Document) *graphql.Result {
+	t := &tracker{
+		r:    r,
+		w:    w,
+		keys: map[string]struct{}{},
+	}
+
+	p.Context = context.WithValue(ctx, trackerKey{}, t)
+	res := r.executor.Execute(p, d)
+
+	// resources no longer touched are not watched
+	t.mx.Lock()
+	keys := t.keys
+	t.mx.Unlock()
+	r.remove(w, keys)
+
+	return res
+}
+
+// extensions returns the result extensions with the live query state
+func extensions(res *graphql.Result, revision int, patch []Operation) map[string]interface{} {
+	ext := map[string]interface{}{}
+	for key, val := range res.Extensions {
+		ext[key] = val
+	}
+
+	state := map[string]interface{}{
+		"revision": revision,
+	}
+	if patch != nil {
+		state["patch"] = patch
+	}
+	ext[ExtensionName] = state
+	return ext
+}
+
+// normalize converts the data to generic json values
+func normalize(data interface{}) interface{} {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,93 @@
+package live_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/live"
+	"github.com/graphql-go/graphql"
+)
+
+func TestLiveQuery(t *testing.T) {
+	var (
+		mx         sync.Mutex
+		name       = "alice"
+		executions = make(chan struct{}, 10)
+	)
+
+	user := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Directives: []*graphql.Directive{live.Directive},
+		Query: graphql.Fields{
+			"user": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					live.Track(p.Context, "user:1")
+					executions <- struct{}{}
+
+					mx.Lock()
+					defer mx.Unlock()
+					return map[string]interface{}{"name": name}, nil
+				},
+			},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := live.New(nil, &live.Options{Patches: true})
+	p := graphql.Params{
+		Schema:        schema,
+		RequestString: `query @live { user { name } }`,
+		Context:       ctx,
+	}
+
+	var executor *document.Executor
+	d, err := executor.Parse(ctx, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := r.Watch(p, d)
+
+	res := <-ch
+	<-executions
+	if len(res.Errors) > 0 || res.Data.(map[string]interface{})["user"] == nil {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	// unchanged results and unrelated resources are not sent
+	r.Invalidate("user:1", "user:2")
+	<-executions
+	select {
+	case res := <-ch:
+		t.Fatalf("unexpected result %+v", res)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mx.Lock()
+	name = "bob"
+	mx.Unlock()
+	r.Invalidate("user:1")
+
+	res = <-ch
+	<-executions
+	b, _ := json.Marshal(res.Extensions[live.ExtensionName])
+	if want := `{"patch":[{"op":"replace","path":"/user/name","value":"bob"}],"revision":1}`; string(b) != want {
+		t.Errorf("expected %s, got %s", want, b)
+	}
+
+	cancel()
+	if _, more := <-ch; more {
+		t.Error("expected the live query to end")
+	}
+}
@@ -0,0 +1,90 @@
+package live
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is a JSON patch operation as defined by RFC 6902
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON omits the value of remove operations
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(map[string]string{
+			"op":   o.Op,
+			"path": o.Path,
+		})
+	}
+
+	type operation Operation
+	return json.Marshal(operation(o))
+}
+
+// pointerEscaper escapes JSON pointer reference tokens
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// Diff returns the JSON patch that transforms from into to. Both values
+// must be generic json values as produced by json.Unmarshal. Arrays that
+// change length are replaced
+func Diff(from, to interface{}) []Operation {
+	return diff("", from, to, []Operation{})
+}
+
+func diff(path string, from, to interface{}, ops []Operation) []Operation {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		t, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		for _, key := range sortedKeys(f) {
+			keyPath := path + "/" + pointerEscaper.Replace(key)
+			if val, ok := t[key]; ok {
+				ops = diff(keyPath, f[key], val, ops)
+			} else {
+				ops = append(ops, Operation{Op: "remove", Path: keyPath})
+			}
+		}
+
+		for _, key := range sortedKeys(t) {
+			if _, ok := f[key]; !ok {
+				ops = append(ops, Operation{Op: "add", Path: path + "/" + pointerEscaper.Replace(key), Value: t[key]})
+			}
+		}
+		return ops
+
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok || len(t) != len(f) {
+			break
+		}
+
+		for i := range f {
+			ops = diff(path+"/"+strconv.Itoa(i), f[i], t[i], ops)
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		ops = append(ops, Operation{Op: "replace", Path: path, Value: to})
+	}
+	return ops
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
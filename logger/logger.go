@@ -1,9 +1,13 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // Level type
@@ -47,40 +51,83 @@ func NewNoopLogger() *LogWrapper {
 	return NewLogWrapper(NoopLogFunc, map[string]interface{}{})
 }
 
-// NewSimpleLogFunc returns a simple logging func
+// NewSimpleLogFunc returns a simple logging func that writes logfmt style
+// lines to stdout
 func NewSimpleLogFunc(level Level) LogFunc {
+	return NewTextLogFunc(os.Stdout, level)
+}
+
+// NewTextLogFunc returns a logging func that writes logfmt style lines
+// to the writer
+func NewTextLogFunc(w io.Writer, level Level) LogFunc {
+	var mx sync.Mutex
+
 	return func(payload LogPayload) {
 		if level < payload.Level {
 			return
 		}
 
-		fields := []string{}
-		m := map[string]interface{}{}
-		keys := []string{"msg", "level", "error"}
+		m := payloadMap(payload)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
 
-		for k, v := range payload.Fields {
-			if k != "msg" && k != "level" {
-				keys = append(keys, k)
-				m[k] = v
-			}
+		fields := make([]string, 0, len(keys))
+		for _, k := range keys {
+			fields = append(fields, fmt.Sprintf("%s=%q", k, fmt.Sprint(m[k])))
 		}
 
-		m["msg"] = payload.Message
-		m["level"] = LevelMap[level]
+		mx.Lock()
+		defer mx.Unlock()
+		fmt.Fprintln(w, strings.Join(fields, " "))
+	}
+}
+
+// NewJSONLogFunc returns a logging func that writes one JSON object per
+// line to the writer
+func NewJSONLogFunc(w io.Writer, level Level) LogFunc {
+	var mx sync.Mutex
 
-		if payload.Error != nil {
-			m["error"] = payload.Error
+	return func(payload LogPayload) {
+		if level < payload.Level {
+			return
 		}
 
-		sort.Strings(keys)
+		b, err := json.Marshal(payloadMap(payload))
+		if err != nil {
+			b, _ = json.Marshal(map[string]interface{}{
+				"msg":   payload.Message,
+				"level": LevelMap[payload.Level],
+				"error": fmt.Sprintf("failed to marshal log fields: %s", err),
+			})
+		}
 
-		for _, k := range keys {
-			v := m[k]
-			fields = append(fields, fmt.Sprintf("%s=%q", k, v))
+		mx.Lock()
+		defer mx.Unlock()
+		w.Write(append(b, '\n'))
+	}
+}
+
+// payloadMap flattens the payload fields, message, level and error into
+// a single map
+func payloadMap(payload LogPayload) map[string]interface{} {
+	m := map[string]interface{}{}
+	for k, v := range payload.Fields {
+		if k != "msg" && k != "level" && k != "error" {
+			m[k] = v
 		}
+	}
 
-		fmt.Println(strings.Join(fields, " "))
+	m["msg"] = payload.Message
+	m["level"] = LevelMap[payload.Level]
+
+	if payload.Error != nil {
+		m["error"] = payload.Error.Error()
 	}
+
+	return m
 }
 
 type LogWrapper struct {
@@ -0,0 +1,33 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/logger"
+)
+
+func TestJSONLogFunc(t *testing.T) {
+	var buf bytes.Buffer
+	log := logger.NewLogWrapper(logger.NewJSONLogFunc(&buf, logger.DebugLevel), nil)
+
+	log.WithField("id", "1").WithError(errors.New("boom")).Warnf("hello %s", "world")
+	log.Tracef("filtered")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d", len(lines))
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m["level"] != "warn" || m["msg"] != "hello world" || m["error"] != "boom" || m["id"] != "1" {
+		t.Fatalf("unexpected log line %v", m)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/bhoriuchi/graphql-go-server/logger/logruslogger
+
+go 1.21
+
+require (
+	github.com/bhoriuchi/graphql-go-server v0.0.0-00010101000000-000000000000
+	github.com/sirupsen/logrus v1.9.3
+)
+
+require golang.org/x/sys v0.21.0 // indirect
+
+replace github.com/bhoriuchi/graphql-go-server => ../..
@@ -0,0 +1,34 @@
+package logruslogger
+
+import (
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Level maps a level to a logrus level
+func Level(level logger.Level) logrus.Level {
+	switch level {
+	case logger.ErrorLevel:
+		return logrus.ErrorLevel
+	case logger.WarnLevel:
+		return logrus.WarnLevel
+	case logger.InfoLevel:
+		return logrus.InfoLevel
+	case logger.DebugLevel:
+		return logrus.DebugLevel
+	default:
+		return logrus.TraceLevel
+	}
+}
+
+// New returns a logging func that logs to the logrus logger
+func New(l logrus.FieldLogger) logger.LogFunc {
+	return func(payload logger.LogPayload) {
+		entry := l.WithFields(logrus.Fields(payload.Fields))
+		if payload.Error != nil {
+			entry = entry.WithError(payload.Error)
+		}
+
+		entry.Log(Level(payload.Level), payload.Message)
+	}
+}
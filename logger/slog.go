@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLevelTrace is the slog level used for trace payloads
+const SlogLevelTrace = slog.LevelDebug - 4
+
+// SlogLevel maps a level to a slog level
+func SlogLevel(level Level) slog.Level {
+	switch level {
+	case ErrorLevel:
+		return slog.LevelError
+	case WarnLevel:
+		return slog.LevelWarn
+	case InfoLevel:
+		return slog.LevelInfo
+	case DebugLevel:
+		return slog.LevelDebug
+	default:
+		return SlogLevelTrace
+	}
+}
+
+// NewSlogFunc returns a logging func that logs to the slog logger, a nil
+// logger uses slog.Default
+func NewSlogFunc(l *slog.Logger) LogFunc {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return func(payload LogPayload) {
+		level := SlogLevel(payload.Level)
+		ctx := context.Background()
+		if !l.Enabled(ctx, level) {
+			return
+		}
+
+		attrs := make([]slog.Attr, 0, len(payload.Fields)+1)
+		for k, v := range payload.Fields {
+			attrs = append(attrs, slog.Any(k, v))
+		}
+		if payload.Error != nil {
+			attrs = append(attrs, slog.Any("error", payload.Error))
+		}
+
+		l.LogAttrs(ctx, level, payload.Message, attrs...)
+	}
+}
@@ -0,0 +1,42 @@
+package zaplogger
+
+import (
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Level maps a level to a zap level, zap has no trace level so trace
+// payloads are logged at debug level
+func Level(level logger.Level) zapcore.Level {
+	switch level {
+	case logger.ErrorLevel:
+		return zapcore.ErrorLevel
+	case logger.WarnLevel:
+		return zapcore.WarnLevel
+	case logger.InfoLevel:
+		return zapcore.InfoLevel
+	default:
+		return zapcore.DebugLevel
+	}
+}
+
+// New returns a logging func that logs to the zap logger
+func New(l *zap.Logger) logger.LogFunc {
+	return func(payload logger.LogPayload) {
+		ce := l.Check(Level(payload.Level), payload.Message)
+		if ce == nil {
+			return
+		}
+
+		fields := make([]zap.Field, 0, len(payload.Fields)+1)
+		for k, v := range payload.Fields {
+			fields = append(fields, zap.Any(k, v))
+		}
+		if payload.Error != nil {
+			fields = append(fields, zap.Error(payload.Error))
+		}
+
+		ce.Write(fields...)
+	}
+}
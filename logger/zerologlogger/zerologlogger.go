@@ -0,0 +1,38 @@
+package zerologlogger
+
+import (
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/rs/zerolog"
+)
+
+// Level maps a level to a zerolog level
+func Level(level logger.Level) zerolog.Level {
+	switch level {
+	case logger.ErrorLevel:
+		return zerolog.ErrorLevel
+	case logger.WarnLevel:
+		return zerolog.WarnLevel
+	case logger.InfoLevel:
+		return zerolog.InfoLevel
+	case logger.DebugLevel:
+		return zerolog.DebugLevel
+	default:
+		return zerolog.TraceLevel
+	}
+}
+
+// New returns a logging func that logs to the zerolog logger
+func New(l zerolog.Logger) logger.LogFunc {
+	return func(payload logger.LogPayload) {
+		e := l.WithLevel(Level(payload.Level))
+		if e == nil {
+			return
+		}
+
+		if payload.Error != nil {
+			e = e.Err(payload.Error)
+		}
+
+		e.Fields(payload.Fields).Msg(payload.Message)
+	}
+}
@@ -0,0 +1,441 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/bhoriuchi/graphql-go-server/ws/manager"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// TransportHTTP is the transport label value for http operations,
+// websocket operations use the subprotocol
+const TransportHTTP = "http"
+
+// Status label values
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+)
+
+// OtherOperationName is the operation_name label value of operations
+// whose name is not recorded once MaxOperationNames is reached
+const OtherOperationName = "other"
+
+// DefaultMaxOperationNames is the operation name cap used when none is
+// configured
+const DefaultMaxOperationNames = 100
+
+// Options configures the metrics. Metrics are registered with the default
+// prometheus registry unless one is specified, servers registering with
+// the same registry and namespace share their metrics.
+//
+// Operation names are sent by clients so MaxOperationNames caps the
+// number of distinct operation_name label values, a negative value
+// records every operation as OtherOperationName
+type Options struct {
+	Namespace         string
+	Registry          *prometheus.Registry
+	Buckets           []float64
+	MaxOperationNames int
+}
+
+// Metrics records prometheus metrics for operations and websocket
+// connections. A nil metrics is valid and records nothing
+type Metrics struct {
+	handler            http.Handler
+	operations         *prometheus.CounterVec
+	operationDuration  *prometheus.HistogramVec
+	parseFailures      prometheus.Counter
+	validationFailures prometheus.Counter
+	messagesReceived   *prometheus.CounterVec
+	messagesSent       *prometheus.CounterVec
+	closeCodes         *prometheus.CounterVec
+	deliveries         *prometheus.CounterVec
+	connections        *connectionCollector
+
+	namesMx  sync.Mutex
+	names    map[string]struct{}
+	maxNames int
+}
+
+// New creates and registers the metrics
+func New(opts *Options) *Metrics {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+
+	var (
+		registerer prometheus.Registerer = prometheus.DefaultRegisterer
+		handler                          = promhttp.Handler()
+	)
+
+	if opts.Registry != nil {
+		registerer = opts.Registry
+		handler = promhttp.HandlerFor(opts.Registry, promhttp.HandlerOpts{})
+	}
+
+	maxNames := opts.MaxOperationNames
+	if maxNames == 0 {
+		maxNames = DefaultMaxOperationNames
+	}
+
+	ns := opts.Namespace
+	m := &Metrics{
+		handler:  handler,
+		names:    map[string]struct{}{},
+		maxNames: maxNames,
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_operations_total",
+			Help:      "Total number of graphql operations.",
+		}, []string{"transport", "operation_name", "operation_type", "status"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "graphql_operation_duration_seconds",
+			Help:      "Duration of graphql operations in seconds.",
+			Buckets:   buckets,
+		}, []string{"transport", "operation_name", "operation_type", "status"}),
+		parseFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_parse_failures_total",
+			Help:      "Total number of documents that failed to parse.",
+		}),
+		validationFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_validation_failures_total",
+			Help:      "Total number of documents that failed validation.",
+		}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_ws_messages_received_total",
+			Help:      "Total number of websocket messages received.",
+		}, []string{"subprotocol", "type"}),
+		messagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_ws_messages_sent_total",
+			Help:      "Total number of websocket messages sent.",
+		}, []string{"subprotocol", "type"}),
+		closeCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_ws_closed_connections_total",
+			Help:      "Total number of closed websocket connections by close code.",
+		}, []string{"subprotocol", "code"}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "graphql_ws_subscription_deliveries_total",
+			Help:      "Total number of subscription results by delivery policy outcome.",
+		}, []string{"subprotocol", "outcome"}),
+		connections: newConnectionCollector(ns),
+	}
+
+	m.operations = register(registerer, m.operations)
+	m.operationDuration = register(registerer, m.operationDuration)
+	m.parseFailures = register(registerer, m.parseFailures)
+	m.validationFailures = register(registerer, m.validationFailures)
+	m.messagesReceived = register(registerer, m.messagesReceived)
+	m.messagesSent = register(registerer, m.messagesSent)
+	m.closeCodes = register(registerer, m.closeCodes)
+	m.deliveries = register(registerer, m.deliveries)
+	m.connections = register(registerer, m.connections)
+
+	return m
+}
+
+// register registers the collector, a collector already registered with
+// the same descriptors is returned in its place
+func register[T prometheus.Collector](registerer prometheus.Registerer, c T) T {
+	err := registerer.Register(c)
+	if err == nil {
+		return c
+	}
+
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+
+	panic(err)
+}
+
+// operationName returns the operation_name label value of the name
+func (m *Metrics) operationName(name string) string {
+	if name == "" {
+		return name
+	}
+
+	m.namesMx.Lock()
+	defer m.namesMx.Unlock()
+
+	if _, ok := m.names[name]; ok {
+		return name
+	}
+	if len(m.names) < m.maxNames {
+		m.names[name] = struct{}{}
+		return name
+	}
+
+	return OtherOperationName
+}
+
+// Handler returns the /metrics http handler
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return m.handler
+}
+
+// Operation records the metrics of a single operation
+type Operation struct {
+	mx            sync.Mutex
+	m             *Metrics
+	start         time.Time
+	transport     string
+	operationName string
+	operationType string
+}
+
+type operationKey struct{}
+
+// StartOperation starts recording an operation and adds it to the context
+// so that the operation name and type can be set once it is parsed
+func (m *Metrics) StartOperation(ctx context.Context, transport string) (context.Context, *Operation) {
+	if m == nil {
+		return ctx, nil
+	}
+
+	o := &Operation{
+		m:         m,
+		start:     time.Now(),
+		transport: transport,
+	}
+
+	return context.WithValue(ctx, operationKey{}, o), o
+}
+
+// SetOperation sets the operation name and type labels
+func (o *Operation) SetOperation(op *ast.OperationDefinition) {
+	if o == nil || op == nil {
+		return
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	o.operationType = op.Operation
+	if op.GetName() != nil {
+		o.operationName = o.m.operationName(op.GetName().Value)
+	}
+}
+
+// End records the operation
+func (o *Operation) End(errorCount int) {
+	if o == nil {
+		return
+	}
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	status := StatusSuccess
+	if errorCount > 0 {
+		status = StatusError
+	}
+
+	labels := prometheus.Labels{
+		"transport":      o.transport,
+		"operation_name": o.operationName,
+		"operation_type": o.operationType,
+		"status":         status,
+	}
+
+	o.m.operations.With(labels).Inc()
+	o.m.operationDuration.With(labels).Observe(time.Since(o.start).Seconds())
+}
+
+// ParseDidStart counts parse failures and sets the operation labels
+func (m *Metrics) ParseDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(doc *ast.Document, err error)) {
+	return ctx, func(doc *ast.Document, err error) {
+		if err != nil {
+			m.parseFailures.Inc()
+			return
+		}
+
+		if o, ok := ctx.Value(operationKey{}).(*Operation); ok && doc != nil {
+			op, _ := utils.GetOperationAST(doc, p.OperationName)
+			o.SetOperation(op)
+		}
+	}
+}
+
+// ValidationDidStart counts validation failures
+func (m *Metrics) ValidationDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(errs gqlerrors.FormattedErrors)) {
+	return ctx, func(errs gqlerrors.FormattedErrors) {
+		if len(errs) > 0 {
+			m.validationFailures.Inc()
+		}
+	}
+}
+
+// ExecutionDidStart implements document.Observer
+func (m *Metrics) ExecutionDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(result *graphql.Result)) {
+	return ctx, func(result *graphql.Result) {}
+}
+
+// MessageReceived counts a received websocket message
+func (m *Metrics) MessageReceived(subprotocol string, t protocol.MessageType) {
+	if m == nil {
+		return
+	}
+	m.messagesReceived.WithLabelValues(subprotocol, string(t)).Inc()
+}
+
+// SubscriptionDelivery counts a subscription result handled by a
+// delivery policy
+func (m *Metrics) SubscriptionDelivery(subprotocol string, outcome protocol.DeliveryOutcome) {
+	if m == nil {
+		return
+	}
+	m.deliveries.WithLabelValues(subprotocol, string(outcome)).Inc()
+}
+
+// MessageSent counts a sent websocket message
+func (m *Metrics) MessageSent(subprotocol string, t protocol.MessageType) {
+	if m == nil {
+		return
+	}
+	m.messagesSent.WithLabelValues(subprotocol, string(t)).Inc()
+}
+
+// TrackConnection tracks an active websocket connection, its active
+// subscriptions and send queue depth until untrack is called with the
+// close code. Calling untrack more than once has no effect
+func (m *Metrics) TrackConnection(subprotocol string, mgr *manager.Manager, queue *protocol.SendQueue) (untrack func(code int)) {
+	if m == nil {
+		return func(code int) {}
+	}
+
+	id := m.connections.add(subprotocol, mgr, queue)
+
+	var once sync.Once
+	return func(code int) {
+		once.Do(func() {
+			m.connections.remove(id)
+			m.closeCodes.WithLabelValues(subprotocol, strconv.Itoa(code)).Inc()
+		})
+	}
+}
+
+// trackedConnection is an active websocket connection
+type trackedConnection struct {
+	subprotocol string
+	mgr         *manager.Manager
+	queue       *protocol.SendQueue
+}
+
+// connectionCollector collects gauges from the active websocket connections
+// at scrape time
+type connectionCollector struct {
+	mx            sync.Mutex
+	nextID        uint64
+	conns         map[uint64]trackedConnection
+	connections   *prometheus.Desc
+	subscriptions *prometheus.Desc
+	queueDepth    *prometheus.Desc
+}
+
+func newConnectionCollector(ns string) *connectionCollector {
+	return &connectionCollector{
+		conns: map[uint64]trackedConnection{},
+		connections: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "", "graphql_ws_active_connections"),
+			"Number of active websocket connections.",
+			[]string{"subprotocol"}, nil,
+		),
+		subscriptions: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "", "graphql_ws_active_subscriptions"),
+			"Number of active websocket operations.",
+			[]string{"subprotocol"}, nil,
+		),
+		queueDepth: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "", "graphql_ws_send_queue_depth"),
+			"Number of messages waiting in websocket send queues.",
+			[]string{"subprotocol"}, nil,
+		),
+	}
+}
+
+func (c *connectionCollector) add(subprotocol string, mgr *manager.Manager, queue *protocol.SendQueue) uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.nextID++
+	c.conns[c.nextID] = trackedConnection{
+		subprotocol: subprotocol,
+		mgr:         mgr,
+		queue:       queue,
+	}
+
+	return c.nextID
+}
+
+func (c *connectionCollector) remove(id uint64) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	delete(c.conns, id)
+}
+
+// Describe implements prometheus.Collector
+func (c *connectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.subscriptions
+	ch <- c.queueDepth
+}
+
+// Collect implements prometheus.Collector
+func (c *connectionCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mx.Lock()
+	conns := make([]trackedConnection, 0, len(c.conns))
+	for _, conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	c.mx.Unlock()
+
+	connections := map[string]int{}
+	subscriptions := map[string]int{}
+	queueDepth := map[string]int{}
+
+	for _, conn := range conns {
+		connections[conn.subprotocol]++
+		if conn.mgr != nil {
+			subscriptions[conn.subprotocol] += conn.mgr.SubscriptionCount()
+		}
+		if conn.queue != nil {
+			queueDepth[conn.subprotocol] += conn.queue.Len()
+		}
+	}
+
+	for subprotocol, n := range connections {
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(n), subprotocol)
+		ch <- prometheus.MustNewConstMetric(c.subscriptions, prometheus.GaugeValue, float64(subscriptions[subprotocol]), subprotocol)
+		ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(queueDepth[subprotocol]), subprotocol)
+	}
+}
@@ -0,0 +1,137 @@
+package metrics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/metrics"
+	"github.com/bhoriuchi/graphql-go-server/ws/manager"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// operation records an operation with the name
+func operation(m *metrics.Metrics, name string, errorCount int) {
+	_, op := m.StartOperation(context.Background(), metrics.TransportHTTP)
+	op.SetOperation(&ast.OperationDefinition{
+		Operation: ast.OperationTypeQuery,
+		Name:      &ast.Name{Value: name},
+	})
+	op.End(errorCount)
+}
+
+func TestSharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// servers sharing a registry share their collectors
+	m1 := metrics.New(&metrics.Options{Registry: reg})
+	m2 := metrics.New(&metrics.Options{Registry: reg})
+	operation(m1, "Hello", 0)
+	operation(m2, "Hello", 1)
+
+	expected := `
+# HELP graphql_operations_total Total number of graphql operations.
+# TYPE graphql_operations_total counter
+graphql_operations_total{operation_name="Hello",operation_type="query",status="error",transport="http"} 1
+graphql_operations_total{operation_name="Hello",operation_type="query",status="success",transport="http"} 1
+`
+	if err := promtest.GatherAndCompare(reg, strings.NewReader(expected), "graphql_operations_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the default registerer is used without a registry
+	metrics.New(nil)
+	metrics.New(nil)
+}
+
+func TestOperationNameCap(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(&metrics.Options{Registry: reg, MaxOperationNames: 1})
+
+	for _, name := range []string{"A", "B", "A", "C"} {
+		operation(m, name, 0)
+	}
+
+	expected := `
+# HELP graphql_operations_total Total number of graphql operations.
+# TYPE graphql_operations_total counter
+graphql_operations_total{operation_name="A",operation_type="query",status="success",transport="http"} 2
+graphql_operations_total{operation_name="other",operation_type="query",status="success",transport="http"} 2
+`
+	if err := promtest.GatherAndCompare(reg, strings.NewReader(expected), "graphql_operations_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(&metrics.Options{Registry: reg})
+
+	queue := protocol.NewSendQueue(10, protocol.SendQueueBlock)
+	queue.Push(protocol.OperationMessage{Type: protocol.MsgNext})
+	untrack := m.TrackConnection("test", manager.NewManager(), queue)
+
+	expected := `
+# HELP graphql_ws_active_connections Number of active websocket connections.
+# TYPE graphql_ws_active_connections gauge
+graphql_ws_active_connections{subprotocol="test"} 1
+# HELP graphql_ws_send_queue_depth Number of messages waiting in websocket send queues.
+# TYPE graphql_ws_send_queue_depth gauge
+graphql_ws_send_queue_depth{subprotocol="test"} 1
+`
+	if err := promtest.GatherAndCompare(reg, strings.NewReader(expected), "graphql_ws_active_connections", "graphql_ws_send_queue_depth"); err != nil {
+		t.Fatal(err)
+	}
+
+	untrack(1000)
+	untrack(1000)
+	if n, _ := promtest.GatherAndCount(reg, "graphql_ws_active_connections"); n != 0 {
+		t.Fatalf("expected no active connections, got %d", n)
+	}
+	if n, _ := promtest.GatherAndCount(reg, "graphql_ws_closed_connections_total"); n != 1 {
+		t.Fatalf("expected one close code, got %d", n)
+	}
+}
+
+func TestWSParseFailure(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(&metrics.Options{Registry: reg})
+	schema := testutil.Hello(t)
+
+	server, conn := transport.Pipe(graphqltransportws.Subprotocol)
+	if _, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+		Transport: server,
+		Schema:    &schema,
+		Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+		Metrics:   m,
+		Executor:  &document.Executor{Observers: []document.Observer{m}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := testutil.NewClient(t, conn)
+	client.Send(`{"type":"connection_init"}`)
+	client.Expect(protocol.MsgConnectionAck)
+	client.Send(`{"id":"1","type":"subscribe","payload":{"query":"{ hello"}}`)
+	client.Expect(protocol.MsgError)
+
+	expected := `
+# HELP graphql_parse_failures_total Total number of documents that failed to parse.
+# TYPE graphql_parse_failures_total counter
+graphql_parse_failures_total 1
+# HELP graphql_operations_total Total number of graphql operations.
+# TYPE graphql_operations_total counter
+graphql_operations_total{operation_name="",operation_type="",status="error",transport="graphql-transport-ws"} 1
+`
+	if err := promtest.GatherAndCompare(reg, strings.NewReader(expected), "graphql_parse_failures_total", "graphql_operations_total"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+type requestKey struct{}
+type hookKey struct{}
+
+// contextSchema resolves the context values and root value of an operation
+func contextSchema(t *testing.T) graphql.Schema {
+	value := func(key interface{}) *graphql.Field {
+		return &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Context.Value(key), nil
+			},
+		}
+	}
+
+	return testutil.NewSchema(t, &testutil.Schema{
+		Query: graphql.Fields{
+			"request": value(requestKey{}),
+			"hook":    value(hookKey{}),
+			"root": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					root, _ := p.Info.RootValue.(map[string]interface{})
+					return root["root"], nil
+				},
+			},
+		},
+	})
+}
+
+func withRequestValue() server.Option {
+	return server.WithContextFunc(func(r *http.Request) context.Context {
+		return context.WithValue(r.Context(), requestKey{}, "request")
+	})
+}
+
+func TestWSContextFunc(t *testing.T) {
+	// the hook returns a context that is not derived from the connection
+	contextValueFunc := func(c protocol.Context, msg protocol.OperationMessage, execArgs graphql.Params) (context.Context, gqlerrors.FormattedErrors) {
+		return context.WithValue(context.Background(), hookKey{}, "hook"), nil
+	}
+
+	tests := []struct {
+		name string
+		opts []server.Option
+		want map[string]interface{}
+	}{
+		{
+			name: "connection context",
+			want: map[string]interface{}{"request": "request", "hook": nil},
+		},
+		{
+			name: "context value func",
+			opts: []server.Option{
+				server.WithGraphQLWS(&server.GraphQLWS{ContextValueFunc: contextValueFunc}),
+				server.WithGraphQLTransportWS(&server.GraphQLTransportWS{ContextValueFunc: contextValueFunc}),
+			},
+			want: map[string]interface{}{"request": "request", "hook": "hook"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := wsServer(t, contextSchema(t), append([]server.Option{withRequestValue()}, tt.opts...)...)
+
+			for _, p := range wsProtocols {
+				t.Run(p.subprotocol, func(t *testing.T) {
+					ws := p.connect(t, srv, nil)
+					p.send(t, ws, "1", "{ request hook }")
+					data := p.data(t, ws, "1")
+					for key, want := range tt.want {
+						if data[key] != want {
+							t.Errorf("expected %s %v, got %v", key, want, data[key])
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestOperationFunc(t *testing.T) {
+	var (
+		mx    sync.Mutex
+		calls = map[string]int{}
+	)
+	operationFunc := func(ctx context.Context, info protocol.OperationInfo) (context.Context, map[string]interface{}, error) {
+		transport := "http"
+		if info.Conn != nil {
+			transport = info.Conn.Subprotocol()
+		}
+		mx.Lock()
+		calls[transport]++
+		mx.Unlock()
+
+		if ctx.Value(requestKey{}) != "request" {
+			t.Errorf("expected the request context on %s", transport)
+		}
+		return context.WithValue(ctx, hookKey{}, transport), map[string]interface{}{"root": transport}, nil
+	}
+
+	srv := wsServer(t, contextSchema(t), withRequestValue(), server.WithOperationFunc(operationFunc))
+
+	t.Run("http", func(t *testing.T) {
+		code, result := post(t, srv.Config.Handler, "{ request hook root }")
+		data, _ := result["data"].(map[string]interface{})
+		if code != http.StatusOK || data["request"] != "request" || data["hook"] != "http" || data["root"] != "http" {
+			t.Fatalf("expected the operation hook values, got %d %v", code, result)
+		}
+	})
+
+	for _, p := range wsProtocols {
+		t.Run(p.subprotocol, func(t *testing.T) {
+			ws := p.connect(t, srv, nil)
+			p.send(t, ws, "1", "{ request hook root }")
+			data := p.data(t, ws, "1")
+			if data["request"] != "request" || data["hook"] != p.subprotocol || data["root"] != p.subprotocol {
+				t.Fatalf("expected the operation hook values, got %v", data)
+			}
+		})
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	for _, transport := range []string{"http", wsProtocols[0].subprotocol, wsProtocols[1].subprotocol} {
+		if calls[transport] != 1 {
+			t.Errorf("expected one %s operation hook call, got %d", transport, calls[transport])
+		}
+	}
+}
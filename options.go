@@ -5,8 +5,18 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
 	"github.com/bhoriuchi/graphql-go-server/ide"
+	"github.com/bhoriuchi/graphql-go-server/live"
 	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/metrics"
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+	"github.com/bhoriuchi/graphql-go-server/timing"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
+	"github.com/bhoriuchi/graphql-go-server/trusted"
+	"github.com/bhoriuchi/graphql-go-server/ws/fanout"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
@@ -28,13 +38,45 @@ type Options struct {
 	LogFunc            logger.LogFunc
 	RootValueFunc      RootValueFunc
 	ContextFunc        ContextFunc
+	OperationFunc      protocol.OperationFunc
+	Hooks              protocol.Hooks
 	FormatErrorFunc    FormatErrorFunc
 	ResultCallbackFunc ResultCallbackFunc
+	RateLimit          *RateLimit
+	Codec              codec.Codec
+	DocumentCacheSize  int
+	Tracing            *tracing.Options
+	Metrics            *metrics.Options
+	FieldTiming        *timing.Options
+	AccessLog          *accesslog.Options
+	TrustedDocuments   *trusted.Manifest
+	ErrorFormatting    *gqlerror.Options
+	PanicHandler       recovery.Handler
+	Timeouts           *Timeouts
+
+	// DisableIntrospection rejects __schema and __type queries unless
+	// IntrospectionAllowFunc returns true for the request context and
+	// DisableSuggestions removes "Did you mean" suggestions from errors
+	DisableIntrospection   bool
+	IntrospectionAllowFunc func(ctx context.Context) bool
+	DisableSuggestions     bool
 
 	// WebSocket configs
+	SendQueue          *SendQueue
+	Compression        *Compression
+	Sessions           *Sessions
+	Fanout             *fanout.Options
+	LiveQueries        *live.Options
+	DeliveryPolicyFunc protocol.DeliveryPolicyFunc
 	GraphQLWS          *GraphQLWS
 	GraphQLTransportWS *GraphQLTransportWS
 
+	// Protocols are additional websocket subprotocols and DefaultProtocol
+	// is the name of the protocol served to clients that do not send a
+	// Sec-WebSocket-Protocol header
+	Protocols       []Protocol
+	DefaultProtocol string
+
 	// IDE configs
 	Playground *ide.PlaygroundOptions
 	GraphiQL   *ide.GraphiQLOptions
@@ -43,17 +85,21 @@ type Options struct {
 type GraphQLWS struct {
 	ConnectionInitWaitTimeout time.Duration
 	KeepAlive                 time.Duration
-	RootValueFunc             func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
 	ContextValueFunc          func(c protocol.Context, msg protocol.OperationMessage, execArgs graphql.Params) (context.Context, gqlerrors.FormattedErrors)
 	OnConnect                 func(c protocol.Context, payload interface{}) (interface{}, error)
 	OnDisconnect              func(c protocol.Context)
 	OnOperation               func(c protocol.Context, msg graphqlws.StartMessage, params *graphql.Params) (*graphql.Params, error)
 	OnOperationComplete       func(c protocol.Context, id string)
+	OnClose                   func(c protocol.Context, code graphqlws.CloseCode, reason string)
+
+	// Deprecated: RootValueFunc only applies to this protocol, use
+	// WithOperationFunc to set the root value of http and websocket
+	// operations
+	RootValueFunc func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
 }
 
 type GraphQLTransportWS struct {
 	ConnectionInitWaitTimeout time.Duration
-	RootValueFunc             func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
 	ContextValueFunc          func(c protocol.Context, msg protocol.OperationMessage, execArgs graphql.Params) (context.Context, gqlerrors.FormattedErrors)
 	OnConnect                 func(c protocol.Context) (interface{}, error)
 	OnPing                    func(c protocol.Context, payload map[string]interface{})
@@ -65,6 +111,84 @@ type GraphQLTransportWS struct {
 	OnError                   func(c protocol.Context, msg graphqltransportws.ErrorMessage, errs gqlerrors.FormattedErrors) (gqlerrors.FormattedErrors, error)
 	OnComplete                func(c protocol.Context, msg graphqltransportws.CompleteMessage) error
 	OnOperation               func(c protocol.Context, msg graphqltransportws.SubscribeMessage, args graphql.Params, result interface{}) (interface{}, error)
+
+	// Deprecated: RootValueFunc only applies to this protocol, use
+	// WithOperationFunc to set the root value of http and websocket
+	// operations
+	RootValueFunc func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
+}
+
+// RateLimit configures operation and connection limits. A zero value
+// for any limit disables it
+type RateLimit struct {
+	// MaxSubscriptionsPerConnection limits the number of concurrent
+	// operations a single websocket connection can have active
+	MaxSubscriptionsPerConnection int
+
+	// OperationsPerSecond and OperationBurst configure a token bucket
+	// that limits the rate operations can be started on a single
+	// websocket connection
+	OperationsPerSecond float64
+	OperationBurst      int
+
+	// MaxConnectionsPerIP limits the number of concurrent websocket
+	// connections from a single remote ip. RemoteIPFunc can be used to
+	// identify the remote ip when running behind a proxy, by default the
+	// host portion of the request RemoteAddr is used
+	MaxConnectionsPerIP int
+	RemoteIPFunc        func(r *http.Request) string
+
+	// HTTPRequestsPerSecond and HTTPBurst configure a token bucket per
+	// key returned by HTTPKeyFunc that limits the rate of http operations.
+	// Requests with an empty key are not limited
+	HTTPKeyFunc           func(r *http.Request) string
+	HTTPRequestsPerSecond float64
+	HTTPBurst             int
+}
+
+// SendQueue configures the bounded outbound message queue of each
+// websocket connection and the policy applied when it is full. Messages
+// still queued when the connection closes are discarded
+type SendQueue struct {
+	Size   int
+	Policy protocol.SendQueuePolicy
+}
+
+// Compression configures permessage-deflate compression for websocket
+// connections. Messages smaller than Threshold bytes are sent uncompressed
+// and Level is a compress/flate level, zero uses the default level
+type Compression struct {
+	EnableCompression bool
+	Level             int
+	Threshold         int
+}
+
+// Sessions enables resumable websocket sessions. The connection_ack
+// payload carries a session token, when the transport disconnects the
+// subscriptions are kept for the GracePeriod and a client presenting the
+// token in its connection_init payload resumes the session. Events sent
+// while the session is detached are held in the send queue so SendQueue
+// sets the buffer limit and the policy applied when it fills.
+//
+// The token is a bearer secret, anyone holding it can take over the
+// session and its subscriptions. ResumeFunc binds sessions to the
+// identity of the client, it is called after OnConnect and a client it
+// rejects is acknowledged with a new session
+type Sessions struct {
+	GracePeriod time.Duration
+	ResumeFunc  protocol.ResumeFunc
+}
+
+// Timeouts configures operation deadlines, a zero value disables the
+// timeout. Queries and mutations that exceed their timeout return a
+// TIMEOUT error, subscriptions that receive no events within the idle
+// timeout are ended with a TIMEOUT error and subscriptions that exceed
+// their lifetime are completed
+type Timeouts struct {
+	Query                time.Duration
+	Mutation             time.Duration
+	SubscriptionIdle     time.Duration
+	SubscriptionLifetime time.Duration
 }
 
 // NewOptions creates a new default options with optional options funcs
@@ -92,6 +216,21 @@ func WithGraphQLTransportWS(o *GraphQLTransportWS) Option {
 	}
 }
 
+// WithProtocol registers a websocket subprotocol
+func WithProtocol(p Protocol) Option {
+	return func(opts *Options) {
+		opts.Protocols = append(opts.Protocols, p)
+	}
+}
+
+// WithDefaultProtocol sets the subprotocol served to clients that do not
+// request one
+func WithDefaultProtocol(name string) Option {
+	return func(opts *Options) {
+		opts.DefaultProtocol = name
+	}
+}
+
 func WithPretty() Option {
 	return func(opts *Options) {
 		opts.Pretty = true
@@ -122,12 +261,182 @@ func WithContextFunc(f ContextFunc) Option {
 	}
 }
 
+// WithOperationFunc sets a hook that returns the context and root value
+// of every http and websocket operation
+func WithOperationFunc(f protocol.OperationFunc) Option {
+	return func(opts *Options) {
+		opts.OperationFunc = f
+	}
+}
+
+// WithHooks sets the lifecycle hooks shared by the websocket protocols
+func WithHooks(h protocol.Hooks) Option {
+	return func(opts *Options) {
+		opts.Hooks = h
+	}
+}
+
+// WithDeliveryPolicyFunc sets a hook that returns the delivery policy of
+// each websocket subscription, see protocol.DeliveryDirective
+func WithDeliveryPolicyFunc(f protocol.DeliveryPolicyFunc) Option {
+	return func(opts *Options) {
+		opts.DeliveryPolicyFunc = f
+	}
+}
+
 func WithResultCallbackFunc(f ResultCallbackFunc) Option {
 	return func(opts *Options) {
 		opts.ResultCallbackFunc = f
 	}
 }
 
+func WithRateLimit(o *RateLimit) Option {
+	return func(opts *Options) {
+		opts.RateLimit = o
+	}
+}
+
+func WithSendQueue(o *SendQueue) Option {
+	return func(opts *Options) {
+		opts.SendQueue = o
+	}
+}
+
+func WithCompression(o *Compression) Option {
+	return func(opts *Options) {
+		opts.Compression = o
+	}
+}
+
+func WithCodec(c codec.Codec) Option {
+	return func(opts *Options) {
+		opts.Codec = c
+	}
+}
+
+// WithDocumentCacheSize caches up to size parsed and validated documents
+func WithDocumentCacheSize(size int) Option {
+	return func(opts *Options) {
+		opts.DocumentCacheSize = size
+	}
+}
+
+// WithTracing enables OpenTelemetry tracing of operations
+func WithTracing(o *tracing.Options) Option {
+	return func(opts *Options) {
+		if o == nil {
+			o = &tracing.Options{}
+		}
+		opts.Tracing = o
+	}
+}
+
+// WithMetrics enables prometheus metrics, use Server.MetricsHandler to
+// expose them
+func WithMetrics(o *metrics.Options) Option {
+	return func(opts *Options) {
+		if o == nil {
+			o = &metrics.Options{}
+		}
+		opts.Metrics = o
+	}
+}
+
+// WithFieldTiming adds per field resolver timings in the apollo tracing
+// format to the result extensions of operations that opt in
+func WithFieldTiming(o *timing.Options) Option {
+	return func(opts *Options) {
+		if o == nil {
+			o = &timing.Options{}
+		}
+		opts.FieldTiming = o
+	}
+}
+
+// WithAccessLog logs one entry per completed http and websocket operation
+func WithAccessLog(o *accesslog.Options) Option {
+	return func(opts *Options) {
+		if o == nil {
+			o = &accesslog.Options{}
+		}
+		opts.AccessLog = o
+	}
+}
+
+// WithTrustedDocuments only allows operations from the manifest to be
+// executed, see trusted.LoadManifest
+func WithTrustedDocuments(m *trusted.Manifest) Option {
+	return func(opts *Options) {
+		opts.TrustedDocuments = m
+	}
+}
+
+// WithDisableIntrospection rejects introspection queries, allow can be
+// used to permit introspection for some requests such as admins
+func WithDisableIntrospection(allow func(ctx context.Context) bool) Option {
+	return func(opts *Options) {
+		opts.DisableIntrospection = true
+		opts.IntrospectionAllowFunc = allow
+	}
+}
+
+// WithDisableSuggestions removes "Did you mean" suggestions that leak
+// schema details from error messages
+func WithDisableSuggestions() Option {
+	return func(opts *Options) {
+		opts.DisableSuggestions = true
+	}
+}
+
+// WithErrorFormatting configures the classification and masking of
+// errors sent to clients over http and websockets
+func WithErrorFormatting(o *gqlerror.Options) Option {
+	return func(opts *Options) {
+		if o == nil {
+			o = &gqlerror.Options{}
+		}
+		opts.ErrorFormatting = o
+	}
+}
+
+// WithPanicHandler sets a handler that receives panics recovered from
+// operations and websocket connections along with the stack
+func WithPanicHandler(h recovery.Handler) Option {
+	return func(opts *Options) {
+		opts.PanicHandler = h
+	}
+}
+
+// WithSessions enables resumable websocket sessions
+func WithSessions(o *Sessions) Option {
+	return func(opts *Options) {
+		opts.Sessions = o
+	}
+}
+
+// WithFanout shares the execution of identical subscriptions between
+// their subscribers within the scope returned by the options ScopeFunc
+func WithFanout(o *fanout.Options) Option {
+	return func(opts *Options) {
+		opts.Fanout = o
+	}
+}
+
+// WithLiveQueries enables queries marked with the @live directive over
+// graphql-transport-ws, see Server.Invalidate
+func WithLiveQueries(o *live.Options) Option {
+	return func(opts *Options) {
+		opts.LiveQueries = o
+	}
+}
+
+// WithTimeouts sets the operation timeouts
+func WithTimeouts(o *Timeouts) Option {
+	return func(opts *Options) {
+		opts.Timeouts = o
+	}
+}
+
 func WithPlaygroundOptions(o *ide.PlaygroundOptions) Option {
 	return func(opts *Options) {
 		opts.Playground = o
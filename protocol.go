@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/gorilla/websocket"
+)
+
+// Priorities of the built-in protocols, the newer protocol is preferred
+const (
+	GraphQLWSPriority          = 10
+	GraphQLTransportWSPriority = 20
+)
+
+// Protocol serves a graphql websocket subprotocol
+type Protocol interface {
+	// Name is the Sec-WebSocket-Protocol value of the protocol
+	Name() string
+
+	// Priority orders the protocols during negotiation, the highest
+	// priority protocol requested by the client is selected
+	Priority() int
+
+	// Serve serves the upgraded connection. The context is the parent of
+	// every operation context and conn.Release must be called once the
+	// connection has closed
+	Serve(ctx context.Context, conn *WSConn)
+}
+
+// WSConn is an upgraded websocket connection. Transport is the
+// connection transport and WS is the gorilla connection it wraps when
+// the connection was upgraded by the server
+type WSConn struct {
+	Transport transport.Transport
+	WS        *websocket.Conn
+	Request   *http.Request
+
+	releaseOnce sync.Once
+	release     func()
+}
+
+// Release releases the resources held for the connection such as its
+// connection limit slot. Calling Release more than once has no effect
+func (c *WSConn) Release() {
+	c.releaseOnce.Do(func() {
+		if c.release != nil {
+			c.release()
+		}
+	})
+}
+
+// protocolRegistry holds the registered protocols by name
+type protocolRegistry struct {
+	mx          sync.RWMutex
+	protocols   map[string]Protocol
+	defaultName string
+}
+
+func newProtocolRegistry() *protocolRegistry {
+	return &protocolRegistry{
+		protocols: map[string]Protocol{},
+	}
+}
+
+// register adds or replaces a protocol
+func (r *protocolRegistry) register(p Protocol) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.protocols[p.Name()] = p
+}
+
+// setDefault sets the protocol used for clients that do not request one
+func (r *protocolRegistry) setDefault(name string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.defaultName = name
+}
+
+// get returns the named protocol or the default protocol when the name
+// is empty
+func (r *protocolRegistry) get(name string) (Protocol, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	if name == "" {
+		name = r.defaultName
+	}
+
+	p, ok := r.protocols[name]
+	return p, ok
+}
+
+// list returns the protocols ordered by priority
+func (r *protocolRegistry) list() []Protocol {
+	r.mx.RLock()
+	protocols := make([]Protocol, 0, len(r.protocols))
+	for _, p := range r.protocols {
+		protocols = append(protocols, p)
+	}
+	r.mx.RUnlock()
+
+	sort.SliceStable(protocols, func(i, j int) bool {
+		if protocols[i].Priority() == protocols[j].Priority() {
+			return protocols[i].Name() < protocols[j].Name()
+		}
+		return protocols[i].Priority() > protocols[j].Priority()
+	})
+
+	return protocols
+}
+
+// names returns the protocol names ordered by priority
+func (r *protocolRegistry) names() []string {
+	names := []string{}
+	for _, p := range r.list() {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// RegisterProtocol registers a websocket subprotocol, a protocol with
+// the same name as a registered protocol replaces it
+func (s *Server) RegisterProtocol(p Protocol) {
+	s.protocols.register(p)
+}
+
+// Protocols returns the registered websocket subprotocols ordered by
+// priority
+func (s *Server) Protocols() []Protocol {
+	return s.protocols.list()
+}
+
+// connectionSettings are the per connection settings shared by the
+// built-in protocols
+type connectionSettings struct {
+	maxSubscriptions     int
+	operationsPerSecond  float64
+	operationBurst       int
+	sendQueueSize        int
+	sendQueuePolicy      protocol.SendQueuePolicy
+	compressionLevel     int
+	compressionThreshold int
+	subscriptionIdle     time.Duration
+	subscriptionLifetime time.Duration
+}
+
+// connectionSettings returns the per connection settings
+func (s *Server) connectionSettings() connectionSettings {
+	cs := connectionSettings{}
+
+	if t := s.options.Timeouts; t != nil {
+		cs.subscriptionIdle = t.SubscriptionIdle
+		cs.subscriptionLifetime = t.SubscriptionLifetime
+	}
+
+	if cmp := s.options.Compression; cmp != nil && cmp.EnableCompression {
+		cs.compressionLevel = cmp.Level
+		cs.compressionThreshold = cmp.Threshold
+	}
+
+	if sq := s.options.SendQueue; sq != nil {
+		cs.sendQueueSize = sq.Size
+		cs.sendQueuePolicy = sq.Policy
+	}
+
+	if rl := s.options.RateLimit; rl != nil {
+		cs.maxSubscriptions = rl.MaxSubscriptionsPerConnection
+		cs.operationsPerSecond = rl.OperationsPerSecond
+		cs.operationBurst = rl.OperationBurst
+	}
+
+	return cs
+}
+
+// graphqlWSProtocol serves the graphql-ws protocol
+type graphqlWSProtocol struct {
+	s    *Server
+	opts *GraphQLWS
+}
+
+func (p *graphqlWSProtocol) Name() string {
+	return graphqlws.Subprotocol
+}
+
+func (p *graphqlWSProtocol) Priority() int {
+	return GraphQLWSPriority
+}
+
+func (p *graphqlWSProtocol) Serve(ctx context.Context, conn *WSConn) {
+	s := p.s
+	cs := s.connectionSettings()
+
+	graphqlws.NewConnection(ctx, graphqlws.Config{
+		Transport:               conn.Transport,
+		Schema:                  &s.schema,
+		Logger:                  s.log,
+		Request:                 conn.Request,
+		KeepAlive:               p.opts.KeepAlive,
+		RootValueFunc:           s.wsRootValueFunc(p.opts.RootValueFunc),
+		ContextValueFunc:        p.opts.ContextValueFunc,
+		OperationFunc:           s.options.OperationFunc,
+		DeliveryPolicyFunc:      s.options.DeliveryPolicyFunc,
+		Hooks:                   s.options.Hooks,
+		OnConnect:               p.opts.OnConnect,
+		OnDisconnect:            p.opts.OnDisconnect,
+		OnOperation:             p.opts.OnOperation,
+		OnOperationComplete:     p.opts.OnOperationComplete,
+		MaxSubscriptions:        cs.maxSubscriptions,
+		OperationsPerSecond:     cs.operationsPerSecond,
+		OperationBurst:          cs.operationBurst,
+		SendQueueSize:           cs.sendQueueSize,
+		SendQueuePolicy:         cs.sendQueuePolicy,
+		CompressionLevel:        cs.compressionLevel,
+		CompressionThreshold:    cs.compressionThreshold,
+		Codec:                   s.codec,
+		Executor:                s.executor,
+		Tracer:                  s.tracer,
+		Metrics:                 s.metrics,
+		FieldTiming:             s.timing,
+		AccessLog:               s.accessLog,
+		TrustedDocuments:        s.options.TrustedDocuments,
+		ErrorFormatter:          s.errors,
+		PanicHandler:            s.options.PanicHandler,
+		SubscriptionIdleTimeout: cs.subscriptionIdle,
+		SubscriptionLifetime:    cs.subscriptionLifetime,
+		Sessions:                s.sessions,
+		ResumeFunc:              s.resumeFunc,
+		Fanout:                  s.fanout,
+		Release:                 conn.Release,
+		OnClose:                 p.opts.OnClose,
+	})
+}
+
+// graphqlTransportWSProtocol serves the graphql-transport-ws protocol
+type graphqlTransportWSProtocol struct {
+	s    *Server
+	opts *GraphQLTransportWS
+}
+
+func (p *graphqlTransportWSProtocol) Name() string {
+	return graphqltransportws.Subprotocol
+}
+
+func (p *graphqlTransportWSProtocol) Priority() int {
+	return GraphQLTransportWSPriority
+}
+
+func (p *graphqlTransportWSProtocol) Serve(ctx context.Context, conn *WSConn) {
+	graphqltransportws.NewConnection(ctx, p.config(conn))
+}
+
+// config returns the connection configuration
+func (p *graphqlTransportWSProtocol) config(conn *WSConn) graphqltransportws.Config {
+	s := p.s
+	cs := s.connectionSettings()
+
+	return graphqltransportws.Config{
+		Transport:                 conn.Transport,
+		Schema:                    &s.schema,
+		Logger:                    s.log,
+		Request:                   conn.Request,
+		ConnectionInitWaitTimeout: p.opts.ConnectionInitWaitTimeout,
+		RootValueFunc:             s.wsRootValueFunc(p.opts.RootValueFunc),
+		ContextValueFunc:          p.opts.ContextValueFunc,
+		OperationFunc:             s.options.OperationFunc,
+		DeliveryPolicyFunc:        s.options.DeliveryPolicyFunc,
+		Hooks:                     s.options.Hooks,
+		OnConnect:                 p.opts.OnConnect,
+		OnPing:                    p.opts.OnPing,
+		OnPong:                    p.opts.OnPong,
+		OnDisconnect:              p.opts.OnDisconnect,
+		OnSubscribe:               p.opts.OnSubscribe,
+		OnNext:                    p.opts.OnNext,
+		OnError:                   p.opts.OnError,
+		OnComplete:                p.opts.OnComplete,
+		OnOperation:               p.opts.OnOperation,
+		MaxSubscriptions:          cs.maxSubscriptions,
+		OperationsPerSecond:       cs.operationsPerSecond,
+		OperationBurst:            cs.operationBurst,
+		SendQueueSize:             cs.sendQueueSize,
+		SendQueuePolicy:           cs.sendQueuePolicy,
+		CompressionLevel:          cs.compressionLevel,
+		CompressionThreshold:      cs.compressionThreshold,
+		Codec:                     s.codec,
+		Executor:                  s.executor,
+		Tracer:                    s.tracer,
+		Metrics:                   s.metrics,
+		FieldTiming:               s.timing,
+		AccessLog:                 s.accessLog,
+		TrustedDocuments:          s.options.TrustedDocuments,
+		ErrorFormatter:            s.errors,
+		PanicHandler:              s.options.PanicHandler,
+		SubscriptionIdleTimeout:   cs.subscriptionIdle,
+		SubscriptionLifetime:      cs.subscriptionLifetime,
+		Sessions:                  s.sessions,
+		ResumeFunc:                s.resumeFunc,
+		Fanout:                    s.fanout,
+		Live:                      s.live,
+		Release:                   conn.Release,
+		OnClose:                   p.opts.OnClose,
+	}
+}
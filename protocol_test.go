@@ -0,0 +1,132 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/gorilla/websocket"
+)
+
+// echoProtocol is a custom protocol that echoes messages prefixed with
+// its name
+type echoProtocol struct {
+	name     string
+	priority int
+}
+
+func (p *echoProtocol) Name() string {
+	return p.name
+}
+
+func (p *echoProtocol) Priority() int {
+	return p.priority
+}
+
+func (p *echoProtocol) Serve(ctx context.Context, conn *server.WSConn) {
+	defer conn.Release()
+
+	for {
+		b, err := conn.Transport.ReadMessage(ctx)
+		if err != nil {
+			return
+		}
+		if err := conn.Transport.WriteMessage(ctx, append([]byte(p.name+":"), b...)); err != nil {
+			return
+		}
+	}
+}
+
+// echo sends a message and returns the echoed reply
+func echo(t *testing.T, ws *websocket.Conn, msg string) string {
+	t.Helper()
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCustomProtocol(t *testing.T) {
+	srv := httptest.NewServer(server.New(
+		testutil.Hello(t),
+		server.WithProtocol(&echoProtocol{name: "echo", priority: 5}),
+	))
+	defer srv.Close()
+
+	ws, _ := dial(t, srv, nil, "echo")
+	if ws.Subprotocol() != "echo" {
+		t.Fatalf("expected the echo subprotocol, got %q", ws.Subprotocol())
+	}
+	if reply := echo(t, ws, "hi"); reply != "echo:hi" {
+		t.Fatalf("expected echo:hi, got %q", reply)
+	}
+}
+
+func TestProtocolPriority(t *testing.T) {
+	s := server.New(
+		testutil.Hello(t),
+		server.WithGraphQLWS(&server.GraphQLWS{}),
+		server.WithGraphQLTransportWS(&server.GraphQLTransportWS{}),
+		server.WithProtocol(&echoProtocol{name: "low", priority: 15}),
+		server.WithProtocol(&echoProtocol{name: "high", priority: 30}),
+	)
+
+	names := []string{}
+	for _, p := range s.Protocols() {
+		names = append(names, p.Name())
+	}
+	expected := []string{"high", graphqltransportws.Subprotocol, "low", graphqlws.Subprotocol}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// the highest priority protocol requested by the client is selected
+	tests := []struct {
+		requested []string
+		want      string
+	}{
+		{requested: []string{graphqlws.Subprotocol, "high"}, want: "high"},
+		{requested: []string{"low", graphqltransportws.Subprotocol}, want: graphqltransportws.Subprotocol},
+		{requested: []string{graphqlws.Subprotocol, "low"}, want: "low"},
+	}
+	for _, tt := range tests {
+		ws, _ := dial(t, srv, nil, tt.requested...)
+		if ws.Subprotocol() != tt.want {
+			t.Errorf("requested %v, expected %q, got %q", tt.requested, tt.want, ws.Subprotocol())
+		}
+	}
+}
+
+func TestReplaceProtocol(t *testing.T) {
+	custom := &echoProtocol{name: graphqlws.Subprotocol, priority: server.GraphQLWSPriority}
+	s := server.New(
+		testutil.Hello(t),
+		server.WithGraphQLWS(&server.GraphQLWS{}),
+		server.WithProtocol(custom),
+	)
+
+	if protocols := s.Protocols(); len(protocols) != 1 || protocols[0] != custom {
+		t.Fatalf("expected the custom protocol to replace the built-in one, got %v", protocols)
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ws, _ := dial(t, srv, nil, graphqlws.Subprotocol)
+	if reply := echo(t, ws, "hi"); reply != graphqlws.Subprotocol+":hi" {
+		t.Fatalf("expected the custom protocol to serve the connection, got %q", reply)
+	}
+}
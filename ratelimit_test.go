@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+)
+
+// rateLimited returns true if the message is a rate limit error
+func rateLimited(msg protocol.OperationMessage) bool {
+	return msg.Type == protocol.MsgError && errorCode(msg.Payload) == gqlerror.CodeRateLimited
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	srv := server.New(testutil.Hello(t), server.WithRateLimit(&server.RateLimit{
+		HTTPKeyFunc:           func(r *http.Request) string { return r.Header.Get("X-Client") },
+		HTTPRequestsPerSecond: 0.001,
+		HTTPBurst:             1,
+	}))
+
+	query := func(client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hello }"}`))
+		r.Header.Set("Content-Type", server.ContentTypeJSON)
+		r.Header.Set("X-Client", client)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := query("a"); w.Code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d %s", w.Code, w.Body.String())
+	}
+	if w := query("a"); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), gqlerror.CodeRateLimited) {
+		t.Fatalf("expected the second request to be limited, got %d %s", w.Code, w.Body.String())
+	}
+
+	// keys are limited separately and an empty key is not limited
+	if w := query("b"); w.Code != http.StatusOK {
+		t.Fatalf("expected another key to pass, got %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := query(""); w.Code != http.StatusOK {
+			t.Fatalf("expected an empty key to pass, got %d", w.Code)
+		}
+	}
+}
+
+func TestWSConnectionLimit(t *testing.T) {
+	for _, p := range wsProtocols {
+		t.Run(p.subprotocol, func(t *testing.T) {
+			srv := wsServer(t, testutil.Hello(t), server.WithRateLimit(&server.RateLimit{
+				MaxConnectionsPerIP: 1,
+			}))
+			ws := p.connect(t, srv, nil)
+
+			// the upgrade is refused while the ip holds a connection
+			dialer := &websocket.Dialer{Subprotocols: []string{p.subprotocol}}
+			url := "ws" + strings.TrimPrefix(srv.URL, "http")
+			if _, resp, err := dialer.Dial(url, nil); err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("expected the connection to be refused with 429, got %v", err)
+			}
+
+			// closing the connection releases its slot
+			ws.Close()
+			deadline := time.Now().Add(testutil.ReadTimeout)
+			for {
+				next, _, err := dialer.Dial(url, nil)
+				if err == nil {
+					next.Close()
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected the connection slot to be released, got %v", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestWSSubscriptionLimit(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"count": testutil.Events(make(chan interface{}))},
+	})
+	srv := wsServer(t, schema, server.WithRateLimit(&server.RateLimit{
+		MaxSubscriptionsPerConnection: 1,
+	}))
+
+	for _, p := range wsProtocols {
+		t.Run(p.subprotocol, func(t *testing.T) {
+			ws := p.connect(t, srv, nil)
+			p.send(t, ws, "1", "subscription { count }")
+			p.send(t, ws, "2", "subscription { count }")
+
+			if msg := readMessage(t, ws); msg.ID != "2" || !rateLimited(msg) {
+				t.Fatalf("expected the second subscription to be limited, got %+v", msg)
+			}
+		})
+	}
+}
+
+func TestWSOperationRateLimit(t *testing.T) {
+	srv := wsServer(t, testutil.Hello(t), server.WithRateLimit(&server.RateLimit{
+		OperationsPerSecond: 0.001,
+		OperationBurst:      2,
+	}))
+
+	for _, p := range wsProtocols {
+		t.Run(p.subprotocol, func(t *testing.T) {
+			ws := p.connect(t, srv, nil)
+			for _, id := range []string{"1", "2", "3"} {
+				p.send(t, ws, id, "{ hello }")
+			}
+
+			// the burst allows two operations and rejects the third
+			results, limited := 0, []string{}
+			for results+len(limited) < 3 {
+				msg := readMessage(t, ws)
+				switch {
+				case msg.Type == p.result:
+					results++
+				case rateLimited(msg):
+					limited = append(limited, msg.ID)
+				}
+			}
+			if results != 2 || len(limited) != 1 || limited[0] != "3" {
+				t.Fatalf("expected operation 3 to be limited, got %d results and %v limited", results, limited)
+			}
+		})
+	}
+}
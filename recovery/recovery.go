@@ -0,0 +1,49 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+)
+
+// Handler receives a recovered panic value and the stack of the
+// goroutine that panicked
+type Handler func(ctx context.Context, value interface{}, stack []byte)
+
+// ErrInternal is the error sent to clients in place of a panic
+var ErrInternal = gqlerror.New(gqlerror.CodeInternalServerError, gqlerror.DefaultMaskedMessage)
+
+// Error is a recovered panic
+type Error struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Recover recovers a panic, passes it to the handler and then calls
+// onPanic. It must be called directly with defer
+func Recover(ctx context.Context, handler Handler, onPanic func(err *Error)) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	err := &Error{
+		Value: value,
+		Stack: debug.Stack(),
+	}
+
+	if handler != nil {
+		handler(ctx, err.Value, err.Stack)
+	}
+
+	if onPanic != nil {
+		onPanic(err)
+	}
+}
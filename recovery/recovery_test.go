@@ -0,0 +1,35 @@
+package recovery_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+)
+
+func TestRecover(t *testing.T) {
+	var (
+		handled   interface{}
+		recovered *recovery.Error
+	)
+
+	func() {
+		defer recovery.Recover(context.Background(), func(ctx context.Context, value interface{}, stack []byte) {
+			handled = value
+			if len(stack) == 0 {
+				t.Error("expected a stack")
+			}
+		}, func(err *recovery.Error) {
+			recovered = err
+		})
+
+		panic("boom")
+	}()
+
+	if handled != "boom" {
+		t.Fatalf("expected handler to receive the panic, got %v", handled)
+	}
+	if recovered == nil || recovered.Error() != "panic: boom" {
+		t.Fatalf("unexpected recovered error %v", recovered)
+	}
+}
@@ -1,13 +1,24 @@
 package server
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
 	"github.com/bhoriuchi/graphql-go-server/ide"
+	"github.com/bhoriuchi/graphql-go-server/live"
 	"github.com/bhoriuchi/graphql-go-server/logger"
-	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
-	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/bhoriuchi/graphql-go-server/metrics"
+	"github.com/bhoriuchi/graphql-go-server/timing"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
+	"github.com/bhoriuchi/graphql-go-server/utils/ratelimit"
+	"github.com/bhoriuchi/graphql-go-server/ws/fanout"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
 )
@@ -20,10 +31,24 @@ const (
 )
 
 type Server struct {
-	schema   graphql.Schema
-	log      *logger.LogWrapper
-	options  *Options
-	upgrader websocket.Upgrader
+	schema      graphql.Schema
+	log         *logger.LogWrapper
+	codec       codec.Codec
+	executor    *document.Executor
+	tracer      *tracing.Tracer
+	metrics     *metrics.Metrics
+	timing      *timing.Timing
+	accessLog   *accesslog.Logger
+	errors      *gqlerror.Formatter
+	options     *Options
+	upgrader    websocket.Upgrader
+	protocols   *protocolRegistry
+	sessions    *protocol.Sessions
+	resumeFunc  protocol.ResumeFunc
+	fanout      *fanout.Hub
+	live        *live.Registry
+	connLimiter *ratelimit.ConnectionLimiter
+	httpLimiter *ratelimit.KeyedLimiter
 }
 
 // New creates a new server
@@ -40,51 +65,166 @@ func New(schema graphql.Schema, opts ...Option) *Server {
 	s := &Server{
 		schema:  schema,
 		log:     logger.NewLogWrapper(options.LogFunc, nil),
+		codec:   codec.OrDefault(options.Codec),
 		options: options,
 	}
 
-	// define the supported subprotocols, the protocols are ordered by
-	// priority and the negotiation process will pick the first match
-	subprotocols := []string{}
+	// the legacy FormatErrorFunc is used unless error formatting sets one
+	errorOptions := gqlerror.Options{}
+	if options.ErrorFormatting != nil {
+		errorOptions = *options.ErrorFormatting
+	}
+	if errorOptions.FormatErrorFunc == nil && options.FormatErrorFunc != nil {
+		errorOptions.FormatErrorFunc = options.FormatErrorFunc
+	}
+	s.errors = gqlerror.NewFormatter(&errorOptions, s.log)
 
-	// prefer newer protocol
-	if options.GraphQLTransportWS != nil {
-		subprotocols = append(subprotocols, graphqltransportws.Subprotocol)
+	s.executor = &document.Executor{}
+	if options.DocumentCacheSize > 0 {
+		s.executor.Cache = document.NewCache(options.DocumentCacheSize)
 	}
 
-	// fallback to older protocol
-	if options.GraphQLWS != nil {
-		subprotocols = append(subprotocols, graphqlws.Subprotocol)
+	if options.DisableIntrospection {
+		s.executor.Checks = append(s.executor.Checks, document.NoIntrospection(options.IntrospectionAllowFunc))
+	}
+	s.executor.StripSuggestions = options.DisableSuggestions
+
+	if options.Timeouts != nil {
+		s.executor.QueryTimeout = options.Timeouts.Query
+		s.executor.MutationTimeout = options.Timeouts.Mutation
+	}
+
+	if options.Tracing != nil {
+		s.tracer = tracing.NewTracer(options.Tracing)
+		s.executor.Observers = append(s.executor.Observers, s.tracer)
+	}
+
+	if options.Metrics != nil {
+		s.metrics = metrics.New(options.Metrics)
+		s.executor.Observers = append(s.executor.Observers, s.metrics)
+	}
+
+	if options.FieldTiming != nil {
+		s.timing = timing.New(options.FieldTiming)
+		document.AddExtensions(&s.schema, s.timing.Extension())
+		s.executor.Observers = append(s.executor.Observers, s.timing.Observer())
+	}
+
+	if options.AccessLog != nil {
+		accessLogOptions := *options.AccessLog
+		if accessLogOptions.LogFunc == nil {
+			accessLogOptions.LogFunc = options.LogFunc
+		}
+		s.accessLog = accesslog.New(&accessLogOptions)
+		s.executor.Observers = append(s.executor.Observers, s.accessLog)
 	}
 
-	if len(subprotocols) > 0 {
-		s.upgrader = websocket.Upgrader{
-			// TODO: make cors configurable
-			CheckOrigin:  func(r *http.Request) bool { return true },
-			Subprotocols: subprotocols,
+	if rl := options.RateLimit; rl != nil {
+		if rl.MaxConnectionsPerIP > 0 {
+			s.connLimiter = ratelimit.NewConnectionLimiter(rl.MaxConnectionsPerIP)
 		}
+		if rl.HTTPKeyFunc != nil && rl.HTTPRequestsPerSecond > 0 {
+			s.httpLimiter = ratelimit.NewKeyedLimiter(rl.HTTPRequestsPerSecond, rl.HTTPBurst)
+		}
+	}
+
+	if options.Fanout != nil {
+		s.fanout = fanout.New(s.executor, options.Fanout)
+	}
+
+	if options.LiveQueries != nil {
+		s.live = live.New(s.executor, options.LiveQueries)
+	}
+
+	if options.Sessions != nil {
+		s.sessions = protocol.NewSessions(options.Sessions.GracePeriod)
+		s.resumeFunc = options.Sessions.ResumeFunc
+	}
+
+	// register the built-in subprotocols followed by the custom ones
+	s.protocols = newProtocolRegistry()
+	if options.GraphQLTransportWS != nil {
+		s.RegisterProtocol(&graphqlTransportWSProtocol{s: s, opts: options.GraphQLTransportWS})
+	}
+	if options.GraphQLWS != nil {
+		s.RegisterProtocol(&graphqlWSProtocol{s: s, opts: options.GraphQLWS})
+	}
+	for _, p := range options.Protocols {
+		s.RegisterProtocol(p)
+	}
+	s.protocols.setDefault(options.DefaultProtocol)
+
+	s.upgrader = websocket.Upgrader{
+		// TODO: make cors configurable
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+
+	if options.Compression != nil {
+		s.upgrader.EnableCompression = options.Compression.EnableCompression
 	}
 
 	return s
 }
 
+// DocumentCacheStats returns the document cache metrics
+func (s *Server) DocumentCacheStats() document.Stats {
+	return s.executor.Cache.Stats()
+}
+
+// FanoutStats returns the shared subscription metrics
+func (s *Server) FanoutStats() fanout.Stats {
+	return s.fanout.Stats()
+}
+
+// Invalidate re-executes the live queries that touched the resources
+func (s *Server) Invalidate(keys ...string) {
+	s.live.Invalidate(keys...)
+}
+
+// MetricsHandler returns the prometheus /metrics handler
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.Handler()
+}
+
 // isWSUpgrade identifies a websocket upgrade
 func (s *Server) isWSUpgrade(r *http.Request) bool {
 	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
 }
 
+// remoteIP identifies the remote ip of the request
+func (s *Server) remoteIP(r *http.Request) string {
+	if s.options.RateLimit != nil && s.options.RateLimit.RemoteIPFunc != nil {
+		return s.options.RateLimit.RemoteIPFunc(r)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 // ServeHTTP provides an entrypoint into executing graphQL queries.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	ctx := s.requestContext(r)
 
 	if s.isWSUpgrade(r) {
 		s.log.Debugf("upgrading connection to websocket")
-		s.WSHandler(w, r)
+		s.ContextWSHandler(ctx, w, r)
 		return
 	}
 
+	s.ContextHandler(ctx, w, r)
+}
+
+// requestContext returns the context of an http or websocket upgrade
+// request using the ContextFunc when set
+func (s *Server) requestContext(r *http.Request) context.Context {
 	if s.options.ContextFunc != nil {
-		ctx = s.options.ContextFunc(r)
+		if ctx := s.options.ContextFunc(r); ctx != nil {
+			return ctx
+		}
 	}
-	s.ContextHandler(ctx, w, r)
+	return r.Context()
 }
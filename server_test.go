@@ -0,0 +1,245 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+)
+
+// dial connects a websocket client to the test server
+func dial(t *testing.T, srv *httptest.Server, dialer *websocket.Dialer, subprotocols ...string) (*websocket.Conn, *http.Response) {
+	t.Helper()
+
+	if dialer == nil {
+		dialer = &websocket.Dialer{}
+	}
+	dialer.Subprotocols = subprotocols
+
+	ws, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return ws, resp
+}
+
+// readMessage reads the next operation message
+func readMessage(t *testing.T, ws *websocket.Conn) protocol.OperationMessage {
+	t.Helper()
+
+	msg := protocol.OperationMessage{}
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// wsProtocol describes the operation messages of a websocket subprotocol
+type wsProtocol struct {
+	subprotocol string
+	start       protocol.MessageType
+	stop        protocol.MessageType
+	result      protocol.MessageType
+}
+
+var wsProtocols = []wsProtocol{
+	{
+		subprotocol: graphqltransportws.Subprotocol,
+		start:       protocol.MsgSubscribe,
+		stop:        protocol.MsgComplete,
+		result:      protocol.MsgNext,
+	},
+	{
+		subprotocol: graphqlws.Subprotocol,
+		start:       protocol.MsgStart,
+		stop:        protocol.MsgStop,
+		result:      protocol.MsgData,
+	},
+}
+
+// wsServer starts a test server serving both websocket protocols, the
+// options can replace their configuration
+func wsServer(t *testing.T, schema graphql.Schema, opts ...server.Option) *httptest.Server {
+	opts = append([]server.Option{
+		server.WithGraphQLWS(&server.GraphQLWS{}),
+		server.WithGraphQLTransportWS(&server.GraphQLTransportWS{}),
+	}, opts...)
+
+	srv := httptest.NewServer(server.New(schema, opts...))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// connect dials the server and initializes the connection with the
+// connection_init payload
+func (p wsProtocol) connect(t *testing.T, srv *httptest.Server, payload interface{}) *websocket.Conn {
+	t.Helper()
+
+	ws, _ := dial(t, srv, nil, p.subprotocol)
+	if err := ws.WriteJSON(protocol.OperationMessage{Type: protocol.MsgConnectionInit, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readMessage(t, ws); msg.Type != protocol.MsgConnectionAck {
+		t.Fatalf("expected connection_ack, got %+v", msg)
+	}
+	return ws
+}
+
+// data reads messages until the result of the operation and returns its
+// data
+func (p wsProtocol) data(t *testing.T, ws *websocket.Conn, id string) map[string]interface{} {
+	t.Helper()
+
+	for {
+		msg := readMessage(t, ws)
+		if msg.ID != id {
+			continue
+		}
+		if msg.Type != p.result {
+			t.Fatalf("expected a result, got %+v", msg)
+		}
+		return testutil.Data(msg)
+	}
+}
+
+// send starts an operation
+func (p wsProtocol) send(t *testing.T, ws *websocket.Conn, id, query string) {
+	t.Helper()
+
+	if err := ws.WriteJSON(protocol.OperationMessage{
+		ID:      id,
+		Type:    p.start,
+		Payload: map[string]interface{}{"query": query},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errorCode returns the code of the first error of a result or error
+// message payload, the error payload is a list on graphql-transport-ws
+// and a single error on graphql-ws
+func errorCode(payload interface{}) string {
+	if result, ok := payload.(map[string]interface{}); ok && result["errors"] != nil {
+		payload = result["errors"]
+	}
+	if errs, ok := payload.([]interface{}); ok && len(errs) > 0 {
+		payload = errs[0]
+	}
+
+	err, _ := payload.(map[string]interface{})
+	ext, _ := err["extensions"].(map[string]interface{})
+	code, _ := ext["code"].(string)
+	return code
+}
+
+// post sends a query over http and decodes the response
+func post(t *testing.T, srv http.Handler, query string) (int, map[string]interface{}) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"query": query})
+	r := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	r.Header.Set("Content-Type", server.ContentTypeJSON)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("invalid response %q: %s", w.Body.String(), err)
+	}
+	return w.Code, result
+}
+
+func TestHTTPQuery(t *testing.T) {
+	code, result := post(t, server.New(testutil.Hello(t)), "{ hello }")
+	if data, _ := result["data"].(map[string]interface{}); code != http.StatusOK || data["hello"] != "world" {
+		t.Fatalf("expected hello world, got %d %v", code, result)
+	}
+}
+
+func TestWSProtocolNegotiation(t *testing.T) {
+	srv := httptest.NewServer(server.New(
+		testutil.Hello(t),
+		server.WithGraphQLWS(&server.GraphQLWS{}),
+		server.WithGraphQLTransportWS(&server.GraphQLTransportWS{}),
+		server.WithDefaultProtocol(graphqlws.Subprotocol),
+	))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		requested []string
+		want      string
+	}{
+		{
+			name:      "preferred",
+			requested: []string{graphqlws.Subprotocol, graphqltransportws.Subprotocol},
+			want:      graphqltransportws.Subprotocol,
+		},
+		{
+			name:      "requested",
+			requested: []string{graphqlws.Subprotocol},
+			want:      graphqlws.Subprotocol,
+		},
+		{
+			name: "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, _ := dial(t, srv, nil, tt.requested...)
+			if ws.Subprotocol() != tt.want {
+				t.Fatalf("expected subprotocol %q, got %q", tt.want, ws.Subprotocol())
+			}
+
+			// both protocols acknowledge the same connection_init
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_init"}`))
+			if msg := readMessage(t, ws); msg.Type != protocol.MsgConnectionAck {
+				t.Fatalf("expected connection_ack, got %+v", msg)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		ws, _ := dial(t, srv, nil, "unsupported")
+		if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseProtocolError) {
+			t.Fatalf("expected a protocol error close, got %v", err)
+		}
+	})
+}
+
+func TestWSQuery(t *testing.T) {
+	srv := httptest.NewServer(server.New(
+		testutil.Hello(t),
+		server.WithGraphQLTransportWS(&server.GraphQLTransportWS{}),
+	))
+	defer srv.Close()
+
+	ws, _ := dial(t, srv, nil, graphqltransportws.Subprotocol)
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"connection_init"}`))
+	if msg := readMessage(t, ws); msg.Type != protocol.MsgConnectionAck {
+		t.Fatalf("expected connection_ack, got %+v", msg)
+	}
+
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`))
+	if msg := readMessage(t, ws); msg.Type != protocol.MsgNext || testutil.Data(msg)["hello"] != "world" {
+		t.Fatalf("expected hello world, got %+v", msg)
+	}
+	if msg := readMessage(t, ws); msg.Type != protocol.MsgComplete || msg.ID != "1" {
+		t.Fatalf("expected complete, got %+v", msg)
+	}
+}
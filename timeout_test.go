@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/graphql-go/graphql"
+)
+
+// timeoutSchema has blocking query and mutation fields and a
+// subscription of the events
+func timeoutSchema(t *testing.T, events chan interface{}) graphql.Schema {
+	return testutil.NewSchema(t, &testutil.Schema{
+		Query:        graphql.Fields{"slow": testutil.Blocking()},
+		Mutation:     graphql.Fields{"slow": testutil.Blocking()},
+		Subscription: graphql.Fields{"count": testutil.Events(events)},
+	})
+}
+
+func TestHTTPTimeouts(t *testing.T) {
+	srv := server.New(timeoutSchema(t, nil), server.WithTimeouts(&server.Timeouts{
+		Query:    10 * time.Millisecond,
+		Mutation: 10 * time.Millisecond,
+	}))
+
+	for _, query := range []string{"{ slow }", "mutation { slow }"} {
+		code, result := post(t, srv, query)
+		if code != http.StatusOK || errorCode(result) != gqlerror.CodeTimeout {
+			t.Fatalf("expected %q to time out, got %d %v", query, code, result)
+		}
+	}
+}
+
+func TestWSTimeouts(t *testing.T) {
+	for _, p := range wsProtocols {
+		t.Run(p.subprotocol, func(t *testing.T) {
+			t.Run("query and mutation", func(t *testing.T) {
+				srv := wsServer(t, timeoutSchema(t, nil), server.WithTimeouts(&server.Timeouts{
+					Query:    10 * time.Millisecond,
+					Mutation: 10 * time.Millisecond,
+				}))
+				ws := p.connect(t, srv, nil)
+
+				for id, query := range map[string]string{"1": "{ slow }", "2": "mutation { slow }"} {
+					p.send(t, ws, id, query)
+					msg := readMessage(t, ws)
+					if msg.ID != id || msg.Type != p.result || errorCode(msg.Payload) != gqlerror.CodeTimeout {
+						t.Fatalf("expected %q to time out, got %+v", query, msg)
+					}
+					if msg := readMessage(t, ws); msg.ID != id || msg.Type != protocol.MsgComplete {
+						t.Fatalf("expected complete, got %+v", msg)
+					}
+				}
+			})
+
+			t.Run("subscription idle", func(t *testing.T) {
+				events := make(chan interface{})
+				srv := wsServer(t, timeoutSchema(t, events), server.WithTimeouts(&server.Timeouts{
+					SubscriptionIdle: 50 * time.Millisecond,
+				}))
+				ws := p.connect(t, srv, nil)
+				p.send(t, ws, "1", "subscription { count }")
+
+				// events reset the idle timeout
+				for i := 1; i <= 3; i++ {
+					events <- i
+					if data := p.data(t, ws, "1"); data["count"] != float64(i) {
+						t.Fatalf("expected count %d, got %v", i, data)
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+
+				msg := readMessage(t, ws)
+				if msg.ID != "1" || msg.Type != protocol.MsgError || errorCode(msg.Payload) != gqlerror.CodeTimeout {
+					t.Fatalf("expected an idle timeout error, got %+v", msg)
+				}
+			})
+
+			t.Run("subscription lifetime", func(t *testing.T) {
+				events := make(chan interface{})
+				srv := wsServer(t, timeoutSchema(t, events), server.WithTimeouts(&server.Timeouts{
+					SubscriptionLifetime: 50 * time.Millisecond,
+				}))
+				ws := p.connect(t, srv, nil)
+				p.send(t, ws, "1", "subscription { count }")
+
+				events <- 1
+				if data := p.data(t, ws, "1"); data["count"] != float64(1) {
+					t.Fatalf("expected count 1, got %v", data)
+				}
+
+				// the subscription is completed without an error
+				if msg := readMessage(t, ws); msg.ID != "1" || msg.Type != protocol.MsgComplete {
+					t.Fatalf("expected the subscription to complete, got %+v", msg)
+				}
+			})
+		})
+	}
+}
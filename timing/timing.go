@@ -0,0 +1,298 @@
+package timing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+	// ExtensionName is the result extensions key of the trace
+	ExtensionName = "tracing"
+
+	// DefaultHeader is the header clients use to opt in to tracing
+	DefaultHeader = "X-Apollo-Tracing"
+)
+
+// Options configures field timing. Operations are only traced when the
+// client sends a non-empty opt in header unless Always is set. Browsers
+// cannot set headers on the websocket upgrade so websocket clients opt in
+// with the header name as a key of the connection params or the operation
+// extensions
+type Options struct {
+	Header string
+	Always bool
+}
+
+// Phase is the timing of a parse or validation phase
+type Phase struct {
+	StartOffset int64 `json:"startOffset"`
+	Duration    int64 `json:"duration"`
+}
+
+// Resolver is the timing of a single field resolver
+type Resolver struct {
+	Path        []interface{} `json:"path"`
+	ParentType  string        `json:"parentType"`
+	FieldName   string        `json:"fieldName"`
+	ReturnType  string        `json:"returnType"`
+	StartOffset int64         `json:"startOffset"`
+	Duration    int64         `json:"duration"`
+}
+
+// Execution holds the resolver timings
+type Execution struct {
+	Resolvers []Resolver `json:"resolvers"`
+}
+
+// Trace is an operation trace in the apollo tracing format, offsets and
+// durations are in nanoseconds
+type Trace struct {
+	Version    int       `json:"version"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	Duration   int64     `json:"duration"`
+	Parsing    *Phase    `json:"parsing,omitempty"`
+	Validation *Phase    `json:"validation,omitempty"`
+	Execution  Execution `json:"execution"`
+}
+
+// Recorder records the timings of a single operation
+type Recorder struct {
+	mx    sync.Mutex
+	start time.Time
+	trace Trace
+}
+
+type recorderKey struct{}
+
+// fromContext returns the recorder of an operation that opted in
+func fromContext(ctx context.Context) *Recorder {
+	if ctx == nil {
+		return nil
+	}
+
+	r, _ := ctx.Value(recorderKey{}).(*Recorder)
+	return r
+}
+
+// offset returns the nanoseconds since the start of the operation
+func (r *Recorder) offset(t time.Time) int64 {
+	return t.Sub(r.start).Nanoseconds()
+}
+
+// phase records a parse or validation phase
+func (r *Recorder) phase(start time.Time) *Phase {
+	return &Phase{
+		StartOffset: r.offset(start),
+		Duration:    time.Since(start).Nanoseconds(),
+	}
+}
+
+// Trace ends the recording and returns the trace
+func (r *Recorder) Trace() Trace {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	end := time.Now()
+	t := r.trace
+	t.EndTime = end
+	t.Duration = r.offset(end)
+	t.Execution.Resolvers = append([]Resolver{}, r.trace.Execution.Resolvers...)
+
+	return t
+}
+
+// Timing records per field resolver timings for operations that opt in
+// and adds them to the result extensions. A nil timing is valid and
+// records nothing
+type Timing struct {
+	header string
+	always bool
+}
+
+// New creates a new field timing
+func New(opts *Options) *Timing {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	header := opts.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	return &Timing{
+		header: header,
+		always: opts.Always,
+	}
+}
+
+// OptIn adds a recorder to the context if the request opted in with the
+// header or one of the payloads holds the header name with a value other
+// than false, null or an empty string. For websocket operations the
+// upgrade request, connection params and operation extensions are used
+func (t *Timing) OptIn(ctx context.Context, r *http.Request, payloads ...map[string]interface{}) context.Context {
+	if t == nil || !t.optedIn(r, payloads) {
+		return ctx
+	}
+
+	now := time.Now()
+	return context.WithValue(ctx, recorderKey{}, &Recorder{
+		start: now,
+		trace: Trace{
+			Version:   1,
+			StartTime: now,
+			Execution: Execution{Resolvers: []Resolver{}},
+		},
+	})
+}
+
+// optedIn returns true if the operation is traced
+func (t *Timing) optedIn(r *http.Request, payloads []map[string]interface{}) bool {
+	if t.always || (r != nil && r.Header.Get(t.header) != "") {
+		return true
+	}
+
+	for _, payload := range payloads {
+		switch v := payload[t.header].(type) {
+		case nil:
+		case bool:
+			if v {
+				return true
+			}
+		case string:
+			if v != "" {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Extension returns the schema extension that records resolver timings
+func (t *Timing) Extension() graphql.Extension {
+	return &extension{}
+}
+
+// Observer returns the executor observer that records the parse and
+// validation phases and adds the trace to the result
+func (t *Timing) Observer() document.Observer {
+	return &observer{}
+}
+
+// extension implements graphql.Extension
+type extension struct{}
+
+func (e *extension) Init(ctx context.Context, p *graphql.Params) context.Context {
+	return ctx
+}
+
+func (e *extension) Name() string {
+	return ExtensionName
+}
+
+func (e *extension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	return ctx, func(err error) {}
+}
+
+func (e *extension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	return ctx, func(errs []gqlerrors.FormattedError) {}
+}
+
+func (e *extension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	return ctx, func(result *graphql.Result) {}
+}
+
+func (e *extension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	r := fromContext(ctx)
+	if r == nil {
+		return ctx, func(v interface{}, err error) {}
+	}
+
+	start := time.Now()
+	return ctx, func(v interface{}, err error) {
+		resolver := Resolver{
+			FieldName:   info.FieldName,
+			StartOffset: r.offset(start),
+			Duration:    time.Since(start).Nanoseconds(),
+		}
+
+		if info.Path != nil {
+			resolver.Path = info.Path.AsArray()
+		}
+		if info.ParentType != nil {
+			resolver.ParentType = info.ParentType.Name()
+		}
+		if info.ReturnType != nil {
+			resolver.ReturnType = info.ReturnType.String()
+		}
+
+		r.mx.Lock()
+		r.trace.Execution.Resolvers = append(r.trace.Execution.Resolvers, resolver)
+		r.mx.Unlock()
+	}
+}
+
+// HasResult returns false since the result is added by the observer
+// only for operations that opted in
+func (e *extension) HasResult() bool {
+	return false
+}
+
+func (e *extension) GetResult(ctx context.Context) interface{} {
+	return nil
+}
+
+// observer implements document.Observer
+type observer struct{}
+
+func (o *observer) ParseDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(doc *ast.Document, err error)) {
+	r := fromContext(ctx)
+	start := time.Now()
+
+	return ctx, func(doc *ast.Document, err error) {
+		if r != nil {
+			r.mx.Lock()
+			r.trace.Parsing = r.phase(start)
+			r.mx.Unlock()
+		}
+	}
+}
+
+func (o *observer) ValidationDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(errs gqlerrors.FormattedErrors)) {
+	r := fromContext(ctx)
+	start := time.Now()
+
+	return ctx, func(errs gqlerrors.FormattedErrors) {
+		if r != nil {
+			r.mx.Lock()
+			r.trace.Validation = r.phase(start)
+			r.mx.Unlock()
+		}
+	}
+}
+
+func (o *observer) ExecutionDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(result *graphql.Result)) {
+	r := fromContext(ctx)
+
+	return ctx, func(result *graphql.Result) {
+		if r == nil || result == nil {
+			return
+		}
+
+		if result.Extensions == nil {
+			result.Extensions = map[string]interface{}{}
+		}
+		result.Extensions[ExtensionName] = r.Trace()
+	}
+}
@@ -0,0 +1,61 @@
+package timing_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/timing"
+)
+
+func TestFieldTiming(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	srv := server.New(schema, server.WithFieldTiming(nil))
+
+	do := func(optIn bool) map[string]interface{} {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ hello }"}`))
+		r.Header.Set("Content-Type", "application/json")
+		if optIn {
+			r.Header.Set(timing.DefaultHeader, "1")
+		}
+
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		var res struct {
+			Extensions map[string]interface{} `json:"extensions"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Extensions
+	}
+
+	if ext := do(false); ext[timing.ExtensionName] != nil {
+		t.Fatalf("expected no trace without opt in, got %v", ext)
+	}
+
+	trace, ok := do(true)[timing.ExtensionName].(map[string]interface{})
+	if !ok {
+		t.Fatal("expected trace in extensions")
+	}
+
+	resolvers := trace["execution"].(map[string]interface{})["resolvers"].([]interface{})
+	if len(resolvers) != 1 {
+		t.Fatalf("expected 1 resolver timing, got %d", len(resolvers))
+	}
+
+	resolver := resolvers[0].(map[string]interface{})
+	if resolver["fieldName"] != "hello" || resolver["parentType"] != "Query" || resolver["returnType"] != "String" {
+		t.Fatalf("unexpected resolver timing %v", resolver)
+	}
+
+	if trace["parsing"] == nil || trace["validation"] == nil {
+		t.Fatalf("expected parsing and validation timings, got %v", trace)
+	}
+}
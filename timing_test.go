@@ -0,0 +1,55 @@
+package server_test
+
+import (
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/timing"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+)
+
+func TestWSFieldTiming(t *testing.T) {
+	srv := wsServer(t, testutil.Hello(t), server.WithFieldTiming(nil))
+	optIn := map[string]interface{}{timing.DefaultHeader: "1"}
+
+	tests := []struct {
+		name       string
+		params     map[string]interface{}
+		extensions map[string]interface{}
+		traced     bool
+	}{
+		{name: "no opt in"},
+		{name: "connection params", params: optIn, traced: true},
+		{name: "operation extensions", extensions: optIn, traced: true},
+		{name: "opt out", extensions: map[string]interface{}{timing.DefaultHeader: false}},
+	}
+
+	for _, p := range wsProtocols {
+		for _, tt := range tests {
+			t.Run(p.subprotocol+"/"+tt.name, func(t *testing.T) {
+				ws := p.connect(t, srv, tt.params)
+				if err := ws.WriteJSON(protocol.OperationMessage{
+					ID:   "1",
+					Type: p.start,
+					Payload: map[string]interface{}{
+						"query":      "{ hello }",
+						"extensions": tt.extensions,
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				msg := readMessage(t, ws)
+				if msg.Type != p.result {
+					t.Fatalf("expected a result, got %+v", msg)
+				}
+				payload, _ := msg.Payload.(map[string]interface{})
+				ext, _ := payload["extensions"].(map[string]interface{})
+				if traced := ext[timing.ExtensionName] != nil; traced != tt.traced {
+					t.Fatalf("expected traced %v, got %v", tt.traced, payload)
+				}
+			})
+		}
+	}
+}
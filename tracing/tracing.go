@@ -0,0 +1,207 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer
+const InstrumentationName = "github.com/bhoriuchi/graphql-go-server"
+
+// Attribute keys
+const (
+	OperationNameKey = attribute.Key("graphql.operation.name")
+	OperationTypeKey = attribute.Key("graphql.operation.type")
+	OperationIDKey   = attribute.Key("graphql.operation.id")
+	ErrorCountKey    = attribute.Key("graphql.errors.count")
+	ConnectionIDKey  = attribute.Key("graphql.ws.connection.id")
+	SubprotocolKey   = attribute.Key("graphql.ws.subprotocol")
+)
+
+// Options configures the tracer. The global tracer provider is used if
+// none is specified and trace context and baggage are propagated by default
+type Options struct {
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+}
+
+// Tracer creates spans for graphql operations. A nil tracer is valid and
+// creates no spans
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer creates a new tracer
+func NewTracer(opts *Options) *Tracer {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	provider := opts.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	propagator := opts.Propagator
+	if propagator == nil {
+		propagator = propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		)
+	}
+
+	return &Tracer{
+		tracer:     provider.Tracer(InstrumentationName),
+		propagator: propagator,
+	}
+}
+
+// ExtractHTTP extracts the trace context from http headers
+func (t *Tracer) ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	return t.propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractExtensions extracts the trace context from operation extensions
+func (t *Tracer) ExtractExtensions(ctx context.Context, extensions map[string]interface{}) context.Context {
+	if t == nil || extensions == nil {
+		return ctx
+	}
+
+	carrier := propagation.MapCarrier{}
+	for _, field := range t.propagator.Fields() {
+		if v, ok := extensions[field].(string); ok {
+			carrier[field] = v
+		}
+	}
+
+	if len(carrier) == 0 {
+		return ctx
+	}
+
+	return t.propagator.Extract(ctx, carrier)
+}
+
+// ExtractPayload extracts the trace context from a connection_init payload
+// using the payload extensions, falling back to top level payload fields
+func (t *Tracer) ExtractPayload(ctx context.Context, payload map[string]interface{}) context.Context {
+	ctx = t.ExtractExtensions(ctx, payload)
+	if extensions, ok := payload["extensions"].(map[string]interface{}); ok {
+		ctx = t.ExtractExtensions(ctx, extensions)
+	}
+	return ctx
+}
+
+// WithParent returns ctx with the span and baggage from parent so that
+// operation spans can be parented by a propagated trace while keeping the
+// values of the execution context
+func (t *Tracer) WithParent(ctx, parent context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+
+	ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(parent))
+	return baggage.ContextWithBaggage(ctx, baggage.FromContext(parent))
+}
+
+// StartOperation starts the span for an operation
+func (t *Tracer) StartOperation(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noopSpan
+	}
+
+	return t.tracer.Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// OperationAttributes returns the attributes describing an operation
+func OperationAttributes(op *ast.OperationDefinition) []attribute.KeyValue {
+	if op == nil {
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{OperationTypeKey.String(op.Operation)}
+	if op.GetName() != nil {
+		attrs = append(attrs, OperationNameKey.String(op.GetName().Value))
+	}
+
+	return attrs
+}
+
+// EndOperation records the error count and ends the span
+func EndOperation(span trace.Span, errorCount int) {
+	span.SetAttributes(ErrorCountKey.Int(errorCount))
+	if errorCount > 0 {
+		span.SetStatus(codes.Error, "operation returned errors")
+	}
+	span.End()
+}
+
+// ParseDidStart starts the parse span and sets the operation attributes on
+// the parent span once the document is parsed
+func (t *Tracer) ParseDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(doc *ast.Document, err error)) {
+	parent := trace.SpanFromContext(ctx)
+	ctx, span := t.tracer.Start(ctx, "graphql.parse")
+
+	return ctx, func(doc *ast.Document, err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else if doc != nil {
+			if op, _ := utils.GetOperationAST(doc, p.OperationName); op != nil {
+				parent.SetAttributes(OperationAttributes(op)...)
+			}
+		}
+		span.End()
+	}
+}
+
+// ValidationDidStart starts the validation span
+func (t *Tracer) ValidationDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(errs gqlerrors.FormattedErrors)) {
+	ctx, span := t.tracer.Start(ctx, "graphql.validate")
+
+	return ctx, func(errs gqlerrors.FormattedErrors) {
+		span.SetAttributes(ErrorCountKey.Int(len(errs)))
+		if len(errs) > 0 {
+			span.SetStatus(codes.Error, errs[0].Message)
+		}
+		span.End()
+	}
+}
+
+// ExecutionDidStart starts the execution span
+func (t *Tracer) ExecutionDidStart(ctx context.Context, p *graphql.Params) (context.Context, func(result *graphql.Result)) {
+	ctx, span := t.tracer.Start(ctx, "graphql.execute")
+
+	return ctx, func(result *graphql.Result) {
+		if result != nil {
+			span.SetAttributes(ErrorCountKey.Int(len(result.Errors)))
+			if len(result.Errors) > 0 {
+				span.SetStatus(codes.Error, result.Errors[0].Message)
+			}
+		}
+		span.End()
+	}
+}
+
+// noopSpan is returned when tracing is disabled
+var noopSpan = trace.SpanFromContext(context.Background())
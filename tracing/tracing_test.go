@@ -0,0 +1,97 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
+	"github.com/graphql-go/graphql"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHTTPTracing(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	srv := server.New(schema, server.WithTracing(&tracing.Options{
+		TracerProvider: provider,
+	}))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"query Hello { hello }"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	srv.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	for _, name := range []string{"graphql.http", "graphql.parse", "graphql.validate", "graphql.execute"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("expected span %q to be exported", name)
+		}
+	}
+
+	op := spans["graphql.http"]
+	if op.SpanContext.TraceID().String() != traceID {
+		t.Fatalf("expected trace id %s, got %s", traceID, op.SpanContext.TraceID())
+	}
+
+	attrs := map[string]string{}
+	for _, attr := range op.Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+
+	if attrs["graphql.operation.name"] != "Hello" || attrs["graphql.operation.type"] != "query" {
+		t.Fatalf("unexpected operation attributes %v", attrs)
+	}
+}
+
+func TestSubscriptionExecutionSpan(t *testing.T) {
+	events := make(chan interface{})
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"ticks": testutil.Events(events)},
+	})
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := tracing.NewTracer(&tracing.Options{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+	})
+	e := &document.Executor{Observers: []document.Observer{tracer}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := e.Subscribe(graphql.Params{Schema: schema, RequestString: "subscription { ticks }", Context: ctx})
+
+	executing := func() bool {
+		for _, span := range exporter.GetSpans() {
+			if span.Name == "graphql.execute" {
+				return false
+			}
+		}
+		return true
+	}
+
+	// the execution span covers event delivery
+	events <- 1
+	<-ch
+	if !executing() {
+		t.Fatal("expected the execution span to end with the subscription")
+	}
+
+	cancel()
+	for range ch {
+	}
+	if executing() {
+		t.Fatal("expected the execution span to end when the subscription ended")
+	}
+}
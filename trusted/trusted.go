@@ -0,0 +1,168 @@
+package trusted
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+)
+
+// ApolloManifestFormat is the format of an apollo persisted query manifest
+const ApolloManifestFormat = "apollo-persisted-query-manifest"
+
+// Errors returned when resolving a document
+var (
+	ErrDocumentNotFound  = gqlerror.New(gqlerror.CodePersistedQueryNotFound, "PersistedQueryNotFound")
+	ErrUntrustedDocument = gqlerror.New(gqlerror.CodeForbidden, "arbitrary queries are not allowed, send a trusted document id")
+	ErrMissingDocument   = gqlerror.New(gqlerror.CodeBadRequest, "no query or document id provided")
+)
+
+// Manifest is the set of trusted documents keyed by document id. When a
+// manifest is configured only its documents can be executed. A nil
+// manifest is valid and trusts every query
+type Manifest struct {
+	mx        sync.RWMutex
+	documents map[string]string
+	hashes    map[string]bool
+}
+
+// NewManifest creates a new empty manifest
+func NewManifest() *Manifest {
+	return &Manifest{
+		documents: map[string]string{},
+		hashes:    map[string]bool{},
+	}
+}
+
+// apolloManifest is an apollo persisted query manifest
+type apolloManifest struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	Operations []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// ParseManifest parses an apollo persisted query manifest or a relay
+// manifest, which is an object of document ids to query text
+func ParseManifest(b []byte) (*Manifest, error) {
+	m := NewManifest()
+
+	var apollo apolloManifest
+	if err := json.Unmarshal(b, &apollo); err == nil && apollo.Format != "" {
+		if apollo.Format != ApolloManifestFormat {
+			return nil, fmt.Errorf("unsupported manifest format %q", apollo.Format)
+		}
+
+		for _, op := range apollo.Operations {
+			if op.ID == "" {
+				return nil, fmt.Errorf("manifest operation %q has no id", op.Name)
+			}
+			m.Add(op.ID, op.Body)
+		}
+
+		return m, nil
+	}
+
+	var relay map[string]string
+	if err := json.Unmarshal(b, &relay); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %s", err)
+	}
+
+	for id, query := range relay {
+		m.Add(id, query)
+	}
+
+	return m, nil
+}
+
+// LoadManifest reads and parses a manifest file
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseManifest(b)
+}
+
+// Add adds a trusted document
+func (m *Manifest) Add(id, query string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.documents[id] = query
+	m.hashes[document.Hash(query)] = true
+}
+
+// Lookup returns the query text of a document
+func (m *Manifest) Lookup(id string) (string, bool) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	query, ok := m.documents[id]
+	return query, ok
+}
+
+// Len returns the number of trusted documents
+func (m *Manifest) Len() int {
+	if m == nil {
+		return 0
+	}
+
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return len(m.documents)
+}
+
+// Resolve returns the query text to execute. The document is identified
+// by the document id or the sha256Hash of the persistedQuery extension,
+// otherwise the query text is only allowed if it matches a trusted
+// document exactly
+func (m *Manifest) Resolve(query, documentID string, extensions map[string]interface{}) (string, error) {
+	if m == nil {
+		return query, nil
+	}
+
+	if documentID == "" {
+		documentID = persistedQueryHash(extensions)
+	}
+
+	if documentID != "" {
+		doc, ok := m.Lookup(documentID)
+		if !ok {
+			return "", ErrDocumentNotFound
+		}
+		return doc, nil
+	}
+
+	if query == "" {
+		return "", ErrMissingDocument
+	}
+
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	if !m.hashes[document.Hash(query)] {
+		return "", ErrUntrustedDocument
+	}
+
+	return query, nil
+}
+
+// persistedQueryHash gets the hash from the persistedQuery extension
+func persistedQueryHash(extensions map[string]interface{}) string {
+	pq, ok := extensions["persistedQuery"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	hash, _ := pq["sha256Hash"].(string)
+	return hash
+}
@@ -0,0 +1,67 @@
+package trusted_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	server "github.com/bhoriuchi/graphql-go-server"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/trusted"
+)
+
+func TestParseManifest(t *testing.T) {
+	apollo := `{
+		"format": "apollo-persisted-query-manifest",
+		"version": 1,
+		"operations": [{"id": "abc", "name": "Hello", "type": "query", "body": "query Hello { hello }"}]
+	}`
+	relay := `{"abc": "query Hello { hello }"}`
+
+	for name, b := range map[string]string{"apollo": apollo, "relay": relay} {
+		m, err := trusted.ParseManifest([]byte(b))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if query, ok := m.Lookup("abc"); !ok || query != "query Hello { hello }" {
+			t.Fatalf("%s: expected document abc, got %q", name, query)
+		}
+	}
+}
+
+func TestTrustedDocuments(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	m := trusted.NewManifest()
+	m.Add("abc", "query Hello { hello }")
+	srv := server.New(schema, server.WithTrustedDocuments(m))
+
+	tests := map[string]struct {
+		body   string
+		status int
+		want   string
+	}{
+		"document id":     {`{"documentId":"abc"}`, http.StatusOK, `"world"`},
+		"persisted query": {`{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"abc"}}}`, http.StatusOK, `"world"`},
+		"trusted query":   {`{"query":"query Hello { hello }"}`, http.StatusOK, `"world"`},
+		"unknown id":      {`{"documentId":"xyz"}`, http.StatusOK, `"PERSISTED_QUERY_NOT_FOUND"`},
+		"unknown hash":    {`{"extensions":{"persistedQuery":{"version":1,"sha256Hash":"xyz"}}}`, http.StatusOK, `"PersistedQueryNotFound"`},
+		"arbitrary query": {`{"query":"{ hello }"}`, http.StatusBadRequest, `"FORBIDDEN"`},
+	}
+
+	for name, tc := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Fatalf("%s: expected status %d, got %d", name, tc.status, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Fatalf("%s: unexpected response %s", name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket implements a token bucket rate limiter. The bucket holds
+// up to burst tokens and is refilled at rate tokens per second
+type TokenBucket struct {
+	mx     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a new token bucket. If burst is less than 1
+// it defaults to the rate rounded up
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last refill, the caller
+// must hold the lock
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+
+	if elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+}
+
+// Allow takes a token from the bucket and returns true if one was available
+func (b *TokenBucket) Allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// full returns true if the bucket has refilled completely
+func (b *TokenBucket) full(now time.Time) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// KeyedLimiter maintains a token bucket per key
+type KeyedLimiter struct {
+	mx        sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*TokenBucket
+	lastSweep time.Time
+}
+
+// NewKeyedLimiter creates a new keyed limiter where each key is allowed
+// rate operations per second with the specified burst
+func NewKeyedLimiter(rate float64, burst int) *KeyedLimiter {
+	return &KeyedLimiter{
+		rate:      rate,
+		burst:     burst,
+		buckets:   map[string]*TokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket for the key and returns true if
+// one was available
+func (l *KeyedLimiter) Allow(key string) bool {
+	l.mx.Lock()
+	now := time.Now()
+
+	// buckets that have refilled completely are equivalent to new ones
+	// so they can be removed to keep the map from growing unbounded
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewTokenBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	l.mx.Unlock()
+
+	return b.Allow()
+}
+
+// ConnectionLimiter limits the number of concurrent connections per key
+type ConnectionLimiter struct {
+	mx     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+// NewConnectionLimiter creates a new connection limiter
+func NewConnectionLimiter(max int) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		max:    max,
+		counts: map[string]int{},
+	}
+}
+
+// Acquire reserves a connection for the key. If the limit has been reached
+// ok is false, otherwise release must be called when the connection ends.
+// Calling release more than once has no effect
+func (l *ConnectionLimiter) Acquire(key string) (release func(), ok bool) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.counts[key] >= l.max {
+		return nil, false
+	}
+
+	l.counts[key]++
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			l.mx.Lock()
+			defer l.mx.Unlock()
+
+			l.counts[key]--
+			if l.counts[key] <= 0 {
+				delete(l.counts, key)
+			}
+		})
+	}
+
+	return release, true
+}
+
+// Count returns the number of active connections for the key
+func (l *ConnectionLimiter) Count(key string) int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.counts[key]
+}
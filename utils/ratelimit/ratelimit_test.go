@@ -0,0 +1,49 @@
+package ratelimit_test
+
+import (
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/utils/ratelimit"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := ratelimit.NewTokenBucket(1, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("expected token %d to be allowed", i+1)
+		}
+	}
+
+	if b.Allow() {
+		t.Fatal("expected bucket to be exhausted")
+	}
+}
+
+func TestKeyedLimiter(t *testing.T) {
+	l := ratelimit.NewKeyedLimiter(1, 1)
+	if !l.Allow("a") || !l.Allow("b") {
+		t.Fatal("expected first request per key to be allowed")
+	}
+
+	if l.Allow("a") {
+		t.Fatal("expected second request for key a to be limited")
+	}
+}
+
+func TestConnectionLimiter(t *testing.T) {
+	l := ratelimit.NewConnectionLimiter(1)
+	release, ok := l.Acquire("127.0.0.1")
+	if !ok {
+		t.Fatal("expected first connection to be allowed")
+	}
+
+	if _, ok := l.Acquire("127.0.0.1"); ok {
+		t.Fatal("expected second connection to be limited")
+	}
+
+	release()
+	release()
+	if n := l.Count("127.0.0.1"); n != 0 {
+		t.Fatalf("expected 0 connections after release, got %d", n)
+	}
+}
@@ -0,0 +1,316 @@
+// Package apigateway serves the graphql-transport-ws protocol behind an
+// AWS API Gateway websocket api. Connect, message and disconnect events
+// drive a protocol connection and outbound messages are posted to the
+// management api.
+//
+// Connections are kept in memory for the life of the process so
+// subscriptions run in the process that started them, state persisted
+// in the store lets any process resume an acknowledged connection and
+// restart its subscriptions.
+//
+// Handle returns once the results of queries and mutations are posted
+// but subscription events are posted as they are produced. A runtime that
+// freezes the process between invocations, such as AWS Lambda, only
+// delivers them while an invocation is running, so subscriptions need a
+// long running process
+package apigateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/graphql-go/graphql"
+)
+
+// Config configures the handler. Protocol is the configuration of every
+// connection, its transport, request and restored state are set by the
+// handler
+type Config struct {
+	Protocol graphqltransportws.Config
+	Store    Store
+	Poster   Poster
+}
+
+// Handler handles API Gateway websocket events
+type Handler struct {
+	config Config
+	store  Store
+	poster Poster
+	log    *logger.LogWrapper
+	codec  codec.Codec
+	mx     sync.Mutex
+	conns  map[string]*eventTransport
+}
+
+// New creates a new handler, the store defaults to a memory store
+func New(config Config) *Handler {
+	store := config.Store
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	log := config.Protocol.Logger
+	if log == nil {
+		log = logger.NewLogWrapper(logger.NoopLogFunc, nil)
+		config.Protocol.Logger = log
+	}
+
+	return &Handler{
+		config: config,
+		store:  store,
+		poster: config.Poster,
+		log:    log,
+		codec:  codec.OrDefault(config.Protocol.Codec),
+		conns:  map[string]*eventTransport{},
+	}
+}
+
+// Handle handles an event. A connection_init message event returns once
+// the connection_ack is posted and a subscribe message event once its
+// query or mutation result and complete are posted or its subscription
+// has started. Responses to other messages are posted asynchronously
+func (h *Handler) Handle(ctx context.Context, event Event) (Response, error) {
+	switch event.RequestContext.EventType {
+	case EventTypeConnect:
+		return h.handleConnect(ctx, event)
+	case EventTypeMessage:
+		return h.handleMessage(ctx, event)
+	case EventTypeDisconnect:
+		return h.handleDisconnect(ctx, event)
+	}
+
+	return Response{StatusCode: http.StatusBadRequest}, fmt.Errorf("unsupported event type %q", event.RequestContext.EventType)
+}
+
+// handleConnect negotiates the subprotocol and stores the connection
+func (h *Handler) handleConnect(ctx context.Context, event Event) (Response, error) {
+	resp := Response{StatusCode: http.StatusOK}
+
+	if requested := event.Header("Sec-WebSocket-Protocol"); requested != "" {
+		accepted := false
+		for _, p := range strings.Split(requested, ",") {
+			if strings.TrimSpace(p) == graphqltransportws.Subprotocol {
+				accepted = true
+				break
+			}
+		}
+
+		if !accepted {
+			h.log.Warnf("Connection does not implement the GraphQL WS protocol. Subprotocol: %q", requested)
+			return Response{StatusCode: http.StatusBadRequest}, nil
+		}
+
+		resp.Headers = map[string]string{
+			"Sec-WebSocket-Protocol": graphqltransportws.Subprotocol,
+		}
+	}
+
+	connectedAt := time.Now()
+	if event.RequestContext.ConnectedAt > 0 {
+		connectedAt = time.UnixMilli(event.RequestContext.ConnectedAt)
+	}
+
+	if err := h.store.PutConnection(ctx, &Connection{
+		ID:          event.RequestContext.ConnectionID,
+		Endpoint:    event.Endpoint(),
+		RemoteAddr:  event.RequestContext.Identity.SourceIP,
+		Headers:     event.httpHeaders(),
+		ConnectedAt: connectedAt,
+	}); err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	return resp, nil
+}
+
+// handleMessage delivers a message to the connection
+func (h *Handler) handleMessage(ctx context.Context, event Event) (Response, error) {
+	id := event.RequestContext.ConnectionID
+
+	body, err := event.body()
+	if err != nil {
+		return Response{StatusCode: http.StatusBadRequest}, err
+	}
+
+	t, err := h.connection(ctx, id)
+	if err == ErrNotFound {
+		h.log.WithField("connectionId", id).Warnf("message received for unknown connection")
+		if err := h.poster.Delete(ctx, event.Endpoint(), id); err != nil && err != ErrGone {
+			h.log.WithError(err).Errorf("failed to disconnect unknown connection")
+		}
+		return Response{StatusCode: http.StatusGone}, nil
+	} else if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	// wait for the messages posted in response so they are sent before
+	// the invocation ends
+	var wait chan struct{}
+	if msg, ok := t.decode(body); ok {
+		t.track(ctx, msg, true)
+		wait = t.waiter(msg)
+	}
+
+	delivered, err := t.deliver(ctx, body)
+	if err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	} else if !delivered {
+		return Response{StatusCode: http.StatusGone}, nil
+	}
+
+	if wait != nil {
+		if err := t.wait(ctx, wait); err != nil {
+			return Response{StatusCode: http.StatusGatewayTimeout}, err
+		}
+	}
+
+	return Response{StatusCode: http.StatusOK}, nil
+}
+
+// handleDisconnect closes the connection and removes its state
+func (h *Handler) handleDisconnect(ctx context.Context, event Event) (Response, error) {
+	id := event.RequestContext.ConnectionID
+
+	h.mx.Lock()
+	t, ok := h.conns[id]
+	delete(h.conns, id)
+	h.mx.Unlock()
+
+	if ok {
+		t.disconnect()
+	}
+
+	if err := h.store.DeleteConnection(ctx, id); err != nil {
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	return Response{StatusCode: http.StatusOK}, nil
+}
+
+// connection returns the live connection, restoring it from the store
+// when it was started by another process
+func (h *Handler) connection(ctx context.Context, id string) (*eventTransport, error) {
+	h.mx.Lock()
+	t, ok := h.conns[id]
+	h.mx.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	conn, err := h.store.GetConnection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// register the transport before starting the connection so events
+	// received meanwhile are delivered to it, another event may have
+	// restored the connection during the lookup
+	h.mx.Lock()
+	if t, ok := h.conns[id]; ok {
+		h.mx.Unlock()
+		return t, nil
+	}
+	t = newEventTransport(h, conn)
+	h.conns[id] = t
+	h.mx.Unlock()
+
+	config := h.config.Protocol
+	config.Transport = t
+	config.WS = nil
+	config.Request = conn.request().WithContext(ctx)
+	config.Acknowledged = conn.Acknowledged
+	config.ConnectionParams = conn.ConnectionParams
+
+	// connections are resumed from the store rather than held in memory
+	config.Sessions = nil
+
+	// a started subscription releases the handler, its results are posted
+	// as they are produced
+	onOperation := config.OnOperation
+	config.OnOperation = func(c protocol.Context, msg graphqltransportws.SubscribeMessage, args graphql.Params, result interface{}) (interface{}, error) {
+		if onOperation != nil {
+			maybeResult, err := onOperation(c, msg, args, result)
+			if err != nil {
+				return nil, err
+			}
+			if maybeResult != nil {
+				result = maybeResult
+			}
+		}
+		if _, ok := result.(chan *graphql.Result); ok {
+			t.release(waitKey{msgType: protocol.MsgSubscribe, id: msg.ID})
+		}
+		return result, nil
+	}
+
+	onClose := config.OnClose
+	config.OnClose = func(c protocol.Context, code graphqltransportws.CloseCode, reason string) {
+		h.remove(id, t)
+		if onClose != nil {
+			onClose(c, code, reason)
+		}
+	}
+
+	if _, err := graphqltransportws.NewConnection(ctx, config); err != nil {
+		return nil, err
+	}
+
+	if err := h.restore(ctx, t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// restore restarts the subscriptions of a connection restored from the
+// store, they ended with the process that started them
+func (h *Handler) restore(ctx context.Context, t *eventTransport) error {
+	subs, err := h.store.Subscriptions(ctx, t.conn.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		var payload map[string]interface{}
+		if err := h.codec.Unmarshal(sub.Payload, &payload); err != nil {
+			h.log.WithError(err).WithField("subscriptionId", sub.ID).Errorf("failed to restore subscription")
+			continue
+		}
+
+		b, err := h.codec.Marshal(protocol.OperationMessage{
+			ID:      sub.ID,
+			Type:    protocol.MsgSubscribe,
+			Payload: payload,
+		})
+		if err != nil {
+			return err
+		}
+
+		if delivered, err := t.deliver(ctx, b); err != nil || !delivered {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove removes a closed connection and its state
+func (h *Handler) remove(id string, t *eventTransport) {
+	h.mx.Lock()
+	if h.conns[id] == t {
+		delete(h.conns, id)
+	}
+	h.mx.Unlock()
+
+	if err := h.store.DeleteConnection(context.Background(), id); err != nil {
+		h.log.WithError(err).Errorf("failed to delete connection state")
+	}
+}
@@ -0,0 +1,237 @@
+package apigateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/apigateway"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/graphql-go/graphql"
+)
+
+const connectionID = "Q2xZ3cJKIAMCJWw="
+
+type call struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+func loadEvent(t *testing.T, name string) apigateway.Event {
+	b, err := os.ReadFile("testdata/" + name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event apigateway.Event
+	if err := json.Unmarshal(b, &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// managementAPI starts a management api stand-in and returns a poster
+// for it and a func that waits for the next call
+func managementAPI(t *testing.T) (*apigateway.HTTPPoster, chan call, func() call) {
+	calls := make(chan call, 10)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := call{method: r.Method, path: r.URL.Path}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &c.body)
+		calls <- c
+	}))
+	t.Cleanup(api.Close)
+
+	next := func() call {
+		t.Helper()
+		select {
+		case c := <-calls:
+			if !strings.HasSuffix(c.path, "/@connections/"+connectionID) {
+				t.Fatalf("unexpected path %q", c.path)
+			}
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for management api call")
+		}
+		return call{}
+	}
+
+	return &apigateway.HTTPPoster{Endpoint: api.URL}, calls, next
+}
+
+func TestHandler(t *testing.T) {
+	schema := testutil.Hello(t)
+	poster, calls, _ := managementAPI(t)
+
+	ctx := context.Background()
+	store := apigateway.NewMemoryStore()
+	newHandler := func() *apigateway.Handler {
+		return apigateway.New(apigateway.Config{
+			Protocol: graphqltransportws.Config{Schema: &schema},
+			Store:    store,
+			Poster:   poster,
+		})
+	}
+
+	// responses to connection_init and subscribe are posted before Handle
+	// returns
+	posted := func() call {
+		t.Helper()
+		select {
+		case c := <-calls:
+			return c
+		default:
+			t.Fatal("expected a management api call before Handle returned")
+		}
+		return call{}
+	}
+
+	h := newHandler()
+	resp, err := h.Handle(ctx, loadEvent(t, "connect"))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("connect failed: %d %v", resp.StatusCode, err)
+	}
+	if resp.Headers["Sec-WebSocket-Protocol"] != graphqltransportws.Subprotocol {
+		t.Fatalf("expected subprotocol header, got %v", resp.Headers)
+	}
+
+	if _, err := h.Handle(ctx, loadEvent(t, "connection_init")); err != nil {
+		t.Fatal(err)
+	}
+	if c := posted(); c.method != http.MethodPost || c.body["type"] != "connection_ack" {
+		t.Fatalf("expected connection_ack, got %v", c)
+	}
+
+	// the acknowledged connection is resumed by another handler
+	conn, err := store.GetConnection(ctx, connectionID)
+	if err != nil || !conn.Acknowledged || conn.ConnectionParams["authorization"] != "Bearer token" {
+		t.Fatalf("expected acknowledged connection state, got %+v %v", conn, err)
+	}
+
+	h = newHandler()
+	if _, err := h.Handle(ctx, loadEvent(t, "subscribe")); err != nil {
+		t.Fatal(err)
+	}
+	if c := posted(); c.body["type"] != "next" {
+		t.Fatalf("expected next, got %v", c)
+	}
+	if c := posted(); c.body["type"] != "complete" {
+		t.Fatalf("expected complete, got %v", c)
+	}
+
+	if _, err := h.Handle(ctx, loadEvent(t, "disconnect")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetConnection(ctx, connectionID); err != apigateway.ErrNotFound {
+		t.Fatalf("expected connection state to be deleted, got %v", err)
+	}
+
+	select {
+	case c := <-calls:
+		t.Fatalf("unexpected management api call after disconnect %v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRestoreSubscriptions(t *testing.T) {
+	events := make(chan interface{})
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"count": testutil.Events(events)},
+	})
+	poster, _, next := managementAPI(t)
+
+	// the state of a connection whose subscription was started by
+	// another process
+	ctx := context.Background()
+	store := apigateway.NewMemoryStore()
+	store.PutConnection(ctx, &apigateway.Connection{
+		ID:           connectionID,
+		Endpoint:     "https://abcdef1234.execute-api.us-east-1.amazonaws.com/prod",
+		Acknowledged: true,
+	})
+	store.PutSubscription(ctx, &apigateway.Subscription{
+		ConnectionID: connectionID,
+		ID:           "1",
+		Payload:      json.RawMessage(`{"query":"subscription { count }"}`),
+	})
+
+	h := apigateway.New(apigateway.Config{
+		Protocol: graphqltransportws.Config{Schema: &schema},
+		Store:    store,
+		Poster:   poster,
+	})
+
+	ping := loadEvent(t, "subscribe")
+	ping.Body = `{"type":"ping"}`
+	ping.IsBase64Encoded = false
+	if _, err := h.Handle(ctx, ping); err != nil {
+		t.Fatal(err)
+	}
+	if c := next(); c.body["type"] != "pong" {
+		t.Fatalf("expected pong, got %v", c)
+	}
+
+	select {
+	case events <- 1:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription was not restored")
+	}
+	if c := next(); c.body["type"] != "next" || c.body["id"] != "1" {
+		t.Fatalf("expected next for the restored subscription, got %v", c)
+	}
+}
+
+func TestHandleSubscription(t *testing.T) {
+	events := make(chan interface{})
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"count": testutil.Events(events)},
+	})
+	poster, _, next := managementAPI(t)
+
+	ctx := context.Background()
+	store := apigateway.NewMemoryStore()
+	store.PutConnection(ctx, &apigateway.Connection{
+		ID:           connectionID,
+		Endpoint:     "https://abcdef1234.execute-api.us-east-1.amazonaws.com/prod",
+		Acknowledged: true,
+	})
+
+	h := apigateway.New(apigateway.Config{
+		Protocol: graphqltransportws.Config{Schema: &schema},
+		Store:    store,
+		Poster:   poster,
+	})
+
+	// Handle returns once the subscription has started rather than when
+	// it completes
+	subscribe := loadEvent(t, "subscribe")
+	subscribe.Body = `{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`
+	subscribe.IsBase64Encoded = false
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Handle(ctx, subscribe)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Handle to return once the subscription started")
+	}
+
+	events <- 1
+	if c := next(); c.body["type"] != "next" || c.body["id"] != "1" {
+		t.Fatalf("expected next, got %v", c)
+	}
+}
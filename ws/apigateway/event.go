@@ -0,0 +1,115 @@
+package apigateway
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Event types of an API Gateway websocket event
+const (
+	EventTypeConnect    = "CONNECT"
+	EventTypeMessage    = "MESSAGE"
+	EventTypeDisconnect = "DISCONNECT"
+)
+
+// Event is an API Gateway websocket proxy event
+type Event struct {
+	RequestContext        RequestContext      `json:"requestContext"`
+	Headers               map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders     map[string][]string `json:"multiValueHeaders,omitempty"`
+	QueryStringParameters map[string]string   `json:"queryStringParameters,omitempty"`
+	Body                  string              `json:"body,omitempty"`
+	IsBase64Encoded       bool                `json:"isBase64Encoded"`
+}
+
+// RequestContext is the request context of an event
+type RequestContext struct {
+	RouteKey     string   `json:"routeKey"`
+	EventType    string   `json:"eventType"`
+	ConnectionID string   `json:"connectionId"`
+	DomainName   string   `json:"domainName"`
+	Stage        string   `json:"stage"`
+	APIID        string   `json:"apiId"`
+	RequestID    string   `json:"requestId"`
+	ConnectedAt  int64    `json:"connectedAt"`
+	Identity     Identity `json:"identity"`
+}
+
+// Identity is the caller identity of an event
+type Identity struct {
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+// Response is the response to an event
+type Response struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+}
+
+// Endpoint returns the management api endpoint of the event
+func (e Event) Endpoint() string {
+	return "https://" + e.RequestContext.DomainName + "/" + e.RequestContext.Stage
+}
+
+// Header returns the first value of the header, header names are case
+// insensitive
+func (e Event) Header(name string) string {
+	for k, v := range e.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+
+	for k, v := range e.MultiValueHeaders {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+
+	return ""
+}
+
+// body returns the decoded event body
+func (e Event) body() ([]byte, error) {
+	if e.IsBase64Encoded {
+		return base64.StdEncoding.DecodeString(e.Body)
+	}
+	return []byte(e.Body), nil
+}
+
+// httpHeaders returns the event headers
+func (e Event) httpHeaders() map[string]string {
+	headers := map[string]string{}
+	for k, v := range e.MultiValueHeaders {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// request creates the http request a connection presents to hooks in
+// place of the upgrade request
+func (c *Connection) request() *http.Request {
+	header := http.Header{}
+	for k, v := range c.Headers {
+		header.Set(k, v)
+	}
+
+	return &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: "/"},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		RemoteAddr: c.RemoteAddr,
+	}
+}
@@ -0,0 +1,81 @@
+package apigateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrGone is returned by a poster when the connection no longer exists
+var ErrGone = errors.New("connection gone")
+
+// Poster sends messages to and disconnects clients through the API
+// Gateway management api
+type Poster interface {
+	Post(ctx context.Context, endpoint, connectionID string, data []byte) error
+	Delete(ctx context.Context, endpoint, connectionID string) error
+}
+
+// HTTPPoster calls the management api over http. Requests must be signed
+// with AWS signature version 4 by Sign unless the endpoint does not
+// require it
+type HTTPPoster struct {
+	Client *http.Client
+	Sign   func(r *http.Request) error
+
+	// Endpoint overrides the endpoint of the event, for example to use a
+	// custom domain or a local stand-in
+	Endpoint string
+}
+
+// Post implements Poster
+func (p *HTTPPoster) Post(ctx context.Context, endpoint, connectionID string, data []byte) error {
+	return p.do(ctx, http.MethodPost, endpoint, connectionID, data)
+}
+
+// Delete implements Poster
+func (p *HTTPPoster) Delete(ctx context.Context, endpoint, connectionID string) error {
+	return p.do(ctx, http.MethodDelete, endpoint, connectionID, nil)
+}
+
+func (p *HTTPPoster) do(ctx context.Context, method, endpoint, connectionID string, data []byte) error {
+	if p.Endpoint != "" {
+		endpoint = p.Endpoint
+	}
+
+	u := strings.TrimSuffix(endpoint, "/") + "/@connections/" + url.PathEscape(connectionID)
+	r, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if p.Sign != nil {
+		if err := p.Sign(r); err != nil {
+			return err
+		}
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("management api %s failed with status %d", method, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package apigateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a store when a connection does not exist
+var ErrNotFound = errors.New("connection not found")
+
+// Connection is the persisted state of a websocket connection
+type Connection struct {
+	ID               string                 `json:"id"`
+	Endpoint         string                 `json:"endpoint"`
+	RemoteAddr       string                 `json:"remoteAddr,omitempty"`
+	Headers          map[string]string      `json:"headers,omitempty"`
+	Acknowledged     bool                   `json:"acknowledged"`
+	ConnectionParams map[string]interface{} `json:"connectionParams,omitempty"`
+	ConnectedAt      time.Time              `json:"connectedAt"`
+}
+
+// Subscription is the persisted state of an active operation
+type Subscription struct {
+	ConnectionID string          `json:"connectionId"`
+	ID           string          `json:"id"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+}
+
+// Store persists connection and subscription state between events.
+// Subscriptions are restarted when another process restores their
+// connection. Deleting a connection also deletes its subscriptions and
+// deleting a missing record is not an error
+type Store interface {
+	GetConnection(ctx context.Context, connectionID string) (*Connection, error)
+	PutConnection(ctx context.Context, conn *Connection) error
+	DeleteConnection(ctx context.Context, connectionID string) error
+	PutSubscription(ctx context.Context, sub *Subscription) error
+	DeleteSubscription(ctx context.Context, connectionID, id string) error
+	Subscriptions(ctx context.Context, connectionID string) ([]*Subscription, error)
+}
+
+// MemoryStore is an in-memory store for a single process
+type MemoryStore struct {
+	mx            sync.RWMutex
+	connections   map[string]Connection
+	subscriptions map[string]map[string]Subscription
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		connections:   map[string]Connection{},
+		subscriptions: map[string]map[string]Subscription{},
+	}
+}
+
+// GetConnection implements Store
+func (s *MemoryStore) GetConnection(ctx context.Context, connectionID string) (*Connection, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	conn, ok := s.connections[connectionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &conn, nil
+}
+
+// PutConnection implements Store
+func (s *MemoryStore) PutConnection(ctx context.Context, conn *Connection) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.connections[conn.ID] = *conn
+	return nil
+}
+
+// DeleteConnection implements Store
+func (s *MemoryStore) DeleteConnection(ctx context.Context, connectionID string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.connections, connectionID)
+	delete(s.subscriptions, connectionID)
+	return nil
+}
+
+// PutSubscription implements Store
+func (s *MemoryStore) PutSubscription(ctx context.Context, sub *Subscription) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	subs, ok := s.subscriptions[sub.ConnectionID]
+	if !ok {
+		subs = map[string]Subscription{}
+		s.subscriptions[sub.ConnectionID] = subs
+	}
+	subs[sub.ID] = *sub
+
+	return nil
+}
+
+// DeleteSubscription implements Store
+func (s *MemoryStore) DeleteSubscription(ctx context.Context, connectionID, id string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.subscriptions[connectionID], id)
+	return nil
+}
+
+// Subscriptions implements Store
+func (s *MemoryStore) Subscriptions(ctx context.Context, connectionID string) ([]*Subscription, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	subs := []*Subscription{}
+	for _, sub := range s.subscriptions[connectionID] {
+		sub := sub
+		subs = append(subs, &sub)
+	}
+
+	return subs, nil
+}
@@ -0,0 +1,250 @@
+package apigateway
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+)
+
+// DeleteTimeout is the timeout for disconnecting a client after the
+// server closed the connection
+var DeleteTimeout = 5 * time.Second
+
+// eventTransport is a transport fed by message events that posts outbound
+// messages through the management api
+type eventTransport struct {
+	h        *Handler
+	conn     *Connection
+	in       chan []byte
+	done     chan struct{}
+	once     sync.Once
+	mx       sync.Mutex
+	gone     bool
+	closeErr error
+	stateMx  sync.Mutex
+	waitMx   sync.Mutex
+	waiters  map[waitKey]chan struct{}
+}
+
+// waitKey identifies the inbound message a handler waits on
+type waitKey struct {
+	msgType protocol.MessageType
+	id      string
+}
+
+func newEventTransport(h *Handler, conn *Connection) *eventTransport {
+	return &eventTransport{
+		h:       h,
+		conn:    conn,
+		in:      make(chan []byte),
+		done:    make(chan struct{}),
+		waiters: map[waitKey]chan struct{}{},
+	}
+}
+
+// Subprotocol implements transport.Transport
+func (t *eventTransport) Subprotocol() string {
+	return graphqltransportws.Subprotocol
+}
+
+// ReadMessage implements transport.Transport
+func (t *eventTransport) ReadMessage(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-t.in:
+		return b, nil
+	case <-t.done:
+		return nil, t.err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WriteMessage implements transport.Transport, the state is stored before
+// posting so it is current once the client receives the message
+func (t *eventTransport) WriteMessage(ctx context.Context, b []byte) error {
+	msg, ok := t.decode(b)
+	if ok {
+		t.track(ctx, msg, false)
+	}
+
+	if err := t.h.poster.Post(ctx, t.conn.Endpoint, t.conn.ID, b); err != nil {
+		if err == ErrGone {
+			t.disconnect()
+		}
+		return err
+	}
+
+	// release the handler waiting on the message this one answers
+	switch {
+	case !ok:
+	case msg.Type == protocol.MsgConnectionAck:
+		t.release(waitKey{msgType: protocol.MsgConnectionInit})
+	case msg.Type == protocol.MsgComplete, msg.Type == protocol.MsgError:
+		t.release(waitKey{msgType: protocol.MsgSubscribe, id: msg.ID})
+	}
+
+	return nil
+}
+
+// Close implements transport.Transport, the client is disconnected unless
+// it has already gone. API Gateway does not forward close codes
+func (t *eventTransport) Close(code int, reason string) error {
+	var err error
+	t.once.Do(func() {
+		t.mx.Lock()
+		gone := t.gone
+		t.closeErr = transport.ErrClosed
+		t.mx.Unlock()
+		close(t.done)
+
+		if !gone {
+			ctx, cancel := context.WithTimeout(context.Background(), DeleteTimeout)
+			defer cancel()
+			if err = t.h.poster.Delete(ctx, t.conn.Endpoint, t.conn.ID); err == ErrGone {
+				err = nil
+			}
+		}
+	})
+	return err
+}
+
+// deliver feeds a message event to the protocol, it returns false if the
+// transport is closed
+func (t *eventTransport) deliver(ctx context.Context, b []byte) (bool, error) {
+	select {
+	case t.in <- b:
+		return true, nil
+	case <-t.done:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// disconnect closes the transport after the client has gone, reads
+// return a normal closure so the protocol closes cleanly
+func (t *eventTransport) disconnect() {
+	t.once.Do(func() {
+		t.mx.Lock()
+		t.gone = true
+		t.closeErr = &transport.CloseError{
+			Code:   transport.CloseNormalClosure,
+			Reason: "client disconnected",
+		}
+		t.mx.Unlock()
+		close(t.done)
+	})
+}
+
+// waiter returns a channel that is closed once the messages posted in
+// response to the inbound message have been posted, the connection_ack of
+// a connection_init and the result of a subscribe. It returns nil for
+// other messages
+func (t *eventTransport) waiter(msg trackedMessage) chan struct{} {
+	key := waitKey{msgType: msg.Type}
+	switch msg.Type {
+	case protocol.MsgConnectionInit:
+	case protocol.MsgSubscribe:
+		key.id = msg.ID
+	default:
+		return nil
+	}
+
+	t.waitMx.Lock()
+	defer t.waitMx.Unlock()
+
+	ch, ok := t.waiters[key]
+	if !ok {
+		ch = make(chan struct{})
+		t.waiters[key] = ch
+	}
+	return ch
+}
+
+// release releases the handler waiting on the key
+func (t *eventTransport) release(key waitKey) {
+	t.waitMx.Lock()
+	defer t.waitMx.Unlock()
+
+	if ch, ok := t.waiters[key]; ok {
+		close(ch)
+		delete(t.waiters, key)
+	}
+}
+
+// wait waits until the channel is closed, the transport is closed or the
+// context is done
+func (t *eventTransport) wait(ctx context.Context, ch chan struct{}) error {
+	select {
+	case <-ch:
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (t *eventTransport) err() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.closeErr
+}
+
+// trackedMessage is the part of a message used to track state
+type trackedMessage struct {
+	ID      string                 `json:"id"`
+	Type    protocol.MessageType   `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// decode decodes the part of a message used to track state
+func (t *eventTransport) decode(b []byte) (trackedMessage, bool) {
+	var msg trackedMessage
+	if err := t.h.codec.Unmarshal(b, &msg); err != nil {
+		return msg, false
+	}
+	return msg, true
+}
+
+// track persists the connection and subscription state changed by an
+// inbound or outbound message
+func (t *eventTransport) track(ctx context.Context, msg trackedMessage, inbound bool) {
+	var (
+		err   error
+		store = t.h.store
+		id    = t.conn.ID
+	)
+
+	t.stateMx.Lock()
+	defer t.stateMx.Unlock()
+
+	switch {
+	case inbound && msg.Type == protocol.MsgConnectionInit:
+		t.conn.ConnectionParams = msg.Payload
+
+	case !inbound && msg.Type == protocol.MsgConnectionAck:
+		t.conn.Acknowledged = true
+		err = store.PutConnection(ctx, t.conn)
+
+	case inbound && msg.Type == protocol.MsgSubscribe:
+		var payload []byte
+		if payload, err = t.h.codec.Marshal(msg.Payload); err == nil {
+			err = store.PutSubscription(ctx, &Subscription{
+				ConnectionID: id,
+				ID:           msg.ID,
+				Payload:      payload,
+			})
+		}
+
+	case msg.Type == protocol.MsgComplete, !inbound && msg.Type == protocol.MsgError:
+		err = store.DeleteSubscription(ctx, id, msg.ID)
+	}
+
+	if err != nil {
+		t.h.log.WithError(err).WithField("connectionId", id).Errorf("failed to store connection state")
+	}
+}
@@ -0,0 +1,250 @@
+// Package fanout shares the execution of identical subscriptions. Each
+// unique subscription, identified by its normalized document, operation
+// name, variables and scope, is executed once and its results are sent
+// to every subscriber. The source is canceled when the last subscriber
+// leaves.
+//
+// A shared subscription is executed with the context and root value of
+// its first subscriber, so the scope must separate subscribers that can
+// observe different results, for example by tenant or role. Nothing is
+// shared without a scope func. Results are shared between subscribers
+// and must not be modified
+package fanout
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/printer"
+)
+
+// DefaultBuffer is the subscriber buffer used when none is configured
+const DefaultBuffer = 16
+
+// ScopeFunc returns the scope a subscription is shared within. Returning
+// false executes the subscription without sharing it
+type ScopeFunc func(ctx context.Context, p graphql.Params) (scope string, ok bool)
+
+// Unscoped shares identical subscriptions between every subscriber. Use
+// it only when results do not depend on the subscriber
+func Unscoped(ctx context.Context, p graphql.Params) (string, bool) {
+	return "", true
+}
+
+// Options configures the fan-out of shared subscriptions
+type Options struct {
+	// ScopeFunc partitions shared subscriptions, when it is nil
+	// subscriptions are not shared
+	ScopeFunc ScopeFunc
+
+	// Buffer is the number of results buffered for each subscriber, the
+	// oldest result is dropped when a subscriber falls behind
+	Buffer int
+}
+
+// Stats are fan-out metrics
+type Stats struct {
+	Sources     int
+	Subscribers int
+}
+
+// Hub executes shared subscriptions
+type Hub struct {
+	mx       sync.Mutex
+	executor *document.Executor
+	scope    ScopeFunc
+	buffer   int
+	sources  map[string]*source
+}
+
+// source is a shared subscription execution
+type source struct {
+	cancel      context.CancelFunc
+	subscribers map[*subscriber]struct{}
+}
+
+// subscriber receives the results of a source
+type subscriber struct {
+	ch   chan *graphql.Result
+	stop func() bool
+}
+
+// New creates a new hub executing subscriptions with the executor
+func New(executor *document.Executor, opts *Options) *Hub {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+
+	return &Hub{
+		executor: executor,
+		scope:    opts.ScopeFunc,
+		buffer:   buffer,
+		sources:  map[string]*source{},
+	}
+}
+
+// key returns the key identifying identical subscriptions
+func (h *Hub) key(p graphql.Params, d *document.Document) (string, bool) {
+	if h.scope == nil {
+		return "", false
+	}
+
+	ctx := p.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	scope, ok := h.scope(ctx, p)
+	if !ok {
+		return "", false
+	}
+
+	normalized, ok := printer.Print(d.AST).(string)
+	if !ok {
+		return "", false
+	}
+
+	variables, err := json.Marshal(p.VariableValues)
+	if err != nil {
+		return "", false
+	}
+
+	sum := sha256.New()
+	for _, part := range []string{scope, normalized, p.OperationName, string(variables)} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), true
+}
+
+// Subscribe subscribes to the shared execution of the subscription,
+// executing it if it is not already running. The subscription is left
+// when the params context is done. The document is the request string
+// parsed by the executor, subscriptions without one are not shared
+func (h *Hub) Subscribe(p graphql.Params, d *document.Document) chan *graphql.Result {
+	if d == nil || p.Context == nil {
+		return h.executor.ExecuteSubscription(p, d)
+	}
+
+	key, ok := h.key(p, d)
+	if !ok {
+		return h.executor.ExecuteSubscription(p, d)
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	src, ok := h.sources[key]
+	if !ok {
+		// the source outlives the subscriber that started it
+		ctx, cancel := context.WithCancel(context.WithoutCancel(p.Context))
+		src = &source{
+			cancel:      cancel,
+			subscribers: map[*subscriber]struct{}{},
+		}
+		h.sources[key] = src
+
+		shared := p
+		shared.Context = ctx
+		go h.fanout(key, src, h.executor.ExecuteSubscription(shared, d))
+	}
+
+	sub := &subscriber{
+		ch: make(chan *graphql.Result, h.buffer),
+	}
+	src.subscribers[sub] = struct{}{}
+	sub.stop = context.AfterFunc(p.Context, func() {
+		h.leave(key, src, sub)
+	})
+
+	return sub.ch
+}
+
+// leave removes the subscriber canceling the source when it was the
+// last subscriber
+func (h *Hub) leave(key string, src *source, sub *subscriber) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	delete(src.subscribers, sub)
+	if len(src.subscribers) == 0 && h.sources[key] == src {
+		delete(h.sources, key)
+		src.cancel()
+	}
+}
+
+// fanout sends the source results to the subscribers, closing their
+// channels when the source ends
+func (h *Hub) fanout(key string, src *source, ch chan *graphql.Result) {
+	for res := range ch {
+		h.mx.Lock()
+		subs := make([]*subscriber, 0, len(src.subscribers))
+		for sub := range src.subscribers {
+			subs = append(subs, sub)
+		}
+		h.mx.Unlock()
+
+		for _, sub := range subs {
+			sub.send(res)
+		}
+	}
+
+	h.mx.Lock()
+	if h.sources[key] == src {
+		delete(h.sources, key)
+	}
+	subs := src.subscribers
+	src.subscribers = map[*subscriber]struct{}{}
+	h.mx.Unlock()
+
+	src.cancel()
+	for sub := range subs {
+		sub.stop()
+		close(sub.ch)
+	}
+}
+
+// send sends the result dropping the oldest buffered result when the
+// subscriber has fallen behind
+func (s *subscriber) send(res *graphql.Result) {
+	for {
+		select {
+		case s.ch <- res:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+// Stats returns the fan-out metrics
+func (h *Hub) Stats() Stats {
+	stats := Stats{}
+	if h == nil {
+		return stats
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	stats.Sources = len(h.sources)
+	for _, src := range h.sources {
+		stats.Subscribers += len(src.subscribers)
+	}
+	return stats
+}
@@ -0,0 +1,170 @@
+package fanout_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/ws/fanout"
+	"github.com/graphql-go/graphql"
+)
+
+type scopeKey struct{}
+
+func TestHub(t *testing.T) {
+	var executions int32
+	events := make(chan interface{})
+	stopped := make(chan struct{})
+
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{
+			"watch": &graphql.Field{
+				Type: graphql.Int,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					atomic.AddInt32(&executions, 1)
+					go func() {
+						<-p.Context.Done()
+						close(stopped)
+					}()
+					return events, nil
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	executor := &document.Executor{Cache: document.NewCache(10)}
+	hub := fanout.New(executor, &fanout.Options{
+		ScopeFunc: func(ctx context.Context, p graphql.Params) (string, bool) {
+			return ctx.Value(scopeKey{}).(string), true
+		},
+	})
+
+	subscribe := func(query string) (chan *graphql.Result, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), scopeKey{}, "tenant"))
+		p := graphql.Params{
+			Schema:         schema,
+			RequestString:  query,
+			VariableValues: map[string]interface{}{"id": "1"},
+			Context:        ctx,
+		}
+
+		d, err := executor.Parse(ctx, &p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hub.Subscribe(p, d), cancel
+	}
+
+	ch1, cancel1 := subscribe(`subscription ($id: String) { watch(id: $id) }`)
+	ch2, cancel2 := subscribe(`subscription ($id: String) {
+		watch(id: $id)
+	}`)
+
+	if stats := hub.Stats(); stats.Sources != 1 || stats.Subscribers != 2 {
+		t.Fatalf("expected one shared source, got %+v", stats)
+	}
+
+	events <- 1
+	for _, ch := range []chan *graphql.Result{ch1, ch2} {
+		res := <-ch
+		if res.Data.(map[string]interface{})["watch"] != 1 {
+			t.Errorf("unexpected result %v", res.Data)
+		}
+	}
+
+	cancel1()
+	cancel2()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the source to be canceled")
+	}
+
+	if n := atomic.LoadInt32(&executions); n != 1 {
+		t.Errorf("expected a single execution, got %d", n)
+	}
+	if stats := hub.Stats(); stats.Sources != 0 {
+		t.Errorf("expected no sources, got %+v", stats)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	var executions int32
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{
+			"watch": &graphql.Field{
+				Type: graphql.Int,
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					atomic.AddInt32(&executions, 1)
+					return make(chan interface{}), nil
+				},
+			},
+		},
+	})
+
+	scoped := func(ctx context.Context, p graphql.Params) (string, bool) {
+		return ctx.Value(scopeKey{}).(string), true
+	}
+
+	tests := []struct {
+		name    string
+		scope   fanout.ScopeFunc
+		scopes  []string
+		sources int
+	}{
+		{name: "same scope", scope: scoped, scopes: []string{"a", "a"}, sources: 1},
+		{name: "different scopes", scope: scoped, scopes: []string{"a", "b"}, sources: 2},
+		{name: "no scope func", scopes: []string{"a", "a"}, sources: 0},
+		{name: "unscoped", scope: fanout.Unscoped, scopes: []string{"a", "b"}, sources: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&executions, 0)
+			executor := &document.Executor{Cache: document.NewCache(10)}
+			hub := fanout.New(executor, &fanout.Options{ScopeFunc: tt.scope})
+
+			for _, scope := range tt.scopes {
+				ctx, cancel := context.WithCancel(context.WithValue(context.Background(), scopeKey{}, scope))
+				t.Cleanup(cancel)
+
+				p := graphql.Params{
+					Schema:        schema,
+					RequestString: `subscription { watch }`,
+					Context:       ctx,
+				}
+				d, err := executor.Parse(ctx, &p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				hub.Subscribe(p, d)
+			}
+
+			if stats := hub.Stats(); stats.Sources != tt.sources {
+				t.Errorf("expected %d sources, got %+v", tt.sources, stats)
+			}
+
+			// unshared subscriptions are executed once per subscriber
+			want := int32(tt.sources)
+			if tt.sources == 0 {
+				want = int32(len(tt.scopes))
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt32(&executions) != want {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %d executions, got %d", want, atomic.LoadInt32(&executions))
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
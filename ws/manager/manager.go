@@ -3,6 +3,7 @@ package manager
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/graphql-go/graphql"
@@ -112,3 +113,19 @@ func (m *Manager) UnsubscribeAll() {
 
 	m.subscriptions = map[string]*Subscription{}
 }
+
+// Subscriptions returns the subscriptions ordered by operation id
+func (m *Manager) Subscriptions() []*Subscription {
+	m.mx.RLock()
+	subs := make([]*Subscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subs = append(subs, sub)
+	}
+	m.mx.RUnlock()
+
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].OperationID < subs[j].OperationID
+	})
+
+	return subs
+}
@@ -2,20 +2,58 @@ package protocol
 
 import (
 	"context"
+	"errors"
+	"net/http"
 
-	"github.com/gorilla/websocket"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
 )
 
+// ErrConnectionClosed is returned when sending on a closed connection
+var ErrConnectionClosed = errors.New("connection closed")
+
+// SubscriptionInfo describes an active operation of a connection
+type SubscriptionInfo struct {
+	ID            string
+	OperationName string
+}
+
+// Context is the connection state exposed to hooks and callbacks
 type Context interface {
 	// ConnectionID returns the connection id
 	ConnectionID() string
 
-	// Context returns the original connection request context
+	// Context returns the connection context, it is derived from the
+	// upgrade request context and canceled when the connection closes
 	Context() context.Context
 
-	// WS returns the websocket
-	WS() *websocket.Conn
+	// Transport returns the connection transport
+	Transport() transport.Transport
+
+	// Request returns the websocket upgrade request
+	Request() *http.Request
+
+	// Subprotocol returns the subprotocol served on the connection
+	Subprotocol() string
+
+	// Send queues a message for the client, ErrConnectionClosed is
+	// returned once the connection has closed
+	Send(msg OperationMessage) error
 
+	// Close closes the connection with a protocol close code and reason
+	Close(code int, reason string)
+
+	// Subscriptions returns the active subscriptions ordered by id
+	Subscriptions() []SubscriptionInfo
+
+	// Unsubscribe cancels an active operation and notifies the client
+	// that it completed. It returns false if the operation was not found
+	Unsubscribe(id string) bool
+
+	// Values returns the connection key/value store
+	Values() *Values
+
+	// Deprecated: messages sent on C are dropped once the connection has
+	// closed without an error, use Send
 	C() chan OperationMessage
 
 	// ConnectionInitReceived
@@ -24,6 +62,10 @@ type Context interface {
 	// Acknowledged
 	Acknowledged() bool
 
-	// ConnectionParams
+	// ConnectionParams returns the connection_init payload, on graphql-ws
+	// an OnConnect hook that returns a map replaces it
 	ConnectionParams() map[string]interface{}
+
+	// SendQueueStats returns the outbound message queue metrics
+	SendQueueStats() SendQueueStats
 }
@@ -0,0 +1,174 @@
+package protocol_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+func TestContext(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{
+			"ticks": testutil.Events(make(chan interface{})),
+		},
+	})
+
+	server, client := transport.Pipe(graphqltransportws.Subprotocol)
+	c, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+		Transport: server,
+		Schema:    &schema,
+		Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	read := func() protocol.OperationMessage {
+		b, err := client.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := protocol.OperationMessage{}
+		json.Unmarshal(b, &msg)
+		return msg
+	}
+
+	client.WriteMessage(ctx, []byte(`{"type":"connection_init"}`))
+	if msg := read(); msg.Type != protocol.MsgConnectionAck {
+		t.Fatalf("expected connection_ack, got %q", msg.Type)
+	}
+
+	client.WriteMessage(ctx, []byte(`{"id":"1","type":"subscribe","payload":{"query":"subscription Ticks { ticks }","operationName":"Ticks"}}`))
+	for len(c.Subscriptions()) == 0 || c.Subscriptions()[0].OperationName == "" {
+		time.Sleep(time.Millisecond)
+	}
+	if subs := c.Subscriptions(); subs[0].ID != "1" || subs[0].OperationName != "Ticks" {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+
+	c.Values().Set("user", "alice")
+	if v, ok := c.Values().Get("user"); !ok || v != "alice" {
+		t.Errorf("expected stored value, got %v", v)
+	}
+
+	if err := c.Send(protocol.OperationMessage{Type: "notify"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg.Type != "notify" {
+		t.Errorf("expected notify, got %q", msg.Type)
+	}
+
+	if !c.Unsubscribe("1") {
+		t.Error("expected the subscription to be found")
+	}
+	if msg := read(); msg.Type != protocol.MsgComplete || msg.ID != "1" {
+		t.Errorf("expected complete for 1, got %+v", msg)
+	}
+
+	c.Close(4403, "forbidden")
+	if _, err := client.ReadMessage(ctx); !transport.IsCloseError(err, 4403) {
+		t.Errorf("expected close 4403, got %v", err)
+	}
+	if err := c.Send(protocol.OperationMessage{Type: "notify"}); err != protocol.ErrConnectionClosed {
+		t.Errorf("expected ErrConnectionClosed, got %v", err)
+	}
+
+	// the deprecated raw channel drops messages once closed
+	select {
+	case c.C() <- protocol.OperationMessage{Type: "notify"}:
+	case <-time.After(testutil.ReadTimeout):
+		t.Error("expected the send on the closed connection to be dropped")
+	}
+}
+
+func TestContextPendingSubscription(t *testing.T) {
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{
+			"ticks": testutil.Events(make(chan interface{})),
+		},
+	})
+
+	// the subscribe hook holds the operation in its placeholder state
+	subscribing, release := make(chan struct{}), make(chan struct{})
+	server, client := transport.Pipe(graphqltransportws.Subprotocol)
+	c, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+		Transport: server,
+		Schema:    &schema,
+		Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+		OnSubscribe: func(c protocol.Context, msg graphqltransportws.SubscribeMessage) (*graphql.Params, gqlerrors.FormattedErrors) {
+			close(subscribing)
+			<-release
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(1000, "done")
+
+	tc := testutil.NewClient(t, client)
+	tc.Send(`{"type":"connection_init"}`)
+	tc.Expect(protocol.MsgConnectionAck)
+	tc.Send(`{"id":"1","type":"subscribe","payload":{"query":"subscription Ticks { ticks }","operationName":"Ticks"}}`)
+
+	<-subscribing
+	if subs := c.Subscriptions(); len(subs) != 0 {
+		t.Errorf("expected the placeholder not to be listed, got %+v", subs)
+	}
+
+	close(release)
+	deadline := time.Now().Add(testutil.ReadTimeout)
+	for len(c.Subscriptions()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the subscription to be listed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if subs := c.Subscriptions(); subs[0].ID != "1" || subs[0].OperationName != "Ticks" {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+}
+
+type connKey struct{}
+type hookKey struct{}
+
+func TestOperationContext(t *testing.T) {
+	conn, closeConn := context.WithCancel(context.WithValue(context.Background(), connKey{}, "conn"))
+	defer closeConn()
+
+	// a hook context that is not derived from the connection keeps the
+	// connection values and ends with the connection
+	hook := context.WithValue(context.Background(), hookKey{}, "hook")
+	ctx, cancel := protocol.OperationContext(conn, hook)
+	defer cancel()
+
+	if ctx.Value(connKey{}) != "conn" || ctx.Value(hookKey{}) != "hook" {
+		t.Fatalf("expected the connection and hook values, got %v %v", ctx.Value(connKey{}), ctx.Value(hookKey{}))
+	}
+
+	closeConn()
+	select {
+	case <-ctx.Done():
+	case <-time.After(testutil.ReadTimeout):
+		t.Fatal("expected the operation to be canceled with the connection")
+	}
+
+	// a nil hook context uses the connection context
+	ctx, cancel = protocol.OperationContext(context.WithValue(context.Background(), connKey{}, "conn"), nil)
+	cancel()
+	if ctx.Value(connKey{}) != "conn" || ctx.Err() == nil {
+		t.Fatalf("expected a canceled connection context, got %v", ctx.Err())
+	}
+}
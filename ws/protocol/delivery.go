@@ -0,0 +1,321 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// DeliveryPolicy controls how the results of a subscription are sent to
+// the client. A zero policy sends every result as soon as it is received
+type DeliveryPolicy struct {
+	// MaxEvents limits delivery to MaxEvents results per Interval,
+	// results over the limit wait for the next interval
+	MaxEvents int
+	Interval  time.Duration
+
+	// Debounce sends the latest result once no result has been received
+	// for the duration
+	Debounce time.Duration
+
+	// Coalesce keeps only the latest result while a result is waiting to
+	// be sent
+	Coalesce bool
+}
+
+// throttled returns true if the policy limits the delivery rate
+func (p *DeliveryPolicy) throttled() bool {
+	return p.MaxEvents > 0 && p.Interval > 0
+}
+
+// Enabled returns true if the policy changes delivery
+func (p *DeliveryPolicy) Enabled() bool {
+	return p != nil && (p.throttled() || p.Debounce > 0 || p.Coalesce)
+}
+
+// DeliveryOutcome describes what happened to a subscription result
+type DeliveryOutcome string
+
+const (
+	// DeliveryReceived is a result received from the source
+	DeliveryReceived DeliveryOutcome = "received"
+	// DeliveryDelivered is a result passed on to the client
+	DeliveryDelivered DeliveryOutcome = "delivered"
+	// DeliveryThrottled is a result delayed by the event limit
+	DeliveryThrottled DeliveryOutcome = "throttled"
+	// DeliveryDebounced is a result replaced within the debounce period
+	DeliveryDebounced DeliveryOutcome = "debounced"
+	// DeliveryCoalesced is a pending result replaced by a newer one
+	DeliveryCoalesced DeliveryOutcome = "coalesced"
+)
+
+// DeliveryPolicyFunc returns the delivery policy of a subscription. The
+// policy passed is the one requested with the @delivery directive, it is
+// nil when the directive is not used. A nil policy sends every result
+type DeliveryPolicyFunc func(c Context, info OperationInfo, policy *DeliveryPolicy) (*DeliveryPolicy, error)
+
+// DeliveryDirective is the @delivery directive a client can use to
+// request a delivery policy for a subscription. It must be added to the
+// schema directives to be used
+var DeliveryDirective = graphql.NewDirective(graphql.DirectiveConfig{
+	Name:        "delivery",
+	Description: "Controls how the results of a subscription are delivered.",
+	Locations:   []string{graphql.DirectiveLocationSubscription},
+	Args: graphql.FieldConfigArgument{
+		"maxEvents": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "Maximum number of results sent per interval.",
+		},
+		"interval": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Interval of maxEvents as a duration, for example 1s.",
+		},
+		"debounce": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Sends the latest result after a quiet period, for example 250ms.",
+		},
+		"coalesce": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Keeps only the latest result waiting to be sent.",
+		},
+	},
+})
+
+// ResolveDeliveryPolicy returns the delivery policy of the operation from
+// the @delivery directive and the policy func
+func ResolveDeliveryPolicy(f DeliveryPolicyFunc, c Context, info OperationInfo) (*DeliveryPolicy, error) {
+	var variables map[string]interface{}
+	if info.Params != nil {
+		variables = info.Params.VariableValues
+	}
+
+	policy, err := directiveDeliveryPolicy(info.Operation, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	if f != nil {
+		return f(c, info, policy)
+	}
+
+	return policy, nil
+}
+
+// directiveDeliveryPolicy reads the policy from the @delivery directive
+func directiveDeliveryPolicy(op *ast.OperationDefinition, variables map[string]interface{}) (*DeliveryPolicy, error) {
+	if op == nil {
+		return nil, nil
+	}
+
+	for _, directive := range op.Directives {
+		if directive.Name == nil || directive.Name.Value != DeliveryDirective.Name {
+			continue
+		}
+
+		policy := &DeliveryPolicy{}
+		for _, arg := range directive.Arguments {
+			value := argumentValue(arg.Value, variables)
+			if value == nil {
+				continue
+			}
+
+			var err error
+			switch arg.Name.Value {
+			case "maxEvents":
+				policy.MaxEvents, err = intValue(value)
+			case "interval":
+				policy.Interval, err = durationValue(value)
+			case "debounce":
+				policy.Debounce, err = durationValue(value)
+			case "coalesce":
+				policy.Coalesce, _ = value.(bool)
+			}
+
+			if err != nil {
+				return nil, gqlerror.New(gqlerror.CodeBadUserInput, fmt.Sprintf("invalid @delivery argument %q: %s", arg.Name.Value, err))
+			}
+		}
+
+		return policy, nil
+	}
+
+	return nil, nil
+}
+
+// argumentValue returns the value of a literal or variable argument
+func argumentValue(value ast.Value, variables map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case *ast.Variable:
+		if v.Name == nil {
+			return nil
+		}
+		return variables[v.Name.Value]
+	case *ast.IntValue:
+		return v.Value
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	}
+	return nil
+}
+
+func intValue(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", value)
+}
+
+func durationValue(value interface{}) (time.Duration, error) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("expected a duration, got %T", value)
+	}
+	return time.ParseDuration(s)
+}
+
+// Deliver applies the policy to the results of a subscription returning
+// the channel the results are delivered on. observe is called with the
+// outcome of each result and may be nil
+func Deliver(ctx context.Context, policy *DeliveryPolicy, in chan *graphql.Result, observe func(outcome DeliveryOutcome)) chan *graphql.Result {
+	if !policy.Enabled() {
+		return in
+	}
+
+	if observe == nil {
+		observe = func(outcome DeliveryOutcome) {}
+	}
+
+	out := make(chan *graphql.Result)
+	go deliver(ctx, *policy, in, out, observe)
+	return out
+}
+
+// deliver sends results from in to out according to the policy
+func deliver(ctx context.Context, policy DeliveryPolicy, in, out chan *graphql.Result, observe func(outcome DeliveryOutcome)) {
+	defer close(out)
+
+	var (
+		pending     *graphql.Result
+		quietUntil  time.Time
+		windowStart time.Time
+		sent        int
+		throttled   bool
+	)
+
+	// debounced results are always replaced by newer ones
+	replace := policy.Coalesce || policy.Debounce > 0
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		// the source has ended and every result was sent
+		if in == nil && pending == nil {
+			return
+		}
+
+		var (
+			send chan *graphql.Result
+			wait time.Duration = -1
+			now                = time.Now()
+		)
+
+		if pending != nil {
+			ready := true
+
+			// wait for the quiet period unless the source has ended
+			if policy.Debounce > 0 && in != nil && now.Before(quietUntil) {
+				ready = false
+				wait = quietUntil.Sub(now)
+			}
+
+			if policy.throttled() {
+				if now.Sub(windowStart) >= policy.Interval {
+					windowStart = now
+					sent = 0
+				}
+
+				if sent >= policy.MaxEvents {
+					ready = false
+					if w := windowStart.Add(policy.Interval).Sub(now); w > wait {
+						wait = w
+					}
+					if !throttled {
+						throttled = true
+						observe(DeliveryThrottled)
+					}
+				}
+			}
+
+			if ready {
+				send = out
+			}
+		}
+
+		// a pending result that cannot be replaced stops reading from
+		// the source until it is sent
+		recv := in
+		if pending != nil && !replace {
+			recv = nil
+		}
+
+		var expired <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			expired = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case res, more := <-recv:
+			if !more {
+				in = nil
+				break
+			}
+
+			observe(DeliveryReceived)
+			if pending != nil {
+				if policy.Debounce > 0 {
+					observe(DeliveryDebounced)
+				} else {
+					observe(DeliveryCoalesced)
+				}
+			}
+
+			pending = res
+			if policy.Debounce > 0 {
+				quietUntil = time.Now().Add(policy.Debounce)
+			}
+
+		case send <- pending:
+			pending = nil
+			sent++
+			throttled = false
+			observe(DeliveryDelivered)
+
+		case <-expired:
+		}
+
+		if expired != nil && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
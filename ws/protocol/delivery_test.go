@@ -0,0 +1,83 @@
+package protocol_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/graphql-go/graphql"
+)
+
+func result(v int) *graphql.Result {
+	return &graphql.Result{Data: v}
+}
+
+func TestDeliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	outcomes := map[protocol.DeliveryOutcome]int{}
+	observe := func(outcome protocol.DeliveryOutcome) {
+		outcomes[outcome]++
+	}
+
+	// coalesce keeps the latest pending result
+	in := make(chan *graphql.Result)
+	out := protocol.Deliver(ctx, &protocol.DeliveryPolicy{Coalesce: true}, in, observe)
+	for i := 1; i <= 3; i++ {
+		in <- result(i)
+	}
+	if res := <-out; res.Data != 3 {
+		t.Errorf("expected the latest result, got %v", res.Data)
+	}
+	close(in)
+	if _, more := <-out; more {
+		t.Error("expected the delivery channel to close")
+	}
+	if outcomes[protocol.DeliveryReceived] != 3 || outcomes[protocol.DeliveryCoalesced] != 2 || outcomes[protocol.DeliveryDelivered] != 1 {
+		t.Errorf("unexpected outcomes %v", outcomes)
+	}
+
+	// debounce sends the latest result after the quiet period
+	in = make(chan *graphql.Result)
+	out = protocol.Deliver(ctx, &protocol.DeliveryPolicy{Debounce: 50 * time.Millisecond}, in, nil)
+	start := time.Now()
+	in <- result(1)
+	in <- result(2)
+	if res := <-out; res.Data != 2 || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected a debounced result, got %v after %s", res.Data, time.Since(start))
+	}
+
+	// throttling delays results over the limit to the next interval
+	in = make(chan *graphql.Result)
+	out = protocol.Deliver(ctx, &protocol.DeliveryPolicy{MaxEvents: 1, Interval: 100 * time.Millisecond}, in, nil)
+	go func() {
+		in <- result(1)
+		in <- result(2)
+	}()
+	start = time.Now()
+	<-out
+	if res := <-out; res.Data != 2 || time.Since(start) < 80*time.Millisecond {
+		t.Errorf("expected a throttled result, got %v after %s", res.Data, time.Since(start))
+	}
+}
+
+func TestDeliveryDirective(t *testing.T) {
+	doc, _ := utils.ParseQuery(`subscription ($interval: String) @delivery(maxEvents: 2, interval: $interval, coalesce: true) { ticks }`)
+	op, _ := utils.GetOperationAST(doc, "")
+
+	policy, err := protocol.ResolveDeliveryPolicy(nil, nil, protocol.OperationInfo{
+		Operation: op,
+		Params:    &graphql.Params{VariableValues: map[string]interface{}{"interval": "1s"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := protocol.DeliveryPolicy{MaxEvents: 2, Interval: time.Second, Coalesce: true}
+	if policy == nil || *policy != want {
+		t.Errorf("expected %+v, got %+v", want, policy)
+	}
+}
@@ -7,9 +7,23 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+	"github.com/bhoriuchi/graphql-go-server/live"
 	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/metrics"
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+	"github.com/bhoriuchi/graphql-go-server/timing"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
+	"github.com/bhoriuchi/graphql-go-server/trusted"
+	"github.com/bhoriuchi/graphql-go-server/utils/ratelimit"
+	"github.com/bhoriuchi/graphql-go-server/ws/fanout"
 	"github.com/bhoriuchi/graphql-go-server/ws/manager"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport/gorillatransport"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
@@ -18,19 +32,26 @@ import (
 )
 
 var (
+	// Deprecated: the close deadline is set on the transport, see
+	// gorillatransport.CloseDeadline
 	CloseDeadlineDuration time.Duration = 100 * time.Millisecond
 )
 
 // ConnectionConfig defines the configuration parameters of a
 // GraphQL WebSocket connection.
 type Config struct {
+	// Transport is the connection transport, WS is wrapped with the
+	// gorilla transport when no transport is set
+	Transport                 transport.Transport
 	WS                        *websocket.Conn
 	Schema                    *graphql.Schema
 	Logger                    *logger.LogWrapper
 	Request                   *http.Request
 	ConnectionInitWaitTimeout time.Duration
-	RootValueFunc             func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
 	ContextValueFunc          func(c protocol.Context, msg protocol.OperationMessage, execArgs graphql.Params) (context.Context, gqlerrors.FormattedErrors)
+	OperationFunc             protocol.OperationFunc
+	DeliveryPolicyFunc        protocol.DeliveryPolicyFunc
+	Hooks                     protocol.Hooks
 	OnConnect                 func(c protocol.Context) (interface{}, error)
 	OnPing                    func(c protocol.Context, payload map[string]interface{})
 	OnPong                    func(c protocol.Context, payload map[string]interface{})
@@ -41,22 +62,82 @@ type Config struct {
 	OnError                   func(c protocol.Context, msg ErrorMessage, errs gqlerrors.FormattedErrors) (gqlerrors.FormattedErrors, error)
 	OnComplete                func(c protocol.Context, msg CompleteMessage) error
 	OnOperation               func(c protocol.Context, msg SubscribeMessage, args graphql.Params, result interface{}) (interface{}, error)
+	MaxSubscriptions          int
+	OperationsPerSecond       float64
+	OperationBurst            int
+	SendQueueSize             int
+	SendQueuePolicy           protocol.SendQueuePolicy
+	CompressionLevel          int
+	CompressionThreshold      int
+	Codec                     codec.Codec
+	Executor                  *document.Executor
+	Tracer                    *tracing.Tracer
+	Metrics                   *metrics.Metrics
+	FieldTiming               *timing.Timing
+	AccessLog                 *accesslog.Logger
+	TrustedDocuments          *trusted.Manifest
+	ErrorFormatter            *gqlerror.Formatter
+	PanicHandler              recovery.Handler
+	SubscriptionIdleTimeout   time.Duration
+	SubscriptionLifetime      time.Duration
+
+	// Sessions enables resumable sessions, a connection whose transport
+	// disconnects keeps its subscriptions until the client resumes it or
+	// the grace period elapses. Messages sent in the meantime are held
+	// in the send queue. The session token is a bearer secret, ResumeFunc
+	// authorizes a client presenting it, without it the token alone
+	// resumes the session
+	Sessions   *protocol.Sessions
+	ResumeFunc protocol.ResumeFunc
+
+	// Fanout shares the execution of identical subscriptions
+	Fanout *fanout.Hub
+
+	// Live executes queries marked with the @live directive
+	Live *live.Registry
+
+	// Release is called once the connection no longer holds its
+	// transport
+	Release func()
+
+	// Acknowledged starts the connection initialised and acknowledged
+	// with the ConnectionParams. It restores connections whose
+	// connection_init was handled by another process
+	Acknowledged     bool
+	ConnectionParams map[string]interface{}
+
+	// Deprecated: use OperationFunc, which sets the root value of
+	// websocket and http operations
+	RootValueFunc func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
 }
 
 // wsConnection defines a connection context
 type wsConnection struct {
 	id                     string
 	ctx                    context.Context
-	ws                     *websocket.Conn
+	cancel                 context.CancelFunc
+	traceCtx               context.Context
+	transport              transport.Transport
 	schema                 *graphql.Schema
 	config                 Config
 	log                    *logger.LogWrapper
-	outgoing               chan protocol.OperationMessage
+	codec                  codec.Codec
+	outgoing               *protocol.SendQueue
+	c                      chan protocol.OperationMessage
+	done                   chan struct{}
 	closed                 bool
 	mgr                    *manager.Manager
+	values                 *protocol.Values
 	connectionInitReceived bool
 	acknowledged           bool
 	connectionParams       map[string]interface{}
+	opLimiter              *ratelimit.TokenBucket
+	untrack                func(code int)
+	sessionToken           string
+	detached               bool
+	attached               *sync.Cond
+	release                func()
+	sessionMx              sync.Mutex
 	initMx                 sync.RWMutex
 	ackMx                  sync.RWMutex
 	closeMx                sync.RWMutex
@@ -66,6 +147,14 @@ type wsConnection struct {
 // the GraphQL WebSocket protocol by managing its internal state and handling
 // the client-server communication.
 func NewConnection(ctx context.Context, config Config) (*wsConnection, error) {
+	if config.Hooks == nil {
+		config.Hooks = protocol.NoopHooks{}
+	}
+
+	// the connection context outlives the upgrade request and is
+	// canceled when the connection is closed
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
 	id := uuid.NewString()
 	l := config.Logger.
 		WithField("connectionId", id).
@@ -74,19 +163,49 @@ func NewConnection(ctx context.Context, config Config) (*wsConnection, error) {
 	c := &wsConnection{
 		id:                     id,
 		ctx:                    ctx,
-		ws:                     config.WS,
+		cancel:                 cancel,
+		transport:              config.Transport,
 		schema:                 config.Schema,
 		config:                 config,
 		log:                    l,
+		codec:                  codec.OrDefault(config.Codec),
 		closed:                 false,
-		outgoing:               make(chan protocol.OperationMessage),
+		outgoing:               protocol.NewSendQueue(config.SendQueueSize, config.SendQueuePolicy),
+		c:                      make(chan protocol.OperationMessage),
+		done:                   make(chan struct{}),
 		connectionInitReceived: false,
 		acknowledged:           false,
 		mgr:                    manager.NewManager(),
+		values:                 protocol.NewValues(),
+		release:                config.Release,
 	}
+	c.attached = sync.NewCond(&c.sessionMx)
 
-	// validate the subprotocol
-	if c.ws.Subprotocol() != Subprotocol {
+	if c.transport == nil {
+		c.transport = gorillatransport.New(config.WS)
+	}
+
+	if config.Acknowledged {
+		c.connectionInitReceived = true
+		c.acknowledged = true
+		c.connectionParams = config.ConnectionParams
+	}
+
+	c.untrack = config.Metrics.TrackConnection(Subprotocol, c.mgr, c.outgoing)
+
+	// propagate the trace context from the upgrade request
+	c.traceCtx = ctx
+	if config.Request != nil {
+		c.traceCtx = config.Tracer.ExtractHTTP(ctx, config.Request.Header)
+	}
+
+	if config.OperationsPerSecond > 0 {
+		c.opLimiter = ratelimit.NewTokenBucket(config.OperationsPerSecond, config.OperationBurst)
+	}
+
+	// validate the subprotocol, clients that did not request one are
+	// served the protocol as the server default
+	if sp := c.transport.Subprotocol(); sp != "" && sp != Subprotocol {
 		err := fmt.Errorf("subprotocol not acceptable")
 		c.log.WithError(err).Errorf("failed to create connection")
 		c.close(SubprotocolNotAcceptable, err.Error())
@@ -95,9 +214,16 @@ func NewConnection(ctx context.Context, config Config) (*wsConnection, error) {
 
 	c.log.Debugf("server accepted graphql subprotocol")
 
+	if cmp, ok := c.transport.(transport.Compressor); ok {
+		if err := cmp.SetCompression(config.CompressionLevel, config.CompressionThreshold); err != nil {
+			c.log.WithError(err).Warnf("failed to set compression level")
+		}
+	}
+
 	// start the read and write loops
 	go c.writeLoop()
-	go c.readLoop()
+	go c.readLoop(c.transport)
+	go c.forwardLoop()
 
 	if config.ConnectionInitWaitTimeout == 0 {
 		config.ConnectionInitWaitTimeout = 3 * time.Second
@@ -122,13 +248,97 @@ func (c *wsConnection) Context() context.Context {
 	return c.ctx
 }
 
-// WS returns the websocket
-func (c *wsConnection) WS() *websocket.Conn {
-	return c.ws
+// Transport returns the connection transport
+func (c *wsConnection) Transport() transport.Transport {
+	c.sessionMx.Lock()
+	defer c.sessionMx.Unlock()
+	return c.transport
+}
+
+// Request returns the websocket upgrade request
+func (c *wsConnection) Request() *http.Request {
+	return c.config.Request
+}
+
+// Subprotocol returns the subprotocol served on the connection
+func (c *wsConnection) Subprotocol() string {
+	return Subprotocol
+}
+
+// Send queues a message for the client
+func (c *wsConnection) Send(msg protocol.OperationMessage) error {
+	return c.sendMessage(msg)
 }
 
+// Close closes the connection
+func (c *wsConnection) Close(code int, reason string) {
+	c.close(CloseCode(code), reason)
+}
+
+// Subscriptions returns the active subscriptions, the placeholders of
+// operations that are still being set up are not listed
+func (c *wsConnection) Subscriptions() []protocol.SubscriptionInfo {
+	infos := []protocol.SubscriptionInfo{}
+	for _, sub := range c.mgr.Subscriptions() {
+		if !sub.IsSub {
+			continue
+		}
+		infos = append(infos, protocol.SubscriptionInfo{
+			ID:            sub.OperationID,
+			OperationName: sub.OperationName,
+		})
+	}
+	return infos
+}
+
+// Unsubscribe cancels an active operation and completes it
+func (c *wsConnection) Unsubscribe(id string) bool {
+	if sub := c.mgr.Unsubscribe(id); sub == nil {
+		return false
+	}
+
+	if err := c.sendComplete(id, true); err != nil {
+		c.log.WithError(err).WithField("subscriptionId", id).Errorf("failed to send complete")
+	}
+	return true
+}
+
+// Values returns the connection key/value store
+func (c *wsConnection) Values() *protocol.Values {
+	return c.values
+}
+
+// C returns the raw send channel. Once the connection is closed it
+// returns a buffered channel that nothing reads so the send is dropped
 func (c *wsConnection) C() chan protocol.OperationMessage {
-	return c.outgoing
+	if c.isClosed() {
+		return make(chan protocol.OperationMessage, 1)
+	}
+	return c.c
+}
+
+// SendQueueStats returns the outbound message queue metrics
+func (c *wsConnection) SendQueueStats() protocol.SendQueueStats {
+	return c.outgoing.Stats()
+}
+
+// forwardLoop forwards messages sent on the raw channel to the send queue
+func (c *wsConnection) forwardLoop() {
+	for {
+		select {
+		case <-c.done:
+			// drop the messages of senders blocked on the raw channel
+			for {
+				select {
+				case <-c.c:
+				default:
+					return
+				}
+			}
+		case msg := <-c.c:
+			c.sendMessage(msg)
+		}
+	}
 }
 
 // ConnectionInitReceived
@@ -151,14 +361,11 @@ func (c *wsConnection) ConnectionParams() map[string]interface{} {
 }
 
 func (c *wsConnection) writeLoop() {
-	// Close the WebSocket connection when leaving the write loop;
-	// this ensures the read loop is also terminated and the connection
-	// closed cleanly
-	defer c.ws.Close()
+	defer recovery.Recover(c.ctx, c.config.PanicHandler, c.closeOnPanic)
 
 	for {
-		msg, ok := <-c.outgoing
-		// Close the write loop when the outgoing messages channel is closed;
+		msg, ok := c.outgoing.Pop()
+		// Close the write loop when the outgoing messages queue is closed;
 		// this will close the connection
 		if !ok {
 			return
@@ -168,35 +375,74 @@ func (c *wsConnection) writeLoop() {
 			return
 		}
 
-		// conn.logger.Debugf("send message: %s", msg.String())
-		c.ws.SetWriteDeadline(time.Now().Add(WriteTimeout))
-
 		// Send the message to the client; if this times out, the WebSocket
 		// connection will be corrupt, hence we need to close the write loop
 		// and the connection immediately
-		if err := c.ws.WriteJSON(msg); err != nil {
+		if err := c.writeMessage(msg); err != nil {
 			c.log.WithError(err).Warnf("sending message failed")
+			c.close(InternalServerError, err.Error())
 			return
 		}
+
+		c.config.Metrics.MessageSent(Subprotocol, msg.Type)
 	}
 }
 
-func (c *wsConnection) readLoop() {
-	// Close the WebSocket connection when leaving the read loop
-	defer c.ws.Close()
+// writeMessage writes the message to the transport. A message that
+// fails to write to a detached session is written once it is resumed
+func (c *wsConnection) writeMessage(msg protocol.OperationMessage) error {
+	b, err := c.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
 
 	for {
+		t := c.attachedTransport()
+		if c.isClosed() {
+			return protocol.ErrConnectionClosed
+		}
+
+		ctx, cancel := context.WithTimeout(c.ctx, WriteTimeout)
+		err := t.WriteMessage(ctx, b)
+		cancel()
+
+		if err == nil || !c.detach(t) {
+			return err
+		}
+	}
+}
+
+// readMessage reads the next message from the transport into v
+func (c *wsConnection) readMessage(t transport.Transport, v interface{}) error {
+	b, err := t.ReadMessage(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.codec.Unmarshal(b, v)
+}
+
+func (c *wsConnection) readLoop(t transport.Transport) {
+	defer recovery.Recover(c.ctx, c.config.PanicHandler, c.closeOnPanic)
+
+	// the loop ends once the transport is handed to a resumed session
+	for !c.isClosed() {
 
 		msg := new(RawMessage)
-		err := c.ws.ReadJSON(&msg)
+		err := c.readMessage(t, msg)
 
 		if err != nil {
 			// look for a normal closure and exit
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || c.isClosed() {
+			if transport.IsCloseError(err, transport.CloseNormalClosure) || c.isClosed() {
 				c.close(NormalClosure, "Client requested normal closure: close error")
 				break
 			}
 
+			// keep the session for the client to resume
+			if c.detach(t) {
+				break
+			}
+
 			c.log.WithError(err).Errorf("graphql-transport-ws: force closing connection")
 			c.close(BadRequest, err.Error())
 			break
@@ -209,6 +455,8 @@ func (c *wsConnection) readLoop() {
 			break
 		}
 
+		c.config.Metrics.MessageReceived(Subprotocol, msgType)
+
 		switch msgType {
 
 		case protocol.MsgConnectionInit:
@@ -244,6 +492,8 @@ func (c *wsConnection) readLoop() {
 
 // send error sends an error
 func (c *wsConnection) sendError(id string, errs gqlerrors.FormattedErrors) error {
+	errs = c.config.ErrorFormatter.FormatErrors(errs)
+
 	if c.config.OnError != nil {
 		maybeErrors, err := c.config.OnError(c, ErrorMessage{
 			ID:      id,
@@ -260,6 +510,10 @@ func (c *wsConnection) sendError(id string, errs gqlerrors.FormattedErrors) erro
 		}
 	}
 
+	if maybeErrors := c.config.Hooks.OnError(c, id, errs); maybeErrors != nil {
+		errs = maybeErrors
+	}
+
 	c.sendMessage(protocol.OperationMessage{
 		ID:      id,
 		Type:    protocol.MsgError,
@@ -269,49 +523,61 @@ func (c *wsConnection) sendError(id string, errs gqlerrors.FormattedErrors) erro
 	return nil
 }
 
-// Send sends a message
-func (c *wsConnection) sendMessage(msg protocol.OperationMessage) {
-	if !c.isClosed() {
-		c.outgoing <- msg
+// sendMessage queues a message for the client
+func (c *wsConnection) sendMessage(msg protocol.OperationMessage) error {
+	if c.isClosed() {
+		return protocol.ErrConnectionClosed
+	}
+
+	// the close is performed asynchronously since the sender may be
+	// holding locks that are required to close the connection
+	err := c.outgoing.Push(msg)
+	switch err {
+	case protocol.ErrSendQueueFull:
+		c.log.WithError(err).Warnf("disconnecting slow consumer")
+		go c.close(TryAgainLater, "slow consumer")
+	case protocol.ErrSendQueueClosed:
+		return protocol.ErrConnectionClosed
 	}
+
+	return err
 }
 
 // close closes the socket with a control message
 func (c *wsConnection) close(code CloseCode, msg string) {
-	// Close the write loop by closing the outgoing messages channels
+	// mark as closed and stop outbound messages, the rest of the close
+	// runs without the lock so hooks can call Send, Unsubscribe or Close
 	c.closeMx.Lock()
-	defer c.closeMx.Unlock()
-
 	if c.closed {
+		c.closeMx.Unlock()
 		return
 	}
-
-	// mark as closed and stop outbound messages
 	c.closed = true
-	close(c.outgoing)
-
-	// close the websocket connection
-	closeMsg := websocket.FormatCloseMessage(int(code), msg)
-	deadline := time.Now().Add(CloseDeadlineDuration)
-
-	// close the connection
-	closedWS := true
-	if err := c.ws.WriteControl(websocket.CloseMessage, closeMsg, deadline); err != nil {
-		if err != websocket.ErrCloseSent {
-			c.log.WithError(err).Errorf("failed to write close control message to websocket, trying force close")
-			if err := c.ws.Close(); err != nil {
-				c.log.WithError(err).Errorf("failed to close websocket")
-				closedWS = false
-			}
+	close(c.done)
+	c.outgoing.Close()
+	c.closeMx.Unlock()
+
+	// close the transport unless the session is detached from it
+	c.sessionMx.Lock()
+	if !c.detached {
+		if err := c.transport.Close(int(code), msg); err != nil {
+			c.log.WithError(err).Errorf("failed to close transport")
+		} else {
+			c.log.WithField("code", code).Infof("CLOSED connection with %q", msg)
 		}
 	}
-
-	if closedWS {
-		c.log.WithField("code", code).Infof("CLOSED connection with %q", msg)
+	c.detached = false
+	c.attached.Broadcast()
+	if c.release != nil {
+		c.release()
+		c.release = nil
 	}
+	c.sessionMx.Unlock()
 
 	// clean up subscriptions
 	c.mgr.UnsubscribeAll()
+	c.cancel()
+	c.untrack(int(code))
 
 	// onDisconnect hook
 	if c.Acknowledged() && c.config.OnDisconnect != nil {
@@ -322,6 +588,7 @@ func (c *wsConnection) close(code CloseCode, msg string) {
 	if c.config.OnClose != nil {
 		c.config.OnClose(c, code, msg)
 	}
+	c.config.Hooks.OnClose(c, int(code), msg)
 }
 
 // sendComplete sends a complete message
@@ -354,6 +621,8 @@ func (c *wsConnection) sendNext(msg NextMessage, args graphql.Params, result *gr
 		maybeResult *protocol.ExecutionResult
 	)
 
+	msg.Payload.Errors = c.config.ErrorFormatter.FormatErrors(msg.Payload.Errors)
+
 	if c.config.OnNext != nil {
 		maybeResult, err = c.config.OnNext(c, msg, args, result)
 
@@ -370,6 +639,15 @@ func (c *wsConnection) sendNext(msg NextMessage, args graphql.Params, result *gr
 		}
 	}
 
+	maybeResult, err = c.config.Hooks.OnResult(c, msg.ID, &msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	if maybeResult != nil {
+		msg.Payload = *maybeResult
+	}
+
 	c.sendMessage(protocol.OperationMessage{
 		ID:      msg.ID,
 		Type:    msg.Type,
@@ -379,6 +657,13 @@ func (c *wsConnection) sendNext(msg NextMessage, args graphql.Params, result *gr
 	return nil
 }
 
+// closeOnPanic closes the connection after a panic has left its state
+// unrecoverable
+func (c *wsConnection) closeOnPanic(err *recovery.Error) {
+	c.log.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic, closing connection")
+	c.close(InternalServerError, "internal server error")
+}
+
 // isClosed returns true if the connection is closed
 func (c *wsConnection) isClosed() bool {
 	c.closeMx.RLock()
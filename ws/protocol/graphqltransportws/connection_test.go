@@ -0,0 +1,277 @@
+package graphqltransportws_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/graphql-go/graphql"
+)
+
+// completions records the summaries of completed operations
+type completions struct {
+	protocol.NoopHooks
+	c chan protocol.OperationSummary
+}
+
+func (h *completions) OnOperationComplete(c protocol.Context, summary protocol.OperationSummary) {
+	h.c <- summary
+}
+
+// failingTransport is a transport that fails every write
+type failingTransport struct {
+	*transport.PipeConn
+}
+
+func (t failingTransport) WriteMessage(ctx context.Context, b []byte) error {
+	return errors.New("write failed")
+}
+
+// connect starts a connection over a pipe and returns the client end
+func connect(t *testing.T, config graphqltransportws.Config) *testutil.Client {
+	t.Helper()
+
+	server, client := transport.Pipe(graphqltransportws.Subprotocol)
+	config.Transport = server
+	if config.Logger == nil {
+		config.Logger = logger.NewLogWrapper(logger.NoopLogFunc, nil)
+	}
+
+	if _, err := graphqltransportws.NewConnection(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	c := testutil.NewClient(t, client)
+	t.Cleanup(func() { c.Close(transport.CloseNormalClosure) })
+	return c
+}
+
+// counter creates a schema with a count subscription resolving the
+// events sent on the returned channel
+func counter(t *testing.T) (chan interface{}, *graphql.Schema) {
+	events := make(chan interface{})
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"count": testutil.Events(events)},
+	})
+	return events, &schema
+}
+
+func TestQuery(t *testing.T) {
+	schema := testutil.Hello(t)
+	client := connect(t, graphqltransportws.Config{Schema: &schema})
+
+	client.Send(`{"type":"connection_init"}`)
+	client.Expect(protocol.MsgConnectionAck)
+
+	client.Send(`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`)
+	next := client.Expect(protocol.MsgNext)
+	if next.ID != "1" || testutil.Data(next)["hello"] != "world" {
+		t.Fatalf("unexpected next message %+v", next)
+	}
+	if complete := client.Expect(protocol.MsgComplete); complete.ID != "1" {
+		t.Fatalf("unexpected complete message %+v", complete)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	t.Run("server complete", func(t *testing.T) {
+		events, schema := counter(t)
+		client := connect(t, graphqltransportws.Config{Schema: schema})
+		client.Send(`{"type":"connection_init"}`)
+		client.Expect(protocol.MsgConnectionAck)
+		client.Send(`{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`)
+
+		for i := 1; i <= 2; i++ {
+			events <- i
+			next := client.Expect(protocol.MsgNext)
+			if count := testutil.Data(next)["count"]; count != float64(i) {
+				t.Fatalf("expected count %d, got %v", i, count)
+			}
+		}
+
+		// the source closing completes the operation
+		close(events)
+		client.Expect(protocol.MsgComplete)
+	})
+
+	t.Run("client complete", func(t *testing.T) {
+		events, schema := counter(t)
+		hooks := &completions{c: make(chan protocol.OperationSummary, 1)}
+		client := connect(t, graphqltransportws.Config{Schema: schema, Hooks: hooks})
+		client.Send(`{"type":"connection_init"}`)
+		client.Expect(protocol.MsgConnectionAck)
+		client.Send(`{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`)
+
+		events <- 1
+		client.Expect(protocol.MsgNext)
+		client.Send(`{"id":"1","type":"complete"}`)
+
+		select {
+		case summary := <-hooks.c:
+			if summary.ID != "1" || summary.Status != protocol.OperationCanceled {
+				t.Fatalf("expected a canceled operation, got %+v", summary)
+			}
+		case <-time.After(testutil.ReadTimeout):
+			t.Fatal("operation did not complete")
+		}
+	})
+
+	t.Run("idle timeout with delivery policy", func(t *testing.T) {
+		events, schema := counter(t)
+		client := connect(t, graphqltransportws.Config{
+			Schema:                  schema,
+			SubscriptionIdleTimeout: 50 * time.Millisecond,
+			DeliveryPolicyFunc: func(c protocol.Context, info protocol.OperationInfo, policy *protocol.DeliveryPolicy) (*protocol.DeliveryPolicy, error) {
+				return &protocol.DeliveryPolicy{MaxEvents: 1, Interval: time.Hour, Coalesce: true}, nil
+			},
+		})
+		client.Send(`{"type":"connection_init"}`)
+		client.Expect(protocol.MsgConnectionAck)
+		client.Send(`{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`)
+
+		// throttled events keep the subscription from idling
+		for i := 1; i <= 10; i++ {
+			select {
+			case events <- i:
+			case <-time.After(testutil.ReadTimeout):
+				t.Fatalf("event %d was not received", i)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if next := client.Expect(protocol.MsgNext); testutil.Data(next)["count"] != float64(1) {
+			t.Fatalf("expected the first count, got %+v", next)
+		}
+
+		// the subscription idles once the source stops
+		if msg := client.Expect(protocol.MsgError); msg.ID != "1" {
+			t.Fatalf("unexpected error message %+v", msg)
+		}
+	})
+}
+
+func TestCloseCodes(t *testing.T) {
+	_, schema := counter(t)
+
+	tests := []struct {
+		name     string
+		config   graphqltransportws.Config
+		messages []string
+		code     graphqltransportws.CloseCode
+	}{
+		{
+			name:     "subscribe before init",
+			messages: []string{`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`},
+			code:     graphqltransportws.Unauthorized,
+		},
+		{
+			name:     "duplicate init",
+			messages: []string{`{"type":"connection_init"}`, `{"type":"connection_init"}`},
+			code:     graphqltransportws.TooManyInitialisationRequests,
+		},
+		{
+			name: "duplicate operation id",
+			messages: []string{
+				`{"type":"connection_init"}`,
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`,
+				`{"id":"1","type":"subscribe","payload":{"query":"subscription { count }"}}`,
+			},
+			code: graphqltransportws.SubscriberAlreadyExists,
+		},
+		{
+			name:     "unknown message type",
+			messages: []string{`{"type":"start"}`},
+			code:     graphqltransportws.BadRequest,
+		},
+		{
+			name:   "init timeout",
+			config: graphqltransportws.Config{ConnectionInitWaitTimeout: 10 * time.Millisecond},
+			code:   graphqltransportws.ConnectionInitialisationTimeout,
+		},
+		{
+			name: "connection rejected",
+			config: graphqltransportws.Config{
+				OnConnect: func(c protocol.Context) (interface{}, error) {
+					return false, nil
+				},
+			},
+			messages: []string{`{"type":"connection_init"}`},
+			code:     graphqltransportws.Forbidden,
+		},
+		{
+			name:     "terminate",
+			messages: []string{`{"type":"connection_terminate"}`},
+			code:     graphqltransportws.NormalClosure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Schema = schema
+			client := connect(t, tt.config)
+			for _, msg := range tt.messages {
+				client.Send(msg)
+			}
+			client.ExpectClose(int(tt.code))
+		})
+	}
+
+	t.Run("write failure", func(t *testing.T) {
+		server, conn := transport.Pipe(graphqltransportws.Subprotocol)
+		if _, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+			Transport: failingTransport{server},
+			Schema:    schema,
+			Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		client := testutil.NewClient(t, conn)
+		client.Send(`{"type":"connection_init"}`)
+		client.ExpectClose(int(graphqltransportws.InternalServerError))
+	})
+}
+
+func TestCloseHooks(t *testing.T) {
+	schema := testutil.Hello(t)
+	errs := make(chan error, 2)
+
+	// the hooks run after the connection is marked closed, calling back
+	// into it must not block
+	hook := func(c protocol.Context) {
+		errs <- c.Send(protocol.OperationMessage{Type: protocol.MsgPing})
+		c.Unsubscribe("1")
+		c.Close(int(graphqltransportws.InternalServerError), "closed by hook")
+	}
+
+	client := connect(t, graphqltransportws.Config{
+		Schema: &schema,
+		OnDisconnect: func(c protocol.Context, code graphqltransportws.CloseCode, reason string) {
+			hook(c)
+		},
+		OnClose: func(c protocol.Context, code graphqltransportws.CloseCode, reason string) {
+			hook(c)
+		},
+	})
+
+	client.Send(`{"type":"connection_init"}`)
+	client.Expect(protocol.MsgConnectionAck)
+	client.Send(`{"type":"connection_terminate"}`)
+	client.ExpectClose(int(graphqltransportws.NormalClosure))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != protocol.ErrConnectionClosed {
+				t.Fatalf("expected ErrConnectionClosed, got %v", err)
+			}
+		case <-time.After(testutil.ReadTimeout):
+			t.Fatal("close hook blocked")
+		}
+	}
+}
@@ -30,6 +30,7 @@ func (c *wsConnection) handleConnectionInit(msg *RawMessage) {
 		payload, err := msg.RecordPayload()
 		if err == nil && payload != nil {
 			c.connectionParams = payload
+			c.traceCtx = c.config.Tracer.ExtractPayload(c.traceCtx, payload)
 		}
 	}
 
@@ -55,9 +56,33 @@ func (c *wsConnection) handleConnectionInit(msg *RawMessage) {
 		payload = v
 	}
 
+	// the session token is added to the ack payload, which must be an
+	// object or encode as one
+	var record map[string]interface{}
+	if c.config.Sessions != nil {
+		var err error
+		if record, err = sessionRecord(payload); err != nil {
+			c.log.WithError(err).Errorf("failed to add the session token")
+			c.close(InternalServerError, err.Error())
+			return
+		}
+	}
+
+	// resume the detached session named by the session token
+	if c.resume(record) {
+		return
+	}
+
 	c.ackMx.Lock()
 	defer c.ackMx.Unlock()
 
+	// issue a token the client can resume the session with
+	if c.config.Sessions != nil {
+		token := protocol.NewSessionToken()
+		payload = sessionPayload(record, token, false)
+		c.sessionToken = token
+	}
+
 	c.sendMessage(protocol.OperationMessage{
 		Type:    protocol.MsgConnectionAck,
 		Payload: payload,
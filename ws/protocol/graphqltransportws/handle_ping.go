@@ -12,6 +12,11 @@ func (c *wsConnection) handlePing(msg *RawMessage) {
 		payload, _ = msg.RecordPayload()
 	}
 
+	c.config.Hooks.OnKeepAlive(c, protocol.KeepAliveEvent{
+		Type:    protocol.MsgPing,
+		Payload: payload,
+	})
+
 	if c.config.OnPing != nil {
 		c.config.OnPing(c, payload)
 		return
@@ -22,4 +27,9 @@ func (c *wsConnection) handlePing(msg *RawMessage) {
 		Type:    protocol.MsgPong,
 		Payload: payload,
 	})
+	c.config.Hooks.OnKeepAlive(c, protocol.KeepAliveEvent{
+		Type:    protocol.MsgPong,
+		Sent:    true,
+		Payload: payload,
+	})
 }
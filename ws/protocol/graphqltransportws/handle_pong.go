@@ -1,5 +1,7 @@
 package graphqltransportws
 
+import "github.com/bhoriuchi/graphql-go-server/ws/protocol"
+
 // handlePong handles a pong message
 func (c *wsConnection) handlePong(msg *RawMessage) {
 	c.log.Tracef("received PONG message")
@@ -10,6 +12,11 @@ func (c *wsConnection) handlePong(msg *RawMessage) {
 		payload, _ = msg.RecordPayload()
 	}
 
+	c.config.Hooks.OnKeepAlive(c, protocol.KeepAliveEvent{
+		Type:    protocol.MsgPong,
+		Payload: payload,
+	})
+
 	if c.config.OnPong != nil {
 		c.config.OnPong(c, payload)
 	}
@@ -3,8 +3,15 @@ package graphqltransportws
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
+	"github.com/bhoriuchi/graphql-go-server/live"
 	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
 	"github.com/bhoriuchi/graphql-go-server/utils"
 	"github.com/bhoriuchi/graphql-go-server/ws/manager"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
@@ -20,6 +27,7 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 		maybeExecArgs   *graphql.Params
 		operationResult interface{}
 		formattedErrs   gqlerrors.FormattedErrors
+		failOperation   func()
 	)
 
 	// validate the message and create a structured one
@@ -33,6 +41,17 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 
 	subLog := c.log.WithField("subscriptionId", id)
 	subLog.Tracef("received SUBSCRIBE message")
+
+	// a panic fails the operation rather than the connection
+	defer recovery.Recover(c.ctx, c.config.PanicHandler, func(err *recovery.Error) {
+		subLog.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic in subscribe operation")
+		if failOperation != nil {
+			failOperation()
+		}
+		c.sendError(id, utils.GQLErrors(recovery.ErrInternal))
+		c.mgr.Unsubscribe(id)
+	})
+
 	payload, err := msg.SubscribePayload()
 	if err != nil {
 		subLog.WithError(err).Errorf("invalid subscribe message payload")
@@ -53,6 +72,24 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 		return
 	}
 
+	// enforce the operation rate limit
+	if c.opLimiter != nil && !c.opLimiter.Allow() {
+		err := gqlerror.New(gqlerror.CodeRateLimited, "operation rate limit exceeded")
+		subLog.WithError(err).Warnf("subscribe operation rejected")
+		c.sendError(id, utils.GQLErrors(err))
+		return
+	}
+
+	// resolve the trusted document
+	query, err := c.config.TrustedDocuments.Resolve(payload.Query, payload.DocumentID, payload.Extensions)
+	if err != nil {
+		subLog.WithError(err).Warnf("untrusted document rejected")
+		c.sendError(id, utils.GQLErrors(err))
+		return
+	}
+	payload.Query = query
+	subMsg.Payload.Query = query
+
 	// attempt to subscribe a placeholder
 	// if the subscription exists, close the connection
 	if err := c.mgr.Subscribe(&manager.Subscription{OperationID: id}); err != nil {
@@ -63,6 +100,15 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 	}
 	subLog.Tracef("subscription count increased to: %d", c.mgr.SubscriptionCount())
 
+	// enforce the concurrent subscription limit, the placeholder is included in the count
+	if max := c.config.MaxSubscriptions; max > 0 && c.mgr.SubscriptionCount() > max {
+		err := gqlerror.New(gqlerror.CodeRateLimited, fmt.Sprintf("maximum of %d concurrent subscriptions exceeded", max))
+		subLog.WithError(err).Warnf("subscribe operation rejected")
+		c.sendError(id, utils.GQLErrors(err))
+		c.mgr.Unsubscribe(id)
+		return
+	}
+
 	if c.config.OnSubscribe != nil {
 		maybeExecArgs, formattedErrs = c.config.OnSubscribe(c, subMsg)
 	}
@@ -105,25 +151,6 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 		subName = "Unnamed Subscription"
 	}
 
-	// get the operation
-	document, err := utils.ParseQuery(execArgs.RequestString)
-	if err != nil {
-		subLog.WithError(err).Errorf("failed to parse query")
-		err = fmt.Errorf("failed to parse query: %s", err)
-		c.sendError(id, utils.GQLErrors(err))
-		c.mgr.Unsubscribe(id)
-		return
-	}
-
-	operation, err := utils.GetOperationAST(document, execArgs.OperationName)
-	if err != nil {
-		subLog.WithError(err).Errorf("failed to identify operation")
-		err = fmt.Errorf("failed to identify operation: %s", err)
-		c.sendError(id, utils.GQLErrors(err))
-		c.mgr.Unsubscribe(id)
-		return
-	}
-
 	// add context
 	if execArgs.Context == nil {
 		if c.config.ContextValueFunc != nil {
@@ -133,45 +160,175 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 				Payload: subMsg.Payload,
 			}, *execArgs)
 
-			if formattedErrs != nil {
-				subLog.WithError(err).Errorf("failed to identify operation")
-				err = fmt.Errorf("failed to identify operation: %s", err)
-				c.sendError(id, utils.GQLErrors(err))
+			if len(formattedErrs) > 0 {
+				subLog.WithError(formattedErrs[0]).Errorf("contextValueFunc failed")
+				c.sendError(id, formattedErrs)
 				c.mgr.Unsubscribe(id)
 				return
 			}
 
 		} else {
-			execArgs.Context = context.Background()
+			execArgs.Context = c.ctx
+		}
+	}
+
+	// create a cancelable context that ends with the connection
+	ctx, cancelFunc := protocol.OperationContext(c.ctx, execArgs.Context)
+
+	// start the operation span and metrics before parsing so that parse
+	// failures are recorded
+	ctx, span := c.config.Tracer.StartOperation(
+		c.config.Tracer.WithParent(ctx, c.config.Tracer.ExtractExtensions(c.traceCtx, payload.Extensions)),
+		"graphql.ws.operation",
+		tracing.OperationIDKey.String(id),
+		tracing.ConnectionIDKey.String(c.id),
+		tracing.SubprotocolKey.String(Subprotocol),
+	)
+	ctx, op := c.config.Metrics.StartOperation(ctx, Subprotocol)
+
+	var remoteAddr string
+	if c.config.Request != nil {
+		remoteAddr = c.config.Request.RemoteAddr
+	}
+	ctx, access := c.config.AccessLog.StartOperation(ctx, accesslog.Entry{
+		Transport:    Subprotocol,
+		RemoteAddr:   remoteAddr,
+		ConnectionID: c.id,
+		OperationID:  id,
+	})
+
+	// end the operation span and metrics and notify the hooks
+	var operation *ast.OperationDefinition
+	start := time.Now()
+	var endOnce sync.Once
+	endOperation := func(status protocol.OperationStatus, errorCount int) {
+		endOnce.Do(func() {
+			tracing.EndOperation(span, errorCount)
+			op.End(errorCount)
+			access.End(errorCount)
+
+			summary := protocol.OperationSummary{
+				ID:            id,
+				OperationName: execArgs.OperationName,
+				Status:        status,
+				ErrorCount:    errorCount,
+				Duration:      time.Since(start),
+			}
+			if operation != nil {
+				summary.OperationType = operation.Operation
+			}
+			c.config.Hooks.OnOperationComplete(c, summary)
+		})
+	}
+
+	// a panic from here on fails the started operation
+	failOperation = func() {
+		cancelFunc()
+		endOperation(protocol.OperationFailed, 1)
+	}
+
+	// reject the operation before it is executed
+	reject := func(err error, msg string, errs gqlerrors.FormattedErrors) {
+		cancelFunc()
+		endOperation(protocol.OperationFailed, 1)
+		subLog.WithError(err).Errorf(msg)
+		c.sendError(id, errs)
+		c.mgr.Unsubscribe(id)
+	}
+
+	// get the operation
+	execArgs.Context = ctx
+	doc, err := c.config.Executor.Parse(ctx, execArgs)
+	if err != nil {
+		err = gqlerror.New(gqlerror.CodeGraphQLParseFailed, fmt.Sprintf("failed to parse query: %s", err))
+		reject(err, "failed to parse query", utils.GQLErrors(err))
+		return
+	}
+
+	operation, err = utils.GetOperationAST(doc.AST, execArgs.OperationName)
+	if err != nil {
+		err = gqlerror.New(gqlerror.CodeBadUserInput, fmt.Sprintf("failed to identify operation: %s", err))
+		reject(err, "failed to identify operation", utils.GQLErrors(err))
+		return
+	}
+
+	span.SetAttributes(tracing.OperationAttributes(operation)...)
+	op.SetOperation(operation)
+
+	// record field timings for queries and mutations that opted in
+	if operation.Operation != ast.OperationTypeSubscription {
+		ctx = c.config.FieldTiming.OptIn(ctx, c.config.Request, c.ConnectionParams(), payload.Extensions)
+	}
+
+	// apply the operation hook shared with http
+	var rootValue map[string]interface{}
+	if c.config.OperationFunc != nil {
+		opCtx, root, err := c.config.OperationFunc(ctx, protocol.OperationInfo{
+			Request:   c.config.Request,
+			Conn:      c,
+			ID:        id,
+			Operation: operation,
+			Params:    execArgs,
+		})
+		if err != nil {
+			reject(err, "operation hook failed", utils.GQLErrors(err))
+			return
+		}
+		if opCtx != nil {
+			ctx = opCtx
 		}
+		rootValue = root
 	}
 
-	// create a cancelable context
-	ctx, cancelFunc := context.WithCancel(execArgs.Context)
+	// resolve the delivery policy of subscriptions
+	var delivery *protocol.DeliveryPolicy
+	if operation.Operation == ast.OperationTypeSubscription {
+		delivery, err = protocol.ResolveDeliveryPolicy(c.config.DeliveryPolicyFunc, c, protocol.OperationInfo{
+			Request:   c.config.Request,
+			Conn:      c,
+			ID:        id,
+			Operation: operation,
+			Params:    execArgs,
+		})
+		if err != nil {
+			reject(err, "failed to resolve delivery policy", utils.GQLErrors(err))
+			return
+		}
+	}
 	execArgs.Context = ctx
 
 	// set the root value
 	if execArgs.RootObject == nil {
-		if c.config.RootValueFunc != nil {
+		if rootValue != nil {
+			execArgs.RootObject = rootValue
+		} else if c.config.RootValueFunc != nil {
 			execArgs.RootObject = c.config.RootValueFunc(execArgs.Context, c.config.Request, operation)
 		}
 	}
 
-	if execArgs.RootObject != nil {
+	if execArgs.RootObject == nil {
 		execArgs.RootObject = map[string]interface{}{}
 	}
 
 	// perform the appropriate operation
-	if operation.Operation == ast.OperationTypeSubscription {
-		operationResult = graphql.Subscribe(*execArgs)
-	} else {
-		operationResult = graphql.Do(*execArgs)
+	switch {
+	case c.config.Live != nil && live.IsLive(operation):
+		// live queries are re-executed when their resources are invalidated
+		operationResult = c.config.Live.Watch(*execArgs, doc)
+	case operation.Operation != ast.OperationTypeSubscription:
+		operationResult = c.config.Executor.Execute(*execArgs, doc)
+	case c.config.Fanout != nil:
+		// identical subscriptions share a single execution
+		operationResult = c.config.Fanout.Subscribe(*execArgs, doc)
+	default:
+		operationResult = c.config.Executor.ExecuteSubscription(*execArgs, doc)
 	}
 
 	if c.config.OnOperation != nil {
 		maybeResult, err := c.config.OnOperation(c, subMsg, *execArgs, operationResult)
 		if err != nil {
 			cancelFunc()
+			endOperation(protocol.OperationFailed, 1)
 			subLog.WithError(err).Errorf("onOperation hook failed")
 			err = fmt.Errorf("onOperation hook failed: %s", err)
 			c.sendError(id, utils.GQLErrors(err))
@@ -192,12 +349,25 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 		// if the subscription has already been unsubscribed, exit silently
 		if !c.mgr.HasSubscription(id) {
 			cancelFunc()
+			endOperation(protocol.OperationCanceled, 0)
 			if err := c.sendComplete(id, false); err != nil {
 				subLog.WithError(err).Errorf("failed to complete operation")
 			}
 			return
 		}
 
+		// enforce the idle timeout and lifetime, the idle timeout is reset
+		// by results received from the source rather than delivered ones
+		timer := protocol.NewSubscriptionTimer(c.config.SubscriptionIdleTimeout, c.config.SubscriptionLifetime)
+
+		// apply the delivery policy
+		result = protocol.Deliver(ctx, delivery, result, func(outcome protocol.DeliveryOutcome) {
+			if outcome == protocol.DeliveryReceived {
+				timer.Reset()
+			}
+			c.config.Metrics.SubscriptionDelivery(Subprotocol, outcome)
+		})
+
 		// subscribe the actual subscription
 		if err := c.mgr.Subscribe(&manager.Subscription{
 			IsSub:         true,
@@ -209,6 +379,8 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 			CancelFunc:    cancelFunc,
 		}); err != nil {
 			cancelFunc()
+			timer.Stop()
+			endOperation(protocol.OperationFailed, 1)
 			err := fmt.Errorf("subscriber for %s already exists", id)
 			subLog.WithError(err).Errorf("failed subscribe operation")
 			c.close(SubscriberAlreadyExists, err.Error())
@@ -216,12 +388,13 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 		}
 
 		// start the goroutine to handle graphql events
-		go c.subscribe(ctx, id, subName, *execArgs, result, subLog)
+		go c.subscribe(ctx, id, subName, *execArgs, result, timer, delivery.Enabled(), endOperation, subLog)
 		subLog.Tracef("subscription %q SUBSCRIBED", subName)
 
 	// operation was a query or mutation
 	case *graphql.Result:
 		cancelFunc()
+		endOperation(protocol.ResultStatus(len(result.Errors)), len(result.Errors))
 		notify := false
 		if c.mgr.HasSubscription(id) {
 			notify = true
@@ -246,6 +419,7 @@ func (c *wsConnection) handleSubscribe(msg *RawMessage) {
 	// unknown operation type
 	default:
 		cancelFunc()
+		endOperation(protocol.OperationFailed, 1)
 		err := fmt.Errorf("invalid operationResult type %T", operationResult)
 		subLog.WithError(err).Errorf("failed subscribe operation")
 		c.close(InternalServerError, err.Error())
@@ -259,19 +433,62 @@ func (c *wsConnection) subscribe(
 	subName string,
 	args graphql.Params,
 	resultChannel chan *graphql.Result,
+	timer *protocol.SubscriptionTimer,
+	delivery bool,
+	endOperation func(status protocol.OperationStatus, errorCount int),
 	subLog *logger.LogWrapper,
 ) {
+	var (
+		errorCount int
+		canceled   bool
+	)
+
 	// ensure subscription is always unsubscribed when finished
 	defer func() {
+		status := protocol.ResultStatus(errorCount)
+		if canceled {
+			status = protocol.OperationCanceled
+		}
+		endOperation(status, errorCount)
 		c.mgr.Unsubscribe(id)
 		subLog.Tracef("subscription ended, current count: %d", c.mgr.SubscriptionCount())
 		subLog.Debugf("subscription %q UNSUBSCRIBED", subName)
 	}()
 
+	// a panic ends the subscription with an error
+	defer recovery.Recover(ctx, c.config.PanicHandler, func(err *recovery.Error) {
+		errorCount++
+		subLog.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic in subscription")
+		c.sendError(id, utils.GQLErrors(recovery.ErrInternal))
+	})
+
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			subLog.Tracef("exiting subscription %q", subName)
+			canceled = true
+			return
+
+		case <-timer.Lifetime():
+			subLog.Debugf("subscription %q exceeded its lifetime, completing", subName)
+			if err := c.sendComplete(id, c.mgr.HasSubscription(id)); err != nil {
+				subLog.WithError(err).Errorf("failed to send complete")
+			}
+			return
+
+		case <-timer.Idle():
+			if !timer.IdleExpired() {
+				continue
+			}
+
+			errorCount++
+			err := gqlerror.New(gqlerror.CodeTimeout, "subscription idle timeout exceeded")
+			subLog.WithError(err).Debugf("subscription %q timed out", subName)
+			if err := c.sendError(id, utils.GQLErrors(err)); err != nil {
+				subLog.WithError(err).Errorf("failed to send error")
+			}
 			return
 
 		case res, more := <-resultChannel:
@@ -284,6 +501,12 @@ func (c *wsConnection) subscribe(
 				return
 			}
 
+			// results of a delivery policy reset the timer when received
+			if !delivery {
+				timer.Reset()
+			}
+			errorCount += len(res.Errors)
+
 			// if the response is a single error, close the result and send errors
 			if len(res.Errors) == 1 && res.Data == nil {
 				if err := c.sendError(id, res.Errors); err != nil {
@@ -19,6 +19,7 @@ const (
 	// Close codes
 	Noop                             CloseCode = -1
 	NormalClosure                    CloseCode = 1000
+	TryAgainLater                    CloseCode = 1013
 	InternalServerError              CloseCode = 4500
 	InternalClientError              CloseCode = 4005
 	BadRequest                       CloseCode = 4400
@@ -59,6 +60,7 @@ type SubscribePayload struct {
 	Query         string                 `json:"query"`
 	Variables     map[string]interface{} `json:"variables"`
 	Extensions    map[string]interface{} `json:"extensions"`
+	DocumentID    string                 `json:"documentId,omitempty"`
 }
 
 type NextMessage struct {
@@ -0,0 +1,188 @@
+package graphqltransportws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bhoriuchi/graphql-go-server/utils"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+)
+
+// Expire closes a detached session once its grace period has elapsed
+func (c *wsConnection) Expire() {
+	c.close(NormalClosure, "session expired")
+}
+
+// attachedTransport returns the current transport, waiting while the
+// session is detached
+func (c *wsConnection) attachedTransport() transport.Transport {
+	c.sessionMx.Lock()
+	defer c.sessionMx.Unlock()
+
+	for c.detached {
+		c.attached.Wait()
+	}
+	return c.transport
+}
+
+// detach keeps the session and its subscriptions alive after the
+// transport t failed. It returns false if the connection should be
+// closed instead
+func (c *wsConnection) detach(t transport.Transport) bool {
+	c.ackMx.RLock()
+	token := c.sessionToken
+	c.ackMx.RUnlock()
+
+	c.closeMx.Lock()
+	defer c.closeMx.Unlock()
+
+	if c.closed || token == "" {
+		return false
+	}
+
+	c.sessionMx.Lock()
+	defer c.sessionMx.Unlock()
+
+	// the transport was already detached or replaced
+	if c.detached || c.transport != t {
+		return true
+	}
+
+	c.detached = true
+	t.Close(int(NormalClosure), "session detached")
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
+
+	c.config.Sessions.Detach(token, c)
+	c.log.Debugf("session detached, waiting for the client to resume")
+	return true
+}
+
+// attach resumes a detached session on the transport t. The ack is
+// written before any message buffered while the session was detached
+func (c *wsConnection) attach(t transport.Transport, release func(), ack protocol.OperationMessage) bool {
+	c.closeMx.Lock()
+	defer c.closeMx.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	b, err := c.codec.Marshal(ack)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(c.ctx, WriteTimeout)
+		err = t.WriteMessage(ctx, b)
+		cancel()
+	}
+
+	if err != nil {
+		c.log.WithError(err).Warnf("failed to resume session")
+		c.config.Sessions.Detach(c.sessionToken, c)
+		return false
+	}
+
+	c.sessionMx.Lock()
+	c.transport = t
+	c.release = release
+	c.detached = false
+	c.attached.Broadcast()
+	c.sessionMx.Unlock()
+
+	go c.readLoop(t)
+	c.log.Debugf("session resumed")
+	return true
+}
+
+// handoff stops the connection after its transport was handed to a
+// resumed session, the transport is left open
+func (c *wsConnection) handoff() {
+	c.closeMx.Lock()
+	defer c.closeMx.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	close(c.done)
+	c.outgoing.Close()
+	c.cancel()
+	c.untrack(int(NormalClosure))
+}
+
+// resume hands the transport to the detached session named by the
+// connection_init token. It returns false if there is no session to
+// resume
+func (c *wsConnection) resume(record map[string]interface{}) bool {
+	token, _ := c.connectionParams[protocol.SessionTokenKey].(string)
+	if c.config.Sessions == nil || token == "" {
+		return false
+	}
+
+	s, ok := c.config.Sessions.Resume(token, func(s protocol.Session) bool {
+		prev, ok := s.(*wsConnection)
+		if !ok || c.config.ResumeFunc == nil {
+			return true
+		}
+		return c.config.ResumeFunc(prev, c)
+	})
+	if !ok {
+		return false
+	}
+
+	prev, ok := s.(*wsConnection)
+	if !ok {
+		s.Expire()
+		return false
+	}
+
+	ack := protocol.OperationMessage{
+		Type:    protocol.MsgConnectionAck,
+		Payload: sessionPayload(record, token, true),
+	}
+
+	c.sessionMx.Lock()
+	release := c.release
+	c.sessionMx.Unlock()
+
+	if !prev.attach(c.Transport(), release, ack) {
+		return false
+	}
+
+	c.handoff()
+	return true
+}
+
+// sessionRecord converts the ack payload to an object the session token
+// can be added to. Structs are converted through their JSON encoding,
+// payloads that do not encode as an object return an error
+func sessionRecord(payload interface{}) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	switch v := payload.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, val := range v {
+			record[key] = val
+		}
+	default:
+		if err := utils.ReMarshal(payload, &record); err != nil {
+			return nil, fmt.Errorf("session ack payload %T is not an object: %s", payload, err)
+		}
+	}
+	return record, nil
+}
+
+// sessionPayload adds the session token to a copy of the ack payload
+func sessionPayload(record map[string]interface{}, token string, resumed bool) map[string]interface{} {
+	payload := map[string]interface{}{}
+	for key, val := range record {
+		payload[key] = val
+	}
+
+	payload[protocol.SessionTokenKey] = token
+	payload[protocol.SessionResumedKey] = resumed
+	return payload
+}
@@ -7,9 +7,22 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/codec"
+	"github.com/bhoriuchi/graphql-go-server/document"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
 	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/metrics"
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+	"github.com/bhoriuchi/graphql-go-server/timing"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
+	"github.com/bhoriuchi/graphql-go-server/trusted"
+	"github.com/bhoriuchi/graphql-go-server/utils/ratelimit"
+	"github.com/bhoriuchi/graphql-go-server/ws/fanout"
 	"github.com/bhoriuchi/graphql-go-server/ws/manager"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport/gorillatransport"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/graphql-go/graphql"
@@ -18,75 +31,173 @@ import (
 )
 
 var (
+	// Deprecated: the close deadline is set on the transport, see
+	// gorillatransport.CloseDeadline
 	CloseDeadlineDuration time.Duration = 100 * time.Millisecond
 )
 
 // ConnectionConfig defines the configuration parameters of a
 // GraphQL WebSocket connection.
 type Config struct {
-	WS                  *websocket.Conn
-	Schema              *graphql.Schema
-	Logger              *logger.LogWrapper
-	Request             *http.Request
-	KeepAlive           time.Duration
-	RootValueFunc       func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
-	ContextValueFunc    func(c protocol.Context, msg protocol.OperationMessage, execArgs graphql.Params) (context.Context, gqlerrors.FormattedErrors)
-	OnConnect           func(c protocol.Context, payload interface{}) (interface{}, error)
-	OnDisconnect        func(c protocol.Context)
-	OnOperation         func(c protocol.Context, msg StartMessage, params *graphql.Params) (*graphql.Params, error)
-	OnOperationComplete func(c protocol.Context, id string)
+	// Transport is the connection transport, WS is wrapped with the
+	// gorilla transport when no transport is set
+	Transport               transport.Transport
+	WS                      *websocket.Conn
+	Schema                  *graphql.Schema
+	Logger                  *logger.LogWrapper
+	Request                 *http.Request
+	KeepAlive               time.Duration
+	ContextValueFunc        func(c protocol.Context, msg protocol.OperationMessage, execArgs graphql.Params) (context.Context, gqlerrors.FormattedErrors)
+	OperationFunc           protocol.OperationFunc
+	DeliveryPolicyFunc      protocol.DeliveryPolicyFunc
+	Hooks                   protocol.Hooks
+	OnConnect               func(c protocol.Context, payload interface{}) (interface{}, error)
+	OnDisconnect            func(c protocol.Context)
+	OnOperation             func(c protocol.Context, msg StartMessage, params *graphql.Params) (*graphql.Params, error)
+	OnOperationComplete     func(c protocol.Context, id string)
+	OnClose                 func(c protocol.Context, code CloseCode, reason string)
+	MaxSubscriptions        int
+	OperationsPerSecond     float64
+	OperationBurst          int
+	SendQueueSize           int
+	SendQueuePolicy         protocol.SendQueuePolicy
+	CompressionLevel        int
+	CompressionThreshold    int
+	Codec                   codec.Codec
+	Executor                *document.Executor
+	Tracer                  *tracing.Tracer
+	Metrics                 *metrics.Metrics
+	FieldTiming             *timing.Timing
+	AccessLog               *accesslog.Logger
+	TrustedDocuments        *trusted.Manifest
+	ErrorFormatter          *gqlerror.Formatter
+	PanicHandler            recovery.Handler
+	SubscriptionIdleTimeout time.Duration
+	SubscriptionLifetime    time.Duration
+
+	// Sessions enables resumable sessions, a connection whose transport
+	// disconnects keeps its subscriptions until the client resumes it or
+	// the grace period elapses. Messages sent in the meantime are held
+	// in the send queue. The session token is a bearer secret, ResumeFunc
+	// authorizes a client presenting it, without it the token alone
+	// resumes the session
+	Sessions   *protocol.Sessions
+	ResumeFunc protocol.ResumeFunc
+
+	// Fanout shares the execution of identical subscriptions
+	Fanout *fanout.Hub
+
+	// Release is called once the connection no longer holds its
+	// transport
+	Release func()
+
+	// Deprecated: use OperationFunc, which sets the root value of
+	// websocket and http operations
+	RootValueFunc func(ctx context.Context, r *http.Request, op *ast.OperationDefinition) map[string]interface{}
 }
 
 // wsConnection defines a connection context
 type wsConnection struct {
 	id                     string
 	ctx                    context.Context
-	ws                     *websocket.Conn
+	cancel                 context.CancelFunc
+	traceCtx               context.Context
+	transport              transport.Transport
 	schema                 *graphql.Schema
 	config                 Config
 	log                    *logger.LogWrapper
-	outgoing               chan protocol.OperationMessage
+	codec                  codec.Codec
+	outgoing               *protocol.SendQueue
+	c                      chan protocol.OperationMessage
+	done                   chan struct{}
 	ka                     chan struct{}
 	closeMx                sync.RWMutex
 	initMx                 sync.RWMutex
 	closed                 bool
 	mgr                    *manager.Manager
+	values                 *protocol.Values
 	connectionParams       map[string]interface{}
 	connectionInitReceived bool
+	opLimiter              *ratelimit.TokenBucket
+	untrack                func(code int)
+	sessionToken           string
+	detached               bool
+	attached               *sync.Cond
+	release                func()
+	sessionMx              sync.Mutex
 }
 
 // NewConnection establishes a GraphQL WebSocket connection. It implements
 // the GraphQL WebSocket protocol by managing its internal state and handling
 // the client-server communication.
 func NewConnection(ctx context.Context, config Config) (*wsConnection, error) {
+	if config.Hooks == nil {
+		config.Hooks = protocol.NoopHooks{}
+	}
+
+	// the connection context outlives the upgrade request and is
+	// canceled when the connection is closed
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
 	id := uuid.NewString()
 	l := config.Logger.
 		WithField("connectionId", id).
 		WithField("subprotocol", Subprotocol)
 
 	c := &wsConnection{
-		id:       id,
-		ctx:      ctx,
-		schema:   config.Schema,
-		ws:       config.WS,
-		config:   config,
-		log:      l,
-		closed:   false,
-		outgoing: make(chan protocol.OperationMessage),
-		ka:       make(chan struct{}),
-		mgr:      manager.NewManager(),
+		id:        id,
+		ctx:       ctx,
+		cancel:    cancel,
+		schema:    config.Schema,
+		transport: config.Transport,
+		config:    config,
+		log:       l,
+		codec:     codec.OrDefault(config.Codec),
+		closed:    false,
+		outgoing:  protocol.NewSendQueue(config.SendQueueSize, config.SendQueuePolicy),
+		c:         make(chan protocol.OperationMessage),
+		done:      make(chan struct{}),
+		ka:        make(chan struct{}),
+		mgr:       manager.NewManager(),
+		values:    protocol.NewValues(),
+		release:   config.Release,
+	}
+	c.attached = sync.NewCond(&c.sessionMx)
+
+	if c.transport == nil {
+		c.transport = gorillatransport.New(config.WS)
+	}
+
+	c.untrack = config.Metrics.TrackConnection(Subprotocol, c.mgr, c.outgoing)
+
+	// propagate the trace context from the upgrade request
+	c.traceCtx = ctx
+	if config.Request != nil {
+		c.traceCtx = config.Tracer.ExtractHTTP(ctx, config.Request.Header)
+	}
+
+	if config.OperationsPerSecond > 0 {
+		c.opLimiter = ratelimit.NewTokenBucket(config.OperationsPerSecond, config.OperationBurst)
 	}
 
-	// validate the subprotocol
-	if c.ws.Subprotocol() != Subprotocol {
-		err := fmt.Errorf("subprotocol %q not acceptable", c.ws.Subprotocol())
+	// validate the subprotocol, clients that did not request one are
+	// served the protocol as the server default
+	if sp := c.transport.Subprotocol(); sp != "" && sp != Subprotocol {
+		err := fmt.Errorf("subprotocol %q not acceptable", sp)
 		c.log.WithError(err).Errorf("failed to create connection")
 		c.close(ProtocolError, err.Error())
 		return nil, err
 	}
 
+	if cmp, ok := c.transport.(transport.Compressor); ok {
+		if err := cmp.SetCompression(config.CompressionLevel, config.CompressionThreshold); err != nil {
+			c.log.WithError(err).Warnf("failed to set compression level")
+		}
+	}
+
 	go c.writeLoop()
-	go c.readLoop()
+	go c.readLoop(c.transport)
+	go c.forwardLoop()
 
 	return c, nil
 }
@@ -99,12 +210,94 @@ func (c *wsConnection) Context() context.Context {
 	return c.ctx
 }
 
-func (c *wsConnection) WS() *websocket.Conn {
-	return c.ws
+// Transport returns the connection transport
+func (c *wsConnection) Transport() transport.Transport {
+	c.sessionMx.Lock()
+	defer c.sessionMx.Unlock()
+	return c.transport
+}
+
+// Request returns the websocket upgrade request
+func (c *wsConnection) Request() *http.Request {
+	return c.config.Request
 }
 
+// Subprotocol returns the subprotocol served on the connection
+func (c *wsConnection) Subprotocol() string {
+	return Subprotocol
+}
+
+// Send queues a message for the client
+func (c *wsConnection) Send(msg protocol.OperationMessage) error {
+	return c.sendMessage(msg)
+}
+
+// Close closes the connection
+func (c *wsConnection) Close(code int, reason string) {
+	c.close(CloseCode(code), reason)
+}
+
+// Subscriptions returns the active subscriptions
+func (c *wsConnection) Subscriptions() []protocol.SubscriptionInfo {
+	infos := []protocol.SubscriptionInfo{}
+	for _, sub := range c.mgr.Subscriptions() {
+		infos = append(infos, protocol.SubscriptionInfo{
+			ID:            sub.OperationID,
+			OperationName: sub.OperationName,
+		})
+	}
+	return infos
+}
+
+// Unsubscribe cancels an active operation and completes it
+func (c *wsConnection) Unsubscribe(id string) bool {
+	if sub := c.mgr.Unsubscribe(id); sub == nil {
+		return false
+	}
+
+	c.sendMessage(protocol.OperationMessage{
+		ID:   id,
+		Type: protocol.MsgComplete,
+	})
+	return true
+}
+
+// Values returns the connection key/value store
+func (c *wsConnection) Values() *protocol.Values {
+	return c.values
+}
+
+// C returns the raw send channel. Once the connection is closed it
+// returns a buffered channel that nothing reads so the send is dropped
 func (c *wsConnection) C() chan protocol.OperationMessage {
-	return c.outgoing
+	if c.isClosed() {
+		return make(chan protocol.OperationMessage, 1)
+	}
+	return c.c
+}
+
+// SendQueueStats returns the outbound message queue metrics
+func (c *wsConnection) SendQueueStats() protocol.SendQueueStats {
+	return c.outgoing.Stats()
+}
+
+// forwardLoop forwards messages sent on the raw channel to the send queue
+func (c *wsConnection) forwardLoop() {
+	for {
+		select {
+		case <-c.done:
+			// drop the messages of senders blocked on the raw channel
+			for {
+				select {
+				case <-c.c:
+				default:
+					return
+				}
+			}
+		case msg := <-c.c:
+			c.sendMessage(msg)
+		}
+	}
 }
 
 // ConnectionInitReceived
@@ -126,14 +319,11 @@ func (c *wsConnection) ConnectionParams() map[string]interface{} {
 }
 
 func (c *wsConnection) writeLoop() {
-	// Close the WebSocket connection when leaving the write loop;
-	// this ensures the read loop is also terminated and the connection
-	// closed cleanly
-	defer c.ws.Close()
+	defer recovery.Recover(c.ctx, c.config.PanicHandler, c.closeOnPanic)
 
 	for {
-		msg, ok := <-c.outgoing
-		// Close the write loop when the outgoing messages channel is closed;
+		msg, ok := c.outgoing.Pop()
+		// Close the write loop when the outgoing messages queue is closed;
 		// this will close the connection
 		if !ok {
 			break
@@ -143,22 +333,55 @@ func (c *wsConnection) writeLoop() {
 			return
 		}
 
-		// conn.logger.Debugf("send message: %s", msg.String())
-		c.ws.SetWriteDeadline(time.Now().Add(WriteTimeout))
-
 		// Send the message to the client; if this times out, the WebSocket
 		// connection will be corrupt, hence we need to close the write loop
 		// and the connection immediately
-		if err := c.ws.WriteJSON(msg); err != nil {
+		if err := c.writeMessage(msg); err != nil {
 			c.log.WithError(err).Warnf("failed to write message")
+			c.close(UnexpectedCondition, err.Error())
 			return
 		}
+
+		c.config.Metrics.MessageSent(Subprotocol, msg.Type)
 	}
 }
 
-func (c *wsConnection) readLoop() {
-	// Close the WebSocket connection when leaving the read loop
-	defer c.ws.Close()
+// writeMessage writes the message to the transport. A message that
+// fails to write to a detached session is written once it is resumed
+func (c *wsConnection) writeMessage(msg protocol.OperationMessage) error {
+	b, err := c.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	for {
+		t := c.attachedTransport()
+		if c.isClosed() {
+			return protocol.ErrConnectionClosed
+		}
+
+		ctx, cancel := context.WithTimeout(c.ctx, WriteTimeout)
+		err := t.WriteMessage(ctx, b)
+		cancel()
+
+		if err == nil || !c.detach(t) {
+			return err
+		}
+	}
+}
+
+// readMessage reads the next message from the transport into v
+func (c *wsConnection) readMessage(t transport.Transport, v interface{}) error {
+	b, err := t.ReadMessage(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.codec.Unmarshal(b, v)
+}
+
+func (c *wsConnection) readLoop(t transport.Transport) {
+	defer recovery.Recover(c.ctx, c.config.PanicHandler, c.closeOnPanic)
 
 	for {
 		if c.isClosed() {
@@ -167,27 +390,32 @@ func (c *wsConnection) readLoop() {
 
 		// Read the next message received from the client
 		msg := &protocol.OperationMessage{}
-		err := c.ws.ReadJSON(msg)
+		err := c.readMessage(t, msg)
 
 		// If this causes an error, close the connection and read loop immediately;
 		// see https://github.com/gorilla/websocket/blob/master/conn.go#L924 for
 		// more information on why this is necessary
 		if err != nil {
 			// look for a normal closure and exit
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			if transport.IsCloseError(err, transport.CloseNormalClosure) {
 				c.close(NormalClosure, "Client requested normal closure: close error")
 				break
 			}
 
+			// keep the session for the client to resume
+			if c.detach(t) {
+				break
+			}
+
 			c.log.WithError(err).Errorf("graphql-ws: force closing connection")
-			c.sendError("", protocol.MsgConnectionError, map[string]interface{}{
-				"message": err.Error(),
-			})
+			c.sendError("", protocol.MsgConnectionError, err)
 			time.Sleep(10 * time.Millisecond)
 			c.close(UnexpectedCondition, err.Error())
 			break
 		}
 
+		c.config.Metrics.MessageReceived(Subprotocol, msg.Type)
+
 		switch msg.Type {
 
 		case protocol.MsgConnectionInit:
@@ -203,75 +431,132 @@ func (c *wsConnection) readLoop() {
 			c.handleStop(msg)
 
 		default:
-			err := fmt.Errorf("unhandled message type %q", msg.Type)
+			err := gqlerror.New(gqlerror.CodeBadRequest, fmt.Sprintf("unhandled message type %q", msg.Type))
 			c.log.WithError(err).Errorf("failed to handle message")
-			c.sendError(msg.ID, protocol.MsgError, map[string]interface{}{
-				"message": err.Error(),
-			})
+			c.sendError(msg.ID, protocol.MsgError, err)
 		}
 	}
 }
 
-// Send sends a message
-func (c *wsConnection) sendMessage(msg protocol.OperationMessage) {
-	if !c.isClosed() {
-		c.outgoing <- msg
+// sendMessage queues a message for the client
+func (c *wsConnection) sendMessage(msg protocol.OperationMessage) error {
+	if c.isClosed() {
+		return protocol.ErrConnectionClosed
+	}
+
+	// the close is performed asynchronously since the sender may be
+	// holding locks that are required to close the connection
+	err := c.outgoing.Push(msg)
+	switch err {
+	case protocol.ErrSendQueueFull:
+		c.log.WithError(err).Warnf("disconnecting slow consumer")
+		go c.close(TryAgainLater, "slow consumer")
+	case protocol.ErrSendQueueClosed:
+		return protocol.ErrConnectionClosed
 	}
+
+	return err
 }
 
 // close closes the connection
 func (c *wsConnection) close(code CloseCode, msg string) {
+	// mark as closed and stop outbound messages, the rest of the close
+	// runs without the lock so hooks can call Send, Unsubscribe or Close
 	c.closeMx.Lock()
-	defer c.closeMx.Unlock()
-
 	if c.closed {
+		c.closeMx.Unlock()
 		return
 	}
-
-	// ,ark as closed and stop outbound messages
 	c.closed = true
 	close(c.ka)
-	close(c.outgoing)
-
-	// close the websocket connection
-	closeMsg := websocket.FormatCloseMessage(int(code), msg)
-	deadline := time.Now().Add(CloseDeadlineDuration)
-
-	// close the connection
-	closedWS := true
-	if err := c.ws.WriteControl(websocket.CloseMessage, closeMsg, deadline); err != nil {
-		if err != websocket.ErrCloseSent {
-			c.log.WithError(err).Errorf("failed to write close control message to websocket, trying force close")
-			if err := c.ws.Close(); err != nil {
-				c.log.WithError(err).Errorf("failed to close websocket")
-				closedWS = false
-			}
+	close(c.done)
+	c.outgoing.Close()
+	c.closeMx.Unlock()
+
+	// close the transport unless the session is detached from it
+	c.sessionMx.Lock()
+	if !c.detached {
+		if err := c.transport.Close(int(code), msg); err != nil {
+			c.log.WithError(err).Errorf("failed to close transport")
+		} else {
+			c.log.WithField("code", code).Infof("CLOSED connection with %q", msg)
 		}
 	}
-
-	if closedWS {
-		c.log.WithField("code", code).Infof("CLOSED connection with %q", msg)
+	c.detached = false
+	c.attached.Broadcast()
+	if c.release != nil {
+		c.release()
+		c.release = nil
 	}
+	c.sessionMx.Unlock()
 
 	// clean up subscriptions
 	c.mgr.UnsubscribeAll()
+	c.cancel()
+	c.untrack(int(code))
 
 	// onDisconnect hook
 	if c.Acknowledged() && c.config.OnDisconnect != nil {
 		c.config.OnDisconnect(c)
 	}
+
+	// onClose hook
+	if c.config.OnClose != nil {
+		c.config.OnClose(c, code, msg)
+	}
+	c.config.Hooks.OnClose(c, int(code), msg)
 }
 
 // handleGQLErrors handles graphql errors
-func (c *wsConnection) sendError(id string, t protocol.MessageType, errs interface{}) error {
+func (c *wsConnection) sendError(id string, t protocol.MessageType, err error) error {
+	payload := c.config.ErrorFormatter.FormatError(err)
+
+	// graphql-ws error messages carry a single error
+	if errs := c.config.Hooks.OnError(c, id, gqlerrors.FormattedErrors{payload}); len(errs) > 0 {
+		payload = errs[0]
+	}
+
 	c.sendMessage(protocol.OperationMessage{
 		ID:      id,
 		Type:    t,
-		Payload: errs,
+		Payload: payload,
+	})
+	return nil
+}
+
+// sendData sends a data message, it returns an error if the result hook
+// failed
+func (c *wsConnection) sendData(id string, result *graphql.Result) error {
+	payload := &protocol.ExecutionResult{
+		Errors:     c.config.ErrorFormatter.FormatErrors(result.Errors),
+		Data:       result.Data,
+		Extensions: result.Extensions,
+	}
+
+	maybeResult, err := c.config.Hooks.OnResult(c, id, payload)
+	if err != nil {
+		return err
+	}
+
+	if maybeResult != nil {
+		payload = maybeResult
+	}
+
+	c.sendMessage(protocol.OperationMessage{
+		ID:      id,
+		Type:    protocol.MsgData,
+		Payload: *payload,
 	})
 	return nil
 }
 
+// closeOnPanic closes the connection after a panic has left its state
+// unrecoverable
+func (c *wsConnection) closeOnPanic(err *recovery.Error) {
+	c.log.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic, closing connection")
+	c.close(UnexpectedCondition, "internal server error")
+}
+
 // isClosed returns true if the connection is closed
 func (c *wsConnection) isClosed() bool {
 	c.closeMx.RLock()
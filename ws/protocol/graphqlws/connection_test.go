@@ -0,0 +1,236 @@
+package graphqlws_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/graphql-go/graphql"
+)
+
+// completions records the summaries of completed operations
+type completions struct {
+	protocol.NoopHooks
+	c chan protocol.OperationSummary
+}
+
+func (h *completions) OnOperationComplete(c protocol.Context, summary protocol.OperationSummary) {
+	h.c <- summary
+}
+
+// failingTransport is a transport that fails every write
+type failingTransport struct {
+	*transport.PipeConn
+}
+
+func (t failingTransport) WriteMessage(ctx context.Context, b []byte) error {
+	return errors.New("write failed")
+}
+
+// connect starts a connection over a pipe and returns the client end
+func connect(t *testing.T, config graphqlws.Config) *testutil.Client {
+	t.Helper()
+
+	server, client := transport.Pipe(graphqlws.Subprotocol)
+	config.Transport = server
+	if config.Logger == nil {
+		config.Logger = logger.NewLogWrapper(logger.NoopLogFunc, nil)
+	}
+
+	if _, err := graphqlws.NewConnection(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	c := testutil.NewClient(t, client)
+	t.Cleanup(func() { c.Close(transport.CloseNormalClosure) })
+	return c
+}
+
+// counter creates a schema with a count subscription resolving the
+// events sent on the returned channel
+func counter(t *testing.T) (chan interface{}, *graphql.Schema) {
+	events := make(chan interface{})
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"count": testutil.Events(events)},
+	})
+	return events, &schema
+}
+
+func TestQuery(t *testing.T) {
+	schema := testutil.Hello(t)
+	client := connect(t, graphqlws.Config{Schema: &schema})
+
+	client.Send(`{"type":"connection_init"}`)
+	client.Expect(protocol.MsgConnectionAck)
+
+	client.Send(`{"id":"1","type":"start","payload":{"query":"{ hello }"}}`)
+	data := client.Expect(protocol.MsgData)
+	if data.ID != "1" || testutil.Data(data)["hello"] != "world" {
+		t.Fatalf("unexpected data message %+v", data)
+	}
+	if complete := client.Expect(protocol.MsgComplete); complete.ID != "1" {
+		t.Fatalf("unexpected complete message %+v", complete)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	schema := testutil.Hello(t)
+	client := connect(t, graphqlws.Config{Schema: &schema, KeepAlive: 10 * time.Millisecond})
+
+	client.Send(`{"type":"connection_init"}`)
+	client.Expect(protocol.MsgConnectionAck)
+	client.Expect(protocol.MsgKeepAlive)
+	client.Expect(protocol.MsgKeepAlive)
+}
+
+func TestSubscription(t *testing.T) {
+	t.Run("server complete", func(t *testing.T) {
+		events, schema := counter(t)
+		client := connect(t, graphqlws.Config{Schema: schema})
+		client.Send(`{"type":"connection_init"}`)
+		client.Expect(protocol.MsgConnectionAck)
+		client.Send(`{"id":"1","type":"start","payload":{"query":"subscription { count }"}}`)
+
+		for i := 1; i <= 2; i++ {
+			events <- i
+			data := client.Expect(protocol.MsgData)
+			if count := testutil.Data(data)["count"]; count != float64(i) {
+				t.Fatalf("expected count %d, got %v", i, count)
+			}
+		}
+
+		// the source closing completes the operation
+		close(events)
+		client.Expect(protocol.MsgComplete)
+	})
+
+	t.Run("client stop", func(t *testing.T) {
+		events, schema := counter(t)
+		hooks := &completions{c: make(chan protocol.OperationSummary, 1)}
+		client := connect(t, graphqlws.Config{Schema: schema, Hooks: hooks})
+		client.Send(`{"type":"connection_init"}`)
+		client.Expect(protocol.MsgConnectionAck)
+		client.Send(`{"id":"1","type":"start","payload":{"query":"subscription { count }"}}`)
+
+		events <- 1
+		client.Expect(protocol.MsgData)
+		client.Send(`{"id":"1","type":"stop"}`)
+
+		select {
+		case summary := <-hooks.c:
+			if summary.ID != "1" || summary.Status != protocol.OperationCanceled {
+				t.Fatalf("expected a canceled operation, got %+v", summary)
+			}
+		case <-time.After(testutil.ReadTimeout):
+			t.Fatal("operation did not complete")
+		}
+	})
+}
+
+func TestErrors(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	tests := []struct {
+		name    string
+		init    bool
+		message string
+		msgType protocol.MessageType
+	}{
+		{
+			name:    "start before init",
+			message: `{"id":"1","type":"start","payload":{"query":"{ hello }"}}`,
+			msgType: protocol.MsgConnectionError,
+		},
+		{
+			name:    "unknown message type",
+			init:    true,
+			message: `{"id":"1","type":"subscribe"}`,
+			msgType: protocol.MsgError,
+		},
+		{
+			name:    "invalid query",
+			init:    true,
+			message: `{"id":"1","type":"start","payload":{"query":"{ hello"}}`,
+			msgType: protocol.MsgError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := connect(t, graphqlws.Config{Schema: &schema})
+			if tt.init {
+				client.Send(`{"type":"connection_init"}`)
+				client.Expect(protocol.MsgConnectionAck)
+			}
+
+			client.Send(tt.message)
+			if msg := client.Expect(tt.msgType); msg.ID != "1" {
+				t.Fatalf("unexpected message %+v", msg)
+			}
+		})
+	}
+}
+
+func TestCloseCodes(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	tests := []struct {
+		name     string
+		config   graphqlws.Config
+		messages []string
+		code     graphqlws.CloseCode
+	}{
+		{
+			name:     "duplicate init",
+			messages: []string{`{"type":"connection_init"}`, `{"type":"connection_init"}`},
+			code:     graphqlws.UnexpectedCondition,
+		},
+		{
+			name: "connection rejected",
+			config: graphqlws.Config{
+				OnConnect: func(c protocol.Context, payload interface{}) (interface{}, error) {
+					return false, nil
+				},
+			},
+			messages: []string{`{"type":"connection_init"}`},
+			code:     graphqlws.UnexpectedCondition,
+		},
+		{
+			name:     "terminate",
+			messages: []string{`{"type":"connection_terminate"}`},
+			code:     graphqlws.NormalClosure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Schema = &schema
+			client := connect(t, tt.config)
+			for _, msg := range tt.messages {
+				client.Send(msg)
+			}
+			client.ExpectClose(int(tt.code))
+		})
+	}
+
+	t.Run("write failure", func(t *testing.T) {
+		server, conn := transport.Pipe(graphqlws.Subprotocol)
+		if _, err := graphqlws.NewConnection(context.Background(), graphqlws.Config{
+			Transport: failingTransport{server},
+			Schema:    &schema,
+			Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		client := testutil.NewClient(t, conn)
+		client.Send(`{"type":"connection_init"}`)
+		client.ExpectClose(int(graphqlws.UnexpectedCondition))
+	})
+}
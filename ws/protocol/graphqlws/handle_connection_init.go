@@ -1,9 +1,9 @@
 package graphqlws
 
 import (
-	"fmt"
 	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
 )
 
@@ -21,6 +21,13 @@ func (c *wsConnection) handleConnectionInit(msg *protocol.OperationMessage) {
 		return
 	}
 
+	// keep the payload as the connection params and propagate its trace
+	// context
+	if payload, ok := msg.Payload.(map[string]interface{}); ok {
+		c.connectionParams = payload
+		c.traceCtx = c.config.Tracer.ExtractPayload(c.traceCtx, payload)
+	}
+
 	// handle connection hook
 	if c.config.OnConnect != nil {
 		maybeContext, err := c.config.OnConnect(c, msg.Payload)
@@ -33,16 +40,15 @@ func (c *wsConnection) handleConnectionInit(msg *protocol.OperationMessage) {
 		switch v := maybeContext.(type) {
 		case bool:
 			if !v {
-				err := fmt.Errorf("prohibited connection")
-				c.sendError(msg.ID, protocol.MsgConnectionError, map[string]interface{}{
-					"message": err.Error(),
-				})
+				err := gqlerror.New(gqlerror.CodeForbidden, "prohibited connection")
+				c.sendError(msg.ID, protocol.MsgConnectionError, err)
 				time.Sleep(10 * time.Millisecond)
 				c.close(UnexpectedCondition, err.Error())
 				c.initMx.Unlock()
 				return
 			}
 		case map[string]interface{}:
+			// the hook replaces the connection params
 			c.connectionParams = v
 		}
 	}
@@ -52,27 +58,38 @@ func (c *wsConnection) handleConnectionInit(msg *protocol.OperationMessage) {
 	c.connectionInitReceived = true
 	c.initMx.Unlock()
 
-	// send an ack message
-	c.sendMessage(protocol.OperationMessage{
+	// resume the detached session named by the session token
+	payload, _ := msg.Payload.(map[string]interface{})
+	if token, _ := payload[protocol.SessionTokenKey].(string); c.resume(token) {
+		return
+	}
+
+	// send an ack message with a token the client can resume the
+	// session with
+	ack := protocol.OperationMessage{
 		Type: protocol.MsgConnectionAck,
-	})
+	}
+
+	if c.config.Sessions != nil {
+		token := protocol.NewSessionToken()
+		c.initMx.Lock()
+		c.sessionToken = token
+		c.initMx.Unlock()
+		ack.Payload = sessionPayload(token, false)
+	}
+
+	c.sendMessage(ack)
 
 	// setup keep-alives
 	if c.config.KeepAlive > 0 {
-		c.log.Tracef("sending KEEP_ALIVE message")
-		c.sendMessage(protocol.OperationMessage{
-			Type: protocol.MsgKeepAlive,
-		})
+		c.sendKeepAlive()
 
 		ticker := time.NewTicker(c.config.KeepAlive)
 		go func() {
 			for {
 				select {
 				case <-ticker.C:
-					c.log.Tracef("sending KEEP_ALIVE message")
-					c.sendMessage(protocol.OperationMessage{
-						Type: protocol.MsgKeepAlive,
-					})
+					c.sendKeepAlive()
 				case <-c.ka:
 					ticker.Stop()
 					return
@@ -81,3 +98,20 @@ func (c *wsConnection) handleConnectionInit(msg *protocol.OperationMessage) {
 		}()
 	}
 }
+
+// sendKeepAlive sends a keepalive message
+func (c *wsConnection) sendKeepAlive() {
+	// keep-alives are not held for a detached session
+	if c.isDetached() {
+		return
+	}
+
+	c.log.Tracef("sending KEEP_ALIVE message")
+	c.sendMessage(protocol.OperationMessage{
+		Type: protocol.MsgKeepAlive,
+	})
+	c.config.Hooks.OnKeepAlive(c, protocol.KeepAliveEvent{
+		Type: protocol.MsgKeepAlive,
+		Sent: true,
+	})
+}
@@ -3,12 +3,19 @@ package graphqlws
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/bhoriuchi/graphql-go-server/accesslog"
+	"github.com/bhoriuchi/graphql-go-server/gqlerror"
 	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/recovery"
+	"github.com/bhoriuchi/graphql-go-server/tracing"
 	"github.com/bhoriuchi/graphql-go-server/utils"
 	"github.com/bhoriuchi/graphql-go-server/ws/manager"
 	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
 	"github.com/graphql-go/graphql/language/ast"
 )
 
@@ -16,27 +23,42 @@ func (c *wsConnection) handleStart(msg *protocol.OperationMessage) {
 	var (
 		err             error
 		operationResult interface{}
+		failOperation   func()
 	)
 	id := msg.ID
 
 	if id == "" {
 		c.log.Debugf("received START message")
-		msgtxt := "message contains no ID"
-		c.log.Errorf(msgtxt)
-		c.sendError("", protocol.MsgError, map[string]interface{}{
-			"message": msgtxt,
-		})
+		err := gqlerror.New(gqlerror.CodeBadRequest, "message contains no ID")
+		c.log.WithError(err).Errorf("invalid start message")
+		c.sendError("", protocol.MsgError, err)
 		return
 	}
 
 	subLog := c.log.WithField("subscriptionId", id)
 	subLog.Debugf("received START message")
 
+	// a panic fails the operation rather than the connection
+	defer recovery.Recover(c.ctx, c.config.PanicHandler, func(err *recovery.Error) {
+		subLog.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic in start operation")
+		if failOperation != nil {
+			failOperation()
+		}
+		c.sendError(id, protocol.MsgError, recovery.ErrInternal)
+		c.mgr.Unsubscribe(id)
+	})
+
 	if !c.ConnectionInitReceived() {
-		err := fmt.Errorf("attempted start operation on uninitialized connection")
-		c.sendError(id, protocol.MsgConnectionError, map[string]interface{}{
-			"message": err.Error(),
-		})
+		err := gqlerror.New(gqlerror.CodeUnauthenticated, "attempted start operation on uninitialized connection")
+		c.sendError(id, protocol.MsgConnectionError, err)
+		return
+	}
+
+	// enforce the operation rate limit
+	if c.opLimiter != nil && !c.opLimiter.Allow() {
+		err := gqlerror.New(gqlerror.CodeRateLimited, "operation rate limit exceeded")
+		subLog.WithError(err).Warnf("start operation rejected")
+		c.sendError(id, protocol.MsgError, err)
 		return
 	}
 
@@ -45,21 +67,34 @@ func (c *wsConnection) handleStart(msg *protocol.OperationMessage) {
 		c.mgr.Unsubscribe(id)
 	}
 
+	// enforce the concurrent subscription limit
+	if max := c.config.MaxSubscriptions; max > 0 && c.mgr.SubscriptionCount() >= max {
+		err := gqlerror.New(gqlerror.CodeRateLimited, fmt.Sprintf("maximum of %d concurrent subscriptions exceeded", max))
+		subLog.WithError(err).Warnf("start operation rejected")
+		c.sendError(id, protocol.MsgError, err)
+		return
+	}
+
 	payload := &StartMessagePayload{}
 	if err := utils.ReMarshal(msg.Payload, payload); err != nil {
-		msgtxt := fmt.Sprintf("failed to parse start payload: %s", err)
 		subLog.WithError(err).Errorf("failed to parse start payload")
-		c.sendError(id, protocol.MsgError, map[string]interface{}{
-			"message": msgtxt,
-		})
+		err = gqlerror.New(gqlerror.CodeBadRequest, fmt.Sprintf("failed to parse start payload: %s", err))
+		c.sendError(id, protocol.MsgError, err)
 		return
 	}
 
+	// resolve the trusted document
+	query, err := c.config.TrustedDocuments.Resolve(payload.Query, payload.DocumentID, payload.Extensions)
+	if err != nil {
+		subLog.WithError(err).Warnf("untrusted document rejected")
+		c.sendError(id, protocol.MsgError, err)
+		return
+	}
+	payload.Query = query
+
 	if err := payload.Validate(); err != nil {
 		subLog.WithError(err).Errorf("start payload validation error")
-		c.sendError(id, protocol.MsgError, map[string]interface{}{
-			"message": err.Error(),
-		})
+		c.sendError(id, protocol.MsgError, err)
 		return
 	}
 
@@ -75,44 +110,150 @@ func (c *wsConnection) handleStart(msg *protocol.OperationMessage) {
 		subName = "Unnamed Subscription"
 	}
 
+	rctx := c.ctx
+	if c.config.ContextValueFunc != nil {
+		var formattedErrs gqlerrors.FormattedErrors
+		if rctx, formattedErrs = c.config.ContextValueFunc(c, *msg, *execArgs); len(formattedErrs) > 0 {
+			subLog.WithError(formattedErrs[0]).Errorf("contextValueFunc failed")
+			c.sendError(id, protocol.MsgError, formattedErrs[0])
+			return
+		}
+	}
+
+	// create a cancelable context that ends with the connection
+	ctx, cancelFunc := protocol.OperationContext(c.ctx, rctx)
+
+	// start the operation span and metrics before parsing so that parse
+	// failures are recorded
+	ctx, span := c.config.Tracer.StartOperation(
+		c.config.Tracer.WithParent(ctx, c.config.Tracer.ExtractExtensions(c.traceCtx, payload.Extensions)),
+		"graphql.ws.operation",
+		tracing.OperationIDKey.String(id),
+		tracing.ConnectionIDKey.String(c.id),
+		tracing.SubprotocolKey.String(Subprotocol),
+	)
+	ctx, op := c.config.Metrics.StartOperation(ctx, Subprotocol)
+
+	var remoteAddr string
+	if c.config.Request != nil {
+		remoteAddr = c.config.Request.RemoteAddr
+	}
+	ctx, access := c.config.AccessLog.StartOperation(ctx, accesslog.Entry{
+		Transport:    Subprotocol,
+		RemoteAddr:   remoteAddr,
+		ConnectionID: c.id,
+		OperationID:  id,
+	})
+
+	// end the operation span and metrics and notify the hooks
+	var operation *ast.OperationDefinition
+	start := time.Now()
+	var endOnce sync.Once
+	endOperation := func(status protocol.OperationStatus, errorCount int) {
+		endOnce.Do(func() {
+			tracing.EndOperation(span, errorCount)
+			op.End(errorCount)
+			access.End(errorCount)
+
+			summary := protocol.OperationSummary{
+				ID:            id,
+				OperationName: execArgs.OperationName,
+				Status:        status,
+				ErrorCount:    errorCount,
+				Duration:      time.Since(start),
+			}
+			if operation != nil {
+				summary.OperationType = operation.Operation
+			}
+			c.config.Hooks.OnOperationComplete(c, summary)
+		})
+	}
+
+	// a panic from here on fails the started operation
+	failOperation = func() {
+		cancelFunc()
+		endOperation(protocol.OperationFailed, 1)
+	}
+
+	// reject the operation before it is executed
+	reject := func(err error, msg string) {
+		cancelFunc()
+		endOperation(protocol.OperationFailed, 1)
+		subLog.WithError(err).Errorf(msg)
+		c.sendError(id, protocol.MsgError, err)
+	}
+
 	// get the operation
-	document, err := utils.ParseQuery(execArgs.RequestString)
+	execArgs.Context = ctx
+	doc, err := c.config.Executor.Parse(ctx, execArgs)
 	if err != nil {
-		subLog.WithError(err).Errorf("failed to parse query")
-		err = fmt.Errorf("failed to parse query: %s", err)
-		c.sendError(id, protocol.MsgError, map[string]interface{}{
-			"message": err.Error(),
-		})
+		err = gqlerror.New(gqlerror.CodeGraphQLParseFailed, fmt.Sprintf("failed to parse query: %s", err))
+		reject(err, "failed to parse query")
 		return
 	}
 
-	operation, err := utils.GetOperationAST(document, execArgs.OperationName)
+	operation, err = utils.GetOperationAST(doc.AST, execArgs.OperationName)
 	if err != nil {
-		subLog.WithError(err).Errorf("failed to identify operation")
-		err = fmt.Errorf("failed to identify operation: %s", err)
-		c.sendError(id, protocol.MsgError, map[string]interface{}{
-			"message": err.Error(),
-		})
+		err = gqlerror.New(gqlerror.CodeBadUserInput, fmt.Sprintf("failed to identify operation: %s", err))
+		reject(err, "failed to identify operation")
 		return
 	}
 
-	rctx := context.Background()
-	if c.config.ContextValueFunc != nil {
-		rctx, _ = c.config.ContextValueFunc(c, *msg, *execArgs)
+	span.SetAttributes(tracing.OperationAttributes(operation)...)
+	op.SetOperation(operation)
+
+	// record field timings for queries and mutations that opted in
+	if operation.Operation != ast.OperationTypeSubscription {
+		ctx = c.config.FieldTiming.OptIn(ctx, c.config.Request, c.ConnectionParams(), payload.Extensions)
 	}
 
-	// add the connection to the metadata context
-	ctx, cancelFunc := context.WithCancel(rctx)
+	// apply the operation hook shared with http
+	var rootValue map[string]interface{}
+	if c.config.OperationFunc != nil {
+		opCtx, root, err := c.config.OperationFunc(ctx, protocol.OperationInfo{
+			Request:   c.config.Request,
+			Conn:      c,
+			ID:        id,
+			Operation: operation,
+			Params:    execArgs,
+		})
+		if err != nil {
+			reject(err, "operation hook failed")
+			return
+		}
+		if opCtx != nil {
+			ctx = opCtx
+		}
+		rootValue = root
+	}
+
+	// resolve the delivery policy of subscriptions
+	var delivery *protocol.DeliveryPolicy
+	if operation.Operation == ast.OperationTypeSubscription {
+		delivery, err = protocol.ResolveDeliveryPolicy(c.config.DeliveryPolicyFunc, c, protocol.OperationInfo{
+			Request:   c.config.Request,
+			Conn:      c,
+			ID:        id,
+			Operation: operation,
+			Params:    execArgs,
+		})
+		if err != nil {
+			reject(err, "failed to resolve delivery policy")
+			return
+		}
+	}
 	execArgs.Context = ctx
 
 	// set the root value
 	if execArgs.RootObject == nil {
-		if c.config.RootValueFunc != nil {
+		if rootValue != nil {
+			execArgs.RootObject = rootValue
+		} else if c.config.RootValueFunc != nil {
 			execArgs.RootObject = c.config.RootValueFunc(execArgs.Context, c.config.Request, operation)
 		}
 	}
 
-	if execArgs.RootObject != nil {
+	if execArgs.RootObject == nil {
 		execArgs.RootObject = map[string]interface{}{}
 	}
 
@@ -126,22 +267,37 @@ func (c *wsConnection) handleStart(msg *protocol.OperationMessage) {
 
 		if execArgs, err = c.config.OnOperation(c, parsedMessage, execArgs); err != nil {
 			c.log.WithError(err).Errorf("onOperation hook failed")
-			c.sendError(id, protocol.MsgError, map[string]interface{}{
-				"message": err.Error(),
-			})
+			c.sendError(id, protocol.MsgError, err)
 			cancelFunc()
+			endOperation(protocol.OperationFailed, 1)
 			return
 		}
 	}
 
-	if operation.Operation == ast.OperationTypeSubscription {
-		operationResult = graphql.Subscribe(*execArgs)
-	} else {
-		operationResult = graphql.Do(*execArgs)
+	switch {
+	case operation.Operation != ast.OperationTypeSubscription:
+		operationResult = c.config.Executor.Execute(*execArgs, doc)
+	case c.config.Fanout != nil:
+		// identical subscriptions share a single execution
+		operationResult = c.config.Fanout.Subscribe(*execArgs, doc)
+	default:
+		operationResult = c.config.Executor.ExecuteSubscription(*execArgs, doc)
 	}
 
 	switch result := operationResult.(type) {
 	case chan *graphql.Result:
+		// enforce the idle timeout and lifetime, the idle timeout is reset
+		// by results received from the source rather than delivered ones
+		timer := protocol.NewSubscriptionTimer(c.config.SubscriptionIdleTimeout, c.config.SubscriptionLifetime)
+
+		// apply the delivery policy
+		result = protocol.Deliver(ctx, delivery, result, func(outcome protocol.DeliveryOutcome) {
+			if outcome == protocol.DeliveryReceived {
+				timer.Reset()
+			}
+			c.config.Metrics.SubscriptionDelivery(Subprotocol, outcome)
+		})
+
 		if err := c.mgr.Subscribe(&manager.Subscription{
 			IsSub:         true,
 			Channel:       result,
@@ -151,28 +307,26 @@ func (c *wsConnection) handleStart(msg *protocol.OperationMessage) {
 			Context:       ctx,
 			CancelFunc:    cancelFunc,
 		}); err != nil {
+			cancelFunc()
+			timer.Stop()
+			endOperation(protocol.OperationFailed, 1)
 			c.log.WithError(err).Errorf("subscribe operation failed")
-			c.sendError(id, protocol.MsgError, map[string]interface{}{
-				"message": err.Error(),
-			})
+			c.sendError(id, protocol.MsgError, err)
 			return
 		}
 
 		c.log.Tracef("subscription %q SUBSCRIBED", subName)
 		subLog.Tracef("subscription count increased to: %d", c.mgr.SubscriptionCount())
-		go c.subscribe(ctx, id, subName, *execArgs, result, subLog)
+		go c.subscribe(ctx, id, subName, *execArgs, result, timer, delivery.Enabled(), endOperation, subLog)
 
 	case *graphql.Result:
 		cancelFunc()
-		c.sendMessage(protocol.OperationMessage{
-			ID:   id,
-			Type: protocol.MsgData,
-			Payload: protocol.ExecutionResult{
-				Errors:     result.Errors,
-				Data:       result.Data,
-				Extensions: result.Extensions,
-			},
-		})
+		endOperation(protocol.ResultStatus(len(result.Errors)), len(result.Errors))
+		if err := c.sendData(id, result); err != nil {
+			subLog.WithError(err).Errorf("failed to send data")
+			c.close(UnexpectedCondition, err.Error())
+			return
+		}
 
 		c.sendMessage(protocol.OperationMessage{
 			ID:   id,
@@ -183,6 +337,7 @@ func (c *wsConnection) handleStart(msg *protocol.OperationMessage) {
 
 	default:
 		cancelFunc()
+		endOperation(protocol.OperationFailed, 1)
 		err := fmt.Errorf("invalid operationResult type %T", operationResult)
 		subLog.WithError(err).Errorf("failed subscribe operation")
 		c.close(UnexpectedCondition, err.Error())
@@ -197,10 +352,23 @@ func (c *wsConnection) subscribe(
 	subName string,
 	args graphql.Params,
 	resultChannel chan *graphql.Result,
+	timer *protocol.SubscriptionTimer,
+	delivery bool,
+	endOperation func(status protocol.OperationStatus, errorCount int),
 	subLog *logger.LogWrapper,
 ) {
+	var (
+		errorCount int
+		canceled   bool
+	)
+
 	// ensure subscription is always unsubscribed when finished
 	defer func() {
+		status := protocol.ResultStatus(errorCount)
+		if canceled {
+			status = protocol.OperationCanceled
+		}
+		endOperation(status, errorCount)
 		c.mgr.Unsubscribe(id)
 		subLog.Tracef("subscription ended, current count: %d", c.mgr.SubscriptionCount())
 		c.log.Debugf("subscription %q UNSUBSCRIBED", subName)
@@ -210,10 +378,41 @@ func (c *wsConnection) subscribe(
 		}
 	}()
 
+	// a panic ends the subscription with an error
+	defer recovery.Recover(ctx, c.config.PanicHandler, func(err *recovery.Error) {
+		errorCount++
+		subLog.WithError(err).WithField("stack", string(err.Stack)).Errorf("recovered panic in subscription")
+		c.sendError(id, protocol.MsgError, recovery.ErrInternal)
+	})
+
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			c.log.Tracef("exiting subscription %q", subName)
+			canceled = true
+			return
+
+		case <-timer.Lifetime():
+			subLog.Debugf("subscription %q exceeded its lifetime, completing", subName)
+			if c.mgr.HasSubscription(id) {
+				c.sendMessage(protocol.OperationMessage{
+					ID:   id,
+					Type: protocol.MsgComplete,
+				})
+			}
+			return
+
+		case <-timer.Idle():
+			if !timer.IdleExpired() {
+				continue
+			}
+
+			errorCount++
+			err := gqlerror.New(gqlerror.CodeTimeout, "subscription idle timeout exceeded")
+			subLog.WithError(err).Debugf("subscription %q timed out", subName)
+			c.sendError(id, protocol.MsgError, err)
 			return
 
 		case res, more := <-resultChannel:
@@ -231,27 +430,23 @@ func (c *wsConnection) subscribe(
 				return
 			}
 
+			// results of a delivery policy reset the timer when received
+			if !delivery {
+				timer.Reset()
+			}
+			errorCount += len(res.Errors)
+
 			// if the response is all errors, close the result and send errors
 			if len(res.Errors) == 1 && res.Data == nil {
 				err := res.Errors[0]
-				c.log.WithError(fmt.Errorf(err.Message)).Errorf("subscription encountered an error")
-				c.sendMessage(protocol.OperationMessage{
-					ID:   id,
-					Type: protocol.MsgError,
-					Payload: map[string]interface{}{
-						"message": err.Message,
-					},
-				})
+				c.log.WithError(err).Errorf("subscription encountered an error")
+				c.sendError(id, protocol.MsgError, err)
 			} else {
-				c.sendMessage(protocol.OperationMessage{
-					ID:   id,
-					Type: protocol.MsgData,
-					Payload: protocol.ExecutionResult{
-						Errors:     res.Errors,
-						Data:       res.Data,
-						Extensions: res.Extensions,
-					},
-				})
+				if err := c.sendData(id, res); err != nil {
+					subLog.WithError(err).Errorf("failed to send data")
+					c.close(UnexpectedCondition, err.Error())
+					return
+				}
 			}
 		}
 	}
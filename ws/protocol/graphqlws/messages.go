@@ -12,6 +12,8 @@ type StartMessagePayload struct {
 	Query         string                 `json:"query"`
 	Variables     map[string]interface{} `json:"variables"`
 	OperationName string                 `json:"operationName"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+	DocumentID    string                 `json:"documentId,omitempty"`
 }
 
 func (s *StartMessagePayload) Validate() error {
@@ -14,6 +14,7 @@ const (
 	NormalClosure       CloseCode = 1000
 	ProtocolError       CloseCode = 1002
 	UnexpectedCondition CloseCode = 1011
+	TryAgainLater       CloseCode = 1013
 
 	// Thresholds
 	WriteTimeout = 10 * time.Second
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"time"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// OperationStatus is the final status of an operation
+type OperationStatus string
+
+const (
+	// OperationCompleted is an operation that finished without errors
+	OperationCompleted OperationStatus = "completed"
+
+	// OperationFailed is an operation that returned errors
+	OperationFailed OperationStatus = "failed"
+
+	// OperationCanceled is an operation stopped by the client or by the
+	// connection closing
+	OperationCanceled OperationStatus = "canceled"
+)
+
+// ResultStatus returns the status of an operation that was not canceled
+func ResultStatus(errorCount int) OperationStatus {
+	if errorCount > 0 {
+		return OperationFailed
+	}
+	return OperationCompleted
+}
+
+// OperationSummary describes a finished operation
+type OperationSummary struct {
+	ID            string
+	OperationName string
+	OperationType string
+	Status        OperationStatus
+	ErrorCount    int
+	Duration      time.Duration
+}
+
+// KeepAliveEvent is a keepalive message sent or received by the server,
+// graphql-ws sends ka messages and graphql-transport-ws exchanges ping
+// and pong messages
+type KeepAliveEvent struct {
+	Type    MessageType
+	Sent    bool
+	Payload map[string]interface{}
+}
+
+// Hooks are connection lifecycle hooks implemented once and shared by the
+// graphql-ws and graphql-transport-ws protocols. They run after the
+// protocol specific hooks. NoopHooks can be embedded to implement only
+// some of the hooks
+type Hooks interface {
+	// OnResult transforms a result before it is sent, a nil result sends
+	// the result unchanged and an error closes the connection
+	OnResult(c Context, id string, result *ExecutionResult) (*ExecutionResult, error)
+
+	// OnError transforms the errors of an operation before they are sent,
+	// nil sends the errors unchanged
+	OnError(c Context, id string, errs gqlerrors.FormattedErrors) gqlerrors.FormattedErrors
+
+	// OnKeepAlive is called when a keepalive message is sent or received
+	OnKeepAlive(c Context, event KeepAliveEvent)
+
+	// OnOperationComplete is called once an operation has finished
+	OnOperationComplete(c Context, summary OperationSummary)
+
+	// OnClose is called when the connection closes
+	OnClose(c Context, code int, reason string)
+}
+
+// NoopHooks implements Hooks without doing anything
+type NoopHooks struct{}
+
+func (NoopHooks) OnResult(c Context, id string, result *ExecutionResult) (*ExecutionResult, error) {
+	return nil, nil
+}
+
+func (NoopHooks) OnError(c Context, id string, errs gqlerrors.FormattedErrors) gqlerrors.FormattedErrors {
+	return nil
+}
+
+func (NoopHooks) OnKeepAlive(c Context, event KeepAliveEvent) {}
+
+func (NoopHooks) OnOperationComplete(c Context, summary OperationSummary) {}
+
+func (NoopHooks) OnClose(c Context, code int, reason string) {}
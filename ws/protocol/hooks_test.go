@@ -0,0 +1,187 @@
+package protocol_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+)
+
+type testHooks struct {
+	protocol.NoopHooks
+	mx        sync.Mutex
+	summaries []protocol.OperationSummary
+}
+
+func (h *testHooks) OnResult(c protocol.Context, id string, result *protocol.ExecutionResult) (*protocol.ExecutionResult, error) {
+	return &protocol.ExecutionResult{Data: map[string]interface{}{"filtered": true}}, nil
+}
+
+func (h *testHooks) OnOperationComplete(c protocol.Context, summary protocol.OperationSummary) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.summaries = append(h.summaries, summary)
+}
+
+func TestHooks(t *testing.T) {
+	schema := testutil.Hello(t)
+	log := logger.NewLogWrapper(logger.NoopLogFunc, nil)
+
+	tests := []struct {
+		subprotocol string
+		start       func(tr transport.Transport, hooks protocol.Hooks) error
+		messages    []string
+		resultType  protocol.MessageType
+	}{
+		{
+			subprotocol: graphqltransportws.Subprotocol,
+			start: func(tr transport.Transport, hooks protocol.Hooks) error {
+				_, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+					Transport: tr,
+					Schema:    &schema,
+					Logger:    log,
+					Hooks:     hooks,
+				})
+				return err
+			},
+			messages: []string{
+				`{"type":"connection_init"}`,
+				`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`,
+			},
+			resultType: protocol.MsgNext,
+		},
+		{
+			subprotocol: graphqlws.Subprotocol,
+			start: func(tr transport.Transport, hooks protocol.Hooks) error {
+				_, err := graphqlws.NewConnection(context.Background(), graphqlws.Config{
+					Transport: tr,
+					Schema:    &schema,
+					Logger:    log,
+					Hooks:     hooks,
+				})
+				return err
+			},
+			messages: []string{
+				`{"type":"connection_init"}`,
+				`{"id":"1","type":"start","payload":{"query":"{ hello }"}}`,
+			},
+			resultType: protocol.MsgData,
+		},
+	}
+
+	for _, tt := range tests {
+		hooks := &testHooks{}
+		server, client := transport.Pipe(tt.subprotocol)
+		if err := tt.start(server, hooks); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		go func() {
+			for _, msg := range tt.messages {
+				client.WriteMessage(ctx, []byte(msg))
+			}
+		}()
+
+		var result protocol.OperationMessage
+		for result.Type != protocol.MsgComplete {
+			b, err := client.ReadMessage(ctx)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.subprotocol, err)
+			}
+			result = protocol.OperationMessage{}
+			json.Unmarshal(b, &result)
+
+			if result.Type == tt.resultType {
+				data := result.Payload.(map[string]interface{})["data"].(map[string]interface{})
+				if data["filtered"] != true {
+					t.Errorf("%s: expected filtered result, got %v", tt.subprotocol, data)
+				}
+			}
+		}
+
+		client.Close(transport.CloseNormalClosure, "")
+		cancel()
+
+		hooks.mx.Lock()
+		if len(hooks.summaries) != 1 || hooks.summaries[0].Status != protocol.OperationCompleted {
+			t.Errorf("%s: expected a completed operation, got %+v", tt.subprotocol, hooks.summaries)
+		}
+		hooks.mx.Unlock()
+	}
+}
+
+func TestOperationPanic(t *testing.T) {
+	schema := testutil.Hello(t)
+	log := logger.NewLogWrapper(logger.NoopLogFunc, nil)
+	panics := func(ctx context.Context, info protocol.OperationInfo) (context.Context, map[string]interface{}, error) {
+		panic("operation hook panic")
+	}
+
+	tests := []struct {
+		subprotocol string
+		start       func(tr transport.Transport, hooks protocol.Hooks) error
+		operation   string
+	}{
+		{
+			subprotocol: graphqltransportws.Subprotocol,
+			start: func(tr transport.Transport, hooks protocol.Hooks) error {
+				_, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+					Transport:     tr,
+					Schema:        &schema,
+					Logger:        log,
+					Hooks:         hooks,
+					OperationFunc: panics,
+				})
+				return err
+			},
+			operation: `{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`,
+		},
+		{
+			subprotocol: graphqlws.Subprotocol,
+			start: func(tr transport.Transport, hooks protocol.Hooks) error {
+				_, err := graphqlws.NewConnection(context.Background(), graphqlws.Config{
+					Transport:     tr,
+					Schema:        &schema,
+					Logger:        log,
+					Hooks:         hooks,
+					OperationFunc: panics,
+				})
+				return err
+			},
+			operation: `{"id":"1","type":"start","payload":{"query":"{ hello }"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subprotocol, func(t *testing.T) {
+			hooks := &testHooks{}
+			server, conn := transport.Pipe(tt.subprotocol)
+			if err := tt.start(server, hooks); err != nil {
+				t.Fatal(err)
+			}
+
+			client := testutil.NewClient(t, conn)
+			defer client.Close(transport.CloseNormalClosure)
+
+			client.Send(`{"type":"connection_init"}`)
+			client.Expect(protocol.MsgConnectionAck)
+			client.Send(tt.operation)
+			client.Expect(protocol.MsgError)
+
+			hooks.mx.Lock()
+			defer hooks.mx.Unlock()
+			if len(hooks.summaries) != 1 || hooks.summaries[0].Status != protocol.OperationFailed {
+				t.Errorf("expected a failed operation, got %+v", hooks.summaries)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// OperationInfo describes an http or websocket operation to an
+// OperationFunc
+type OperationInfo struct {
+	// Request is the http request or the websocket upgrade request
+	Request *http.Request
+
+	// Conn is the websocket connection, it is nil for http operations
+	Conn Context
+
+	// ID is the websocket operation id
+	ID string
+
+	// Operation is the operation definition, it is nil if the document
+	// could not be parsed
+	Operation *ast.OperationDefinition
+
+	Params *graphql.Params
+}
+
+// OperationFunc returns the context and root value of an operation. The
+// context passed is derived from the http request or the websocket upgrade
+// request so values added by the server ContextFunc are available on both
+// transports, it also carries the operation span and metrics so a returned
+// context must be derived from it. A nil context or root value keeps the
+// current one and an error rejects the operation
+type OperationFunc func(ctx context.Context, info OperationInfo) (context.Context, map[string]interface{}, error)
+
+// OperationContext returns the context of a websocket operation from the
+// context returned by a ContextValueFunc or OnSubscribe hook. The
+// operation is canceled when the connection context is done and values
+// not found in ctx are looked up in the connection context, so values
+// added by the server ContextFunc remain available when a hook returns a
+// context that is not derived from the connection. A nil ctx uses the
+// connection context
+func OperationContext(conn, ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil || ctx == conn {
+		return context.WithCancel(conn)
+	}
+
+	ctx, cancel := context.WithCancel(linkedContext{Context: ctx, conn: conn})
+	stop := context.AfterFunc(conn, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// linkedContext falls back to the connection context values
+type linkedContext struct {
+	context.Context
+	conn context.Context
+}
+
+func (c linkedContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.conn.Value(key)
+}
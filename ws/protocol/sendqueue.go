@@ -0,0 +1,253 @@
+package protocol
+
+import (
+	"errors"
+	"sync"
+)
+
+// SendQueuePolicy determines how a full send queue handles new messages
+type SendQueuePolicy string
+
+const (
+	// SendQueueBlock blocks the sender until there is room in the queue
+	SendQueueBlock SendQueuePolicy = "block"
+	// SendQueueDropOldest drops the oldest queued result to make room
+	SendQueueDropOldest SendQueuePolicy = "drop_oldest"
+	// SendQueueDropNewest drops the message being sent
+	SendQueueDropNewest SendQueuePolicy = "drop_newest"
+	// SendQueueCoalesce replaces a queued result for the same operation
+	// id with the new one, falling back to dropping the oldest result
+	SendQueueCoalesce SendQueuePolicy = "coalesce"
+	// SendQueueDisconnect disconnects the slow consumer
+	SendQueueDisconnect SendQueuePolicy = "disconnect"
+
+	// DefaultSendQueueSize is the queue size used when none is configured
+	DefaultSendQueueSize = 100
+)
+
+var (
+	// ErrSendQueueFull is returned when a message cannot be queued and
+	// the connection should be closed
+	ErrSendQueueFull = errors.New("send queue full")
+	// ErrSendQueueClosed is returned when sending on a closed queue
+	ErrSendQueueClosed = errors.New("send queue closed")
+)
+
+// SendQueueStats are send queue metrics
+type SendQueueStats struct {
+	Depth     int
+	MaxDepth  int
+	Capacity  int
+	Enqueued  uint64
+	Dropped   uint64
+	Coalesced uint64
+}
+
+// SendQueue is a bounded queue of outbound messages for a single connection
+type SendQueue struct {
+	mx       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []OperationMessage
+	size     int
+	policy   SendQueuePolicy
+	closed   bool
+	stats    SendQueueStats
+}
+
+// NewSendQueue creates a new send queue
+func NewSendQueue(size int, policy SendQueuePolicy) *SendQueue {
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+
+	if policy == "" {
+		policy = SendQueueBlock
+	}
+
+	q := &SendQueue{
+		items:  []OperationMessage{},
+		size:   size,
+		policy: policy,
+	}
+
+	q.notEmpty = sync.NewCond(&q.mx)
+	q.notFull = sync.NewCond(&q.mx)
+	q.stats.Capacity = size
+
+	return q
+}
+
+// droppable returns true if the message can be discarded without
+// breaking the protocol for the client
+func droppable(msg OperationMessage) bool {
+	switch msg.Type {
+	case MsgNext, MsgData, MsgKeepAlive:
+		return true
+	}
+	return false
+}
+
+// coalescable returns true if the message contains a result
+func coalescable(msg OperationMessage) bool {
+	return msg.ID != "" && (msg.Type == MsgNext || msg.Type == MsgData)
+}
+
+// dropOldest removes the oldest droppable message, the caller must hold the lock
+func (q *SendQueue) dropOldest() bool {
+	for i, item := range q.items {
+		if droppable(item) {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			q.stats.Dropped++
+			return true
+		}
+	}
+	return false
+}
+
+// coalesce replaces the newest pending result for the same operation,
+// the caller must hold the lock
+func (q *SendQueue) coalesce(msg OperationMessage) bool {
+	if !coalescable(msg) {
+		return false
+	}
+
+	for i := len(q.items) - 1; i >= 0; i-- {
+		item := q.items[i]
+		if item.ID != msg.ID {
+			continue
+		}
+
+		// results queued before a terminating message cannot be replaced
+		if item.Type != msg.Type {
+			return false
+		}
+
+		q.items[i] = msg
+		q.stats.Coalesced++
+		return true
+	}
+	return false
+}
+
+// enqueue appends the message, the caller must hold the lock
+func (q *SendQueue) enqueue(msg OperationMessage) {
+	q.items = append(q.items, msg)
+	q.stats.Enqueued++
+	if len(q.items) > q.stats.MaxDepth {
+		q.stats.MaxDepth = len(q.items)
+	}
+	q.notEmpty.Signal()
+}
+
+// Push adds a message to the queue applying the queue policy when it is full.
+// ErrSendQueueFull is returned if the slow consumer should be disconnected
+func (q *SendQueue) Push(msg OperationMessage) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.policy == SendQueueBlock {
+		for !q.closed && len(q.items) >= q.size {
+			q.notFull.Wait()
+		}
+	}
+
+	if q.closed {
+		return ErrSendQueueClosed
+	}
+
+	if len(q.items) < q.size {
+		q.enqueue(msg)
+		return nil
+	}
+
+	switch q.policy {
+	case SendQueueCoalesce:
+		if q.coalesce(msg) {
+			return nil
+		}
+		fallthrough
+
+	case SendQueueDropOldest:
+		if q.dropOldest() {
+			q.enqueue(msg)
+			return nil
+		}
+
+		if droppable(msg) {
+			q.stats.Dropped++
+			return nil
+		}
+
+	case SendQueueDropNewest:
+		if droppable(msg) {
+			q.stats.Dropped++
+			return nil
+		}
+
+		// protocol messages take priority over results
+		if q.dropOldest() {
+			q.enqueue(msg)
+			return nil
+		}
+	}
+
+	return ErrSendQueueFull
+}
+
+// Pop removes the next message from the queue, blocking until one is
+// available. ok is false once the queue has been closed
+func (q *SendQueue) Pop() (msg OperationMessage, ok bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	for !q.closed && len(q.items) == 0 {
+		q.notEmpty.Wait()
+	}
+
+	if q.closed {
+		return msg, false
+	}
+
+	msg = q.items[0]
+	q.items[0] = OperationMessage{}
+	q.items = q.items[1:]
+	q.notFull.Signal()
+
+	return msg, true
+}
+
+// Close closes the queue releasing blocked senders and receivers. Messages
+// still pending are discarded and counted as dropped, they are not flushed
+// to the client
+func (q *SendQueue) Close() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+	q.stats.Dropped += uint64(len(q.items))
+	q.items = nil
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// Len returns the current queue depth
+func (q *SendQueue) Len() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return len(q.items)
+}
+
+// Stats returns the queue metrics
+func (q *SendQueue) Stats() SendQueueStats {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.items)
+	return stats
+}
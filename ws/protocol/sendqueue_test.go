@@ -0,0 +1,97 @@
+package protocol_test
+
+import (
+	"testing"
+
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+)
+
+func next(id string, payload interface{}) protocol.OperationMessage {
+	return protocol.OperationMessage{ID: id, Type: protocol.MsgNext, Payload: payload}
+}
+
+func TestSendQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy   protocol.SendQueuePolicy
+		size     int
+		push     []protocol.OperationMessage
+		err      error
+		payloads []interface{}
+	}{
+		{
+			policy:   protocol.SendQueueDropOldest,
+			size:     2,
+			push:     []protocol.OperationMessage{next("1", 1), next("1", 2), next("1", 3)},
+			payloads: []interface{}{2, 3},
+		},
+		{
+			policy:   protocol.SendQueueDropNewest,
+			size:     2,
+			push:     []protocol.OperationMessage{next("1", 1), next("1", 2), next("1", 3)},
+			payloads: []interface{}{1, 2},
+		},
+		{
+			policy:   protocol.SendQueueCoalesce,
+			size:     2,
+			push:     []protocol.OperationMessage{next("1", 1), next("2", 1), next("1", 2)},
+			payloads: []interface{}{2, 1},
+		},
+		{
+			policy:   protocol.SendQueueDisconnect,
+			size:     1,
+			push:     []protocol.OperationMessage{next("1", 1), next("1", 2)},
+			err:      protocol.ErrSendQueueFull,
+			payloads: []interface{}{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			q := protocol.NewSendQueue(tt.size, tt.policy)
+
+			var err error
+			for _, msg := range tt.push {
+				if err = q.Push(msg); err != nil {
+					break
+				}
+			}
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if q.Len() != len(tt.payloads) {
+				t.Fatalf("expected %d queued messages, got %d", len(tt.payloads), q.Len())
+			}
+
+			for _, payload := range tt.payloads {
+				if msg, _ := q.Pop(); msg.Payload != payload {
+					t.Fatalf("expected payload %v, got %v", payload, msg.Payload)
+				}
+			}
+		})
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := protocol.NewSendQueue(1, protocol.SendQueueBlock)
+	q.Push(next("1", 1))
+
+	done := make(chan error)
+	go func() {
+		done <- q.Push(next("1", 2))
+	}()
+
+	q.Close()
+	if err := <-done; err != protocol.ErrSendQueueClosed {
+		t.Fatalf("expected ErrSendQueueClosed, got %v", err)
+	}
+
+	// messages queued before the close are discarded
+	if stats := q.Stats(); stats.Depth != 0 || stats.Dropped != 1 {
+		t.Fatalf("expected the pending message to be dropped, got %+v", stats)
+	}
+
+	if _, ok := q.Pop(); ok {
+		t.Fatal("expected pop on closed queue to fail")
+	}
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// SessionTokenKey is the connection_ack payload key of the session
+	// token and the connection_init payload key used to resume a session
+	SessionTokenKey = "sessionToken"
+
+	// SessionResumedKey is the connection_ack payload key set to true
+	// when a session was resumed
+	SessionResumedKey = "sessionResumed"
+
+	// DefaultSessionGracePeriod is the grace period used when none is
+	// configured
+	DefaultSessionGracePeriod = 30 * time.Second
+)
+
+// Session is a connection that can be resumed after its transport
+// disconnects
+type Session interface {
+	// Expire closes the session once its grace period has elapsed
+	Expire()
+}
+
+// ResumeFunc authorizes a client to resume a detached session, session
+// is the detached connection and c is the new connection after its
+// OnConnect hook ran. Applications bind a session to an identity by
+// storing the identity in the connection values from OnConnect and
+// comparing them here. A rejected client is acknowledged with a new
+// session
+type ResumeFunc func(session, c Context) bool
+
+// Sessions holds the detached sessions until a client resumes them or
+// their grace period elapses
+type Sessions struct {
+	mx          sync.Mutex
+	gracePeriod time.Duration
+	detached    map[string]*detachedSession
+}
+
+type detachedSession struct {
+	session Session
+	timer   *time.Timer
+}
+
+// NewSessions creates a new session registry
+func NewSessions(gracePeriod time.Duration) *Sessions {
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultSessionGracePeriod
+	}
+
+	return &Sessions{
+		gracePeriod: gracePeriod,
+		detached:    map[string]*detachedSession{},
+	}
+}
+
+// NewSessionToken generates a session token
+func NewSessionToken() string {
+	return uuid.NewString()
+}
+
+// Detach holds the session until it is resumed with the token or the
+// grace period elapses
+func (s *Sessions) Detach(token string, session Session) {
+	if s == nil {
+		session.Expire()
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if current, ok := s.detached[token]; ok {
+		current.timer.Stop()
+	}
+
+	d := &detachedSession{session: session}
+	d.timer = time.AfterFunc(s.gracePeriod, func() {
+		s.mx.Lock()
+		current, ok := s.detached[token]
+		if ok && current == d {
+			delete(s.detached, token)
+		}
+		s.mx.Unlock()
+
+		if ok && current == d {
+			session.Expire()
+		}
+	})
+	s.detached[token] = d
+}
+
+// Resume removes and returns the detached session for the token. A
+// session that allow rejects stays detached, a nil allow accepts every
+// session
+func (s *Sessions) Resume(token string, allow func(session Session) bool) (Session, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	s.mx.Lock()
+	d, ok := s.detached[token]
+	s.mx.Unlock()
+	if !ok || (allow != nil && !allow(d.session)) {
+		return nil, false
+	}
+
+	// the session may have expired or been resumed while allow ran
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.detached[token] != d {
+		return nil, false
+	}
+
+	d.timer.Stop()
+	delete(s.detached, token)
+	return d.session, true
+}
+
+// Len returns the number of detached sessions
+func (s *Sessions) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.detached)
+}
@@ -0,0 +1,340 @@
+package protocol_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqlws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/graphql-go/graphql"
+)
+
+// sessionConfig is the configuration of a resumable test connection
+type sessionConfig struct {
+	schema        *graphql.Schema
+	sessions      *protocol.Sessions
+	resumeFunc    protocol.ResumeFunc
+	sendQueueSize int
+}
+
+// sessionProtocol starts resumable connections of a subprotocol
+type sessionProtocol struct {
+	subprotocol string
+	subscribe   string
+	resultType  protocol.MessageType
+	connect     func(tr transport.Transport, config sessionConfig) (protocol.Context, error)
+}
+
+var sessionProtocols = []sessionProtocol{
+	{
+		subprotocol: graphqltransportws.Subprotocol,
+		subscribe:   `{"id":"1","type":"subscribe","payload":{"query":"subscription { ticks }"}}`,
+		resultType:  protocol.MsgNext,
+		connect: func(tr transport.Transport, config sessionConfig) (protocol.Context, error) {
+			return graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+				Transport:       tr,
+				Schema:          config.schema,
+				Logger:          logger.NewLogWrapper(logger.NoopLogFunc, nil),
+				Sessions:        config.sessions,
+				ResumeFunc:      config.resumeFunc,
+				SendQueueSize:   config.sendQueueSize,
+				SendQueuePolicy: protocol.SendQueueDropNewest,
+			})
+		},
+	},
+	{
+		subprotocol: graphqlws.Subprotocol,
+		subscribe:   `{"id":"1","type":"start","payload":{"query":"subscription { ticks }"}}`,
+		resultType:  protocol.MsgData,
+		connect: func(tr transport.Transport, config sessionConfig) (protocol.Context, error) {
+			return graphqlws.NewConnection(context.Background(), graphqlws.Config{
+				Transport:       tr,
+				Schema:          config.schema,
+				Logger:          logger.NewLogWrapper(logger.NoopLogFunc, nil),
+				Sessions:        config.sessions,
+				ResumeFunc:      config.resumeFunc,
+				SendQueueSize:   config.sendQueueSize,
+				SendQueuePolicy: protocol.SendQueueDropNewest,
+				OnConnect: func(c protocol.Context, payload interface{}) (interface{}, error) {
+					return payload, nil
+				},
+			})
+		},
+	},
+}
+
+// sessionTest is a subscription on a resumable connection
+type sessionTest struct {
+	t        *testing.T
+	p        sessionProtocol
+	config   sessionConfig
+	events   chan interface{}
+	conn     protocol.Context
+	client   *testutil.Client
+	token    string
+	sessions *protocol.Sessions
+}
+
+// newSessionTest starts a connection with an active subscription
+func newSessionTest(t *testing.T, p sessionProtocol, config sessionConfig) *sessionTest {
+	events := make(chan interface{})
+	schema := testutil.NewSchema(t, &testutil.Schema{
+		Subscription: graphql.Fields{"ticks": testutil.Events(events)},
+	})
+	config.schema = &schema
+	if config.sessions == nil {
+		config.sessions = protocol.NewSessions(5 * time.Second)
+	}
+
+	s := &sessionTest{t: t, p: p, config: config, events: events, sessions: config.sessions}
+	s.conn, s.client = s.connect()
+	s.token = s.init(`{"type":"connection_init","payload":{"user":"a"}}`, false)
+
+	s.client.Send(p.subscribe)
+	s.waitFor("the subscription to start", func() bool {
+		subs := s.conn.Subscriptions()
+		return len(subs) != 0 && subs[0].OperationName != ""
+	})
+	return s
+}
+
+// waitFor waits until cond returns true failing the test if it does not
+// within the read timeout
+func (s *sessionTest) waitFor(what string, cond func() bool) {
+	s.t.Helper()
+
+	deadline := time.Now().Add(testutil.ReadTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connect starts a connection over a new pipe
+func (s *sessionTest) connect() (protocol.Context, *testutil.Client) {
+	s.t.Helper()
+
+	server, client := transport.Pipe(s.p.subprotocol)
+	c, err := s.p.connect(server, s.config)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	tc := testutil.NewClient(s.t, client)
+	s.t.Cleanup(func() { tc.Close(transport.CloseNormalClosure) })
+	return c, tc
+}
+
+// init sends the connection_init message and returns the session token
+// of the ack
+func (s *sessionTest) init(msg string, resumed bool) string {
+	s.t.Helper()
+
+	s.client.Send(msg)
+	ack := s.client.Expect(protocol.MsgConnectionAck)
+	payload, _ := ack.Payload.(map[string]interface{})
+	if payload[protocol.SessionResumedKey] != resumed {
+		s.t.Fatalf("expected resumed %v, got %v", resumed, ack.Payload)
+	}
+
+	token, _ := payload[protocol.SessionTokenKey].(string)
+	if token == "" {
+		s.t.Fatalf("expected a session token, got %v", ack.Payload)
+	}
+	return token
+}
+
+// detach drops the transport and waits for the session to detach
+func (s *sessionTest) detach() {
+	s.t.Helper()
+
+	s.client.Close(transport.CloseAbnormalClosure)
+	s.waitFor("the session to detach", func() bool {
+		return s.sessions.Len() != 0
+	})
+}
+
+// resume resumes the session on a new connection, returning the
+// connection that handed its transport to the session
+func (s *sessionTest) resume(user string) protocol.Context {
+	s.t.Helper()
+
+	next, client := s.connect()
+	s.client = client
+	s.init(`{"type":"connection_init","payload":{"user":"`+user+`","sessionToken":"`+s.token+`"}}`, true)
+	return next
+}
+
+// send sends an event to the subscription
+func (s *sessionTest) send(tick int) {
+	s.t.Helper()
+
+	select {
+	case s.events <- tick:
+	case <-time.After(testutil.ReadTimeout):
+		s.t.Fatalf("tick %d was not received", tick)
+	}
+}
+
+// expect reads the results of the subscription
+func (s *sessionTest) expect(ticks ...int) {
+	s.t.Helper()
+
+	for _, tick := range ticks {
+		msg := s.client.Expect(s.p.resultType)
+		if msg.ID != "1" || testutil.Data(msg)["ticks"] != float64(tick) {
+			s.t.Fatalf("expected tick %d, got %+v", tick, msg)
+		}
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	for _, p := range sessionProtocols {
+		t.Run(p.subprotocol, func(t *testing.T) {
+			t.Run("replay", func(t *testing.T) {
+				s := newSessionTest(t, p, sessionConfig{})
+
+				// events sent while detached are replayed in order
+				s.detach()
+				s.send(1)
+				s.send(2)
+
+				next := s.resume("a")
+				go s.send(3)
+				s.expect(1, 2, 3)
+
+				// the connection that handed off its transport is stopped
+				if err := next.Send(protocol.OperationMessage{Type: protocol.MsgPing}); err != protocol.ErrConnectionClosed {
+					t.Errorf("expected the handed off connection to be closed, got %v", err)
+				}
+				if s.sessions.Len() != 0 {
+					t.Errorf("expected no detached sessions, got %d", s.sessions.Len())
+				}
+			})
+
+			t.Run("buffer limit", func(t *testing.T) {
+				s := newSessionTest(t, p, sessionConfig{sendQueueSize: 2})
+				s.detach()
+
+				// the write loop holds the first result until the session
+				// is resumed, the queue holds two more and drops the rest
+				enqueued := s.conn.SendQueueStats().Enqueued
+				s.send(1)
+				s.waitFor("the write loop to hold the first result", func() bool {
+					stats := s.conn.SendQueueStats()
+					return stats.Enqueued > enqueued && stats.Depth == 0
+				})
+				for tick := 2; tick <= 4; tick++ {
+					s.send(tick)
+				}
+				s.waitFor("a result to be dropped", func() bool {
+					return s.conn.SendQueueStats().Dropped != 0
+				})
+
+				// the dropped result is not replayed
+				s.resume("a")
+				s.expect(1, 2, 3)
+				go s.send(5)
+				s.expect(5)
+			})
+
+			t.Run("rejected", func(t *testing.T) {
+				s := newSessionTest(t, p, sessionConfig{
+					resumeFunc: func(session, c protocol.Context) bool {
+						return session.ConnectionParams()["user"] == c.ConnectionParams()["user"]
+					},
+				})
+				s.detach()
+
+				// a client presenting the token as another user gets a new
+				// session and the detached session is kept
+				_, client := s.connect()
+				stolen := &sessionTest{t: t, client: client}
+				if token := stolen.init(`{"type":"connection_init","payload":{"user":"b","sessionToken":"`+s.token+`"}}`, false); token == s.token {
+					t.Fatal("expected a new session token")
+				}
+				if s.sessions.Len() != 1 {
+					t.Fatalf("expected the session to stay detached, got %d", s.sessions.Len())
+				}
+
+				s.resume("a")
+				go s.send(1)
+				s.expect(1)
+			})
+
+			t.Run("expired", func(t *testing.T) {
+				s := newSessionTest(t, p, sessionConfig{sessions: protocol.NewSessions(10 * time.Millisecond)})
+
+				// the session is closed once the grace period elapses
+				s.client.Close(transport.CloseAbnormalClosure)
+				s.waitFor("the session to expire", func() bool {
+					return len(s.conn.Subscriptions()) == 0
+				})
+				if s.sessions.Len() != 0 {
+					t.Errorf("expected no detached sessions, got %d", s.sessions.Len())
+				}
+
+				_, s.client = s.connect()
+				s.init(`{"type":"connection_init","payload":{"sessionToken":"`+s.token+`"}}`, false)
+			})
+
+			t.Run("normal closure", func(t *testing.T) {
+				s := newSessionTest(t, p, sessionConfig{})
+
+				// a client that closes normally is not detached
+				s.client.Close(transport.CloseNormalClosure)
+				s.waitFor("the connection to close", func() bool {
+					return len(s.conn.Subscriptions()) == 0
+				})
+				if s.sessions.Len() != 0 {
+					t.Errorf("expected no detached sessions, got %d", s.sessions.Len())
+				}
+			})
+		})
+	}
+}
+
+func TestSessionAckPayload(t *testing.T) {
+	type greeting struct {
+		Greeting string `json:"greeting"`
+	}
+
+	schema := testutil.Hello(t)
+	connect := func(payload interface{}) *testutil.Client {
+		server, client := transport.Pipe(graphqltransportws.Subprotocol)
+		_, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+			Transport: server,
+			Schema:    &schema,
+			Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+			Sessions:  protocol.NewSessions(5 * time.Second),
+			OnConnect: func(c protocol.Context) (interface{}, error) {
+				return payload, nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tc := testutil.NewClient(t, client)
+		t.Cleanup(func() { tc.Close(transport.CloseNormalClosure) })
+		tc.Send(`{"type":"connection_init"}`)
+		return tc
+	}
+
+	// a struct payload is converted to an object holding the token
+	ack := connect(&greeting{Greeting: "hi"}).Expect(protocol.MsgConnectionAck)
+	payload, _ := ack.Payload.(map[string]interface{})
+	if token, _ := payload[protocol.SessionTokenKey].(string); token == "" || payload["greeting"] != "hi" {
+		t.Fatalf("expected the greeting and a session token, got %v", ack.Payload)
+	}
+
+	// a payload that is not an object fails the connection
+	connect("hi").ExpectClose(int(graphqltransportws.InternalServerError))
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SubscriptionTimer enforces the idle timeout and maximum lifetime of a
+// subscription. A zero duration disables the timeout and its channel is
+// nil so that it never fires in a select
+type SubscriptionTimer struct {
+	idle     time.Duration
+	idleT    *time.Timer
+	lifetime *time.Timer
+	start    time.Time
+	last     atomic.Int64
+}
+
+// NewSubscriptionTimer starts a new subscription timer
+func NewSubscriptionTimer(idle, lifetime time.Duration) *SubscriptionTimer {
+	t := &SubscriptionTimer{idle: idle, start: time.Now()}
+
+	if idle > 0 {
+		t.idleT = time.NewTimer(idle)
+	}
+	if lifetime > 0 {
+		t.lifetime = time.NewTimer(lifetime)
+	}
+
+	return t
+}
+
+// Idle fires when the idle timeout may have been exceeded, IdleExpired
+// confirms it
+func (t *SubscriptionTimer) Idle() <-chan time.Time {
+	if t.idleT == nil {
+		return nil
+	}
+	return t.idleT.C
+}
+
+// IdleExpired is called after Idle fires and returns true if no event has
+// been received within the idle timeout. Otherwise the timeout is
+// restarted from the last event
+func (t *SubscriptionTimer) IdleExpired() bool {
+	if t.idleT == nil {
+		return false
+	}
+
+	remaining := t.idle - (time.Since(t.start) - time.Duration(t.last.Load()))
+	if remaining <= 0 {
+		return true
+	}
+
+	t.idleT.Reset(remaining)
+	return false
+}
+
+// Lifetime fires when the subscription has exceeded its lifetime
+func (t *SubscriptionTimer) Lifetime() <-chan time.Time {
+	if t.lifetime == nil {
+		return nil
+	}
+	return t.lifetime.C
+}
+
+// Reset restarts the idle timeout after an event is received. It is safe
+// to call from any goroutine
+func (t *SubscriptionTimer) Reset() {
+	t.last.Store(int64(time.Since(t.start)))
+}
+
+// Stop stops the timers
+func (t *SubscriptionTimer) Stop() {
+	if t.idleT != nil {
+		t.idleT.Stop()
+	}
+	if t.lifetime != nil {
+		t.lifetime.Stop()
+	}
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"sort"
+	"sync"
+)
+
+// Values is a concurrency safe key/value store scoped to a connection
+type Values struct {
+	mx     sync.RWMutex
+	values map[string]interface{}
+}
+
+// NewValues creates a new value store
+func NewValues() *Values {
+	return &Values{
+		values: map[string]interface{}{},
+	}
+}
+
+// Get returns the value stored for the key
+func (v *Values) Get(key string) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+
+	v.mx.RLock()
+	defer v.mx.RUnlock()
+	val, ok := v.values[key]
+	return val, ok
+}
+
+// Set stores a value for the key
+func (v *Values) Set(key string, val interface{}) {
+	if v == nil {
+		return
+	}
+
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	v.values[key] = val
+}
+
+// Delete removes the value stored for the key
+func (v *Values) Delete(key string) {
+	if v == nil {
+		return
+	}
+
+	v.mx.Lock()
+	defer v.mx.Unlock()
+	delete(v.values, key)
+}
+
+// Keys returns the stored keys in sorted order
+func (v *Values) Keys() []string {
+	keys := []string{}
+	if v == nil {
+		return keys
+	}
+
+	v.mx.RLock()
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	v.mx.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package gorillatransport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"github.com/gorilla/websocket"
+)
+
+// CloseDeadline is the deadline for writing the close message
+var CloseDeadline = 100 * time.Millisecond
+
+// Conn adapts a gorilla websocket connection to a transport
+type Conn struct {
+	ws        *websocket.Conn
+	threshold int
+	once      sync.Once
+	closed    chan struct{}
+}
+
+// New creates a new transport from a gorilla websocket connection
+func New(ws *websocket.Conn) *Conn {
+	return &Conn{
+		ws:     ws,
+		closed: make(chan struct{}),
+	}
+}
+
+// WS returns the underlying websocket connection
+func (c *Conn) WS() *websocket.Conn {
+	return c.ws
+}
+
+// Subprotocol implements transport.Transport
+func (c *Conn) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+// ReadMessage implements transport.Transport, the context is not used
+// since gorilla reads are unblocked by closing the connection
+func (c *Conn) ReadMessage(ctx context.Context) ([]byte, error) {
+	_, b, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, c.convertError(err)
+	}
+	return b, nil
+}
+
+// WriteMessage implements transport.Transport
+func (c *Conn) WriteMessage(ctx context.Context, b []byte) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.ws.SetWriteDeadline(deadline)
+	}
+
+	c.ws.EnableWriteCompression(len(b) >= c.threshold)
+	if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		return c.convertError(err)
+	}
+
+	return nil
+}
+
+// SetCompression implements transport.Compressor, it has no effect if
+// compression was not negotiated
+func (c *Conn) SetCompression(level, threshold int) error {
+	c.threshold = threshold
+	if level == 0 {
+		return nil
+	}
+	return c.ws.SetCompressionLevel(level)
+}
+
+// Close implements transport.Transport
+func (c *Conn) Close(code int, reason string) error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+
+		msg := websocket.FormatCloseMessage(code, reason)
+		err = c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(CloseDeadline))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			err = nil
+		}
+
+		if cerr := c.ws.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// convertError converts gorilla close errors to transport errors
+func (c *Conn) convertError(err error) error {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return &transport.CloseError{Code: ce.Code, Reason: ce.Text}
+	}
+
+	select {
+	case <-c.closed:
+		return transport.ErrClosed
+	default:
+	}
+
+	return err
+}
@@ -0,0 +1,10 @@
+module github.com/bhoriuchi/graphql-go-server/ws/transport/nhooyrtransport
+
+go 1.21
+
+require (
+	github.com/bhoriuchi/graphql-go-server v0.0.0-00010101000000-000000000000
+	nhooyr.io/websocket v1.8.17
+)
+
+replace github.com/bhoriuchi/graphql-go-server => ../../..
@@ -0,0 +1,78 @@
+package nhooyrtransport
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+	"nhooyr.io/websocket"
+)
+
+// Conn adapts a nhooyr.io/websocket connection to a transport
+type Conn struct {
+	ws     *websocket.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+// New creates a new transport from a nhooyr.io/websocket connection
+func New(ws *websocket.Conn) *Conn {
+	return &Conn{
+		ws:     ws,
+		closed: make(chan struct{}),
+	}
+}
+
+// WS returns the underlying websocket connection
+func (c *Conn) WS() *websocket.Conn {
+	return c.ws
+}
+
+// Subprotocol implements transport.Transport
+func (c *Conn) Subprotocol() string {
+	return c.ws.Subprotocol()
+}
+
+// ReadMessage implements transport.Transport
+func (c *Conn) ReadMessage(ctx context.Context) ([]byte, error) {
+	_, b, err := c.ws.Read(ctx)
+	if err != nil {
+		return nil, c.convertError(err)
+	}
+	return b, nil
+}
+
+// WriteMessage implements transport.Transport
+func (c *Conn) WriteMessage(ctx context.Context, b []byte) error {
+	if err := c.ws.Write(ctx, websocket.MessageText, b); err != nil {
+		return c.convertError(err)
+	}
+	return nil
+}
+
+// Close implements transport.Transport
+func (c *Conn) Close(code int, reason string) error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.ws.Close(websocket.StatusCode(code), reason)
+	})
+	return err
+}
+
+// convertError converts nhooyr close errors to transport errors
+func (c *Conn) convertError(err error) error {
+	var ce websocket.CloseError
+	if errors.As(err, &ce) {
+		return &transport.CloseError{Code: int(ce.Code), Reason: ce.Reason}
+	}
+
+	select {
+	case <-c.closed:
+		return transport.ErrClosed
+	default:
+	}
+
+	return err
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// pipe is the state shared by both ends of an in-memory transport
+type pipe struct {
+	once     sync.Once
+	done     chan struct{}
+	closedBy *PipeConn
+	code     int
+	reason   string
+}
+
+// PipeConn is one end of an in-memory transport
+type PipeConn struct {
+	p           *pipe
+	subprotocol string
+	in          chan []byte
+	out         chan []byte
+}
+
+// Pipe creates an in-memory transport and returns both ends. Writes
+// block until the peer reads the message or either end is closed
+func Pipe(subprotocol string) (server, client *PipeConn) {
+	p := &pipe{
+		done: make(chan struct{}),
+	}
+
+	a := make(chan []byte)
+	b := make(chan []byte)
+
+	server = &PipeConn{p: p, subprotocol: subprotocol, in: a, out: b}
+	client = &PipeConn{p: p, subprotocol: subprotocol, in: b, out: a}
+	return
+}
+
+// Subprotocol implements Transport
+func (c *PipeConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// ReadMessage implements Transport
+func (c *PipeConn) ReadMessage(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-c.in:
+		return b, nil
+	case <-c.p.done:
+		return nil, c.err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WriteMessage implements Transport
+func (c *PipeConn) WriteMessage(ctx context.Context, b []byte) error {
+	msg := make([]byte, len(b))
+	copy(msg, b)
+
+	select {
+	case c.out <- msg:
+		return nil
+	case <-c.p.done:
+		return c.err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close implements Transport
+func (c *PipeConn) Close(code int, reason string) error {
+	c.p.once.Do(func() {
+		c.p.closedBy = c
+		c.p.code = code
+		c.p.reason = reason
+		close(c.p.done)
+	})
+	return nil
+}
+
+// err returns the error of an operation on the closed pipe
+func (c *PipeConn) err() error {
+	if c.p.closedBy == c {
+		return ErrClosed
+	}
+	return &CloseError{Code: c.p.code, Reason: c.p.reason}
+}
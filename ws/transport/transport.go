@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Close codes used by the transports
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseAbnormalClosure = 1006
+)
+
+// ErrClosed is returned when reading from or writing to a transport
+// that was closed locally
+var ErrClosed = errors.New("transport closed")
+
+// Transport is a message oriented connection the websocket protocols run
+// over. Messages are text messages, ReadMessage is called from a single
+// goroutine and WriteMessage from a single goroutine while Close may be
+// called concurrently with both and more than once
+type Transport interface {
+	// Subprotocol returns the negotiated subprotocol
+	Subprotocol() string
+
+	// ReadMessage blocks until the next message is received. A close
+	// received from the peer is returned as a *CloseError
+	ReadMessage(ctx context.Context) ([]byte, error)
+
+	// WriteMessage writes a message, the context deadline is used as the
+	// write deadline
+	WriteMessage(ctx context.Context, b []byte) error
+
+	// Close sends a close with the code and reason and closes the
+	// transport. Calls after the first have no effect
+	Close(code int, reason string) error
+}
+
+// Compressor is implemented by transports that support per message
+// compression. Messages smaller than threshold bytes are sent
+// uncompressed and a zero level uses the default level
+type Compressor interface {
+	SetCompression(level, threshold int) error
+}
+
+// CloseError is the close code and reason sent by the peer
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+// Error implements the error interface
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("transport closed by peer: %d %s", e.Code, e.Reason)
+}
+
+// IsCloseError returns true if the error is a close from the peer with
+// one of the codes
+func IsCloseError(err error, codes ...int) bool {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+
+	for _, code := range codes {
+		if ce.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bhoriuchi/graphql-go-server/internal/testutil"
+	"github.com/bhoriuchi/graphql-go-server/logger"
+	"github.com/bhoriuchi/graphql-go-server/ws/protocol/graphqltransportws"
+	"github.com/bhoriuchi/graphql-go-server/ws/transport"
+)
+
+func TestPipeClose(t *testing.T) {
+	server, client := transport.Pipe("test")
+	ctx := context.Background()
+
+	go server.WriteMessage(ctx, []byte("hello"))
+	if b, err := client.ReadMessage(ctx); err != nil || string(b) != "hello" {
+		t.Fatalf("expected hello, got %q %v", b, err)
+	}
+
+	server.Close(4400, "bad request")
+	if _, err := client.ReadMessage(ctx); !transport.IsCloseError(err, 4400) {
+		t.Fatalf("expected close error 4400, got %v", err)
+	}
+	if _, err := server.ReadMessage(ctx); err != transport.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestPipeProtocol(t *testing.T) {
+	schema := testutil.Hello(t)
+
+	server, client := transport.Pipe(graphqltransportws.Subprotocol)
+	if _, err := graphqltransportws.NewConnection(context.Background(), graphqltransportws.Config{
+		Transport: server,
+		Schema:    &schema,
+		Logger:    logger.NewLogWrapper(logger.NoopLogFunc, nil),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	read := func() map[string]interface{} {
+		b, err := client.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := map[string]interface{}{}
+		json.Unmarshal(b, &msg)
+		return msg
+	}
+
+	client.WriteMessage(ctx, []byte(`{"type":"connection_init"}`))
+	if msg := read(); msg["type"] != "connection_ack" {
+		t.Fatalf("expected connection_ack, got %v", msg)
+	}
+
+	client.WriteMessage(ctx, []byte(`{"id":"1","type":"subscribe","payload":{"query":"{ hello }"}}`))
+	msg := read()
+	if msg["type"] != "next" {
+		t.Fatalf("expected next, got %v", msg)
+	}
+	if data := msg["payload"].(map[string]interface{})["data"]; data.(map[string]interface{})["hello"] != "world" {
+		t.Fatalf("expected hello world, got %v", data)
+	}
+	if msg := read(); msg["type"] != "complete" {
+		t.Fatalf("expected complete, got %v", msg)
+	}
+
+	client.Close(transport.CloseNormalClosure, "")
+}